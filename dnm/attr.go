@@ -1,6 +1,7 @@
 package dnm
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/flowhealth/goamz/dynamodb"
@@ -8,10 +9,22 @@ import (
 
 type IIndex interface {
 	Where(...dynamodb.AttributeComparison) *dynamodb.Query
+	Query() *TQuery
 }
 
 type IAttr interface {
 	Equals(val string) dynamodb.AttributeComparison
+	NotEquals(val string) dynamodb.AttributeComparison
+	LessThan(val string) dynamodb.AttributeComparison
+	LessOrEqual(val string) dynamodb.AttributeComparison
+	GreaterThan(val string) dynamodb.AttributeComparison
+	GreaterOrEqual(val string) dynamodb.AttributeComparison
+	Between(from, to string) dynamodb.AttributeComparison
+	BeginsWith(prefix string) dynamodb.AttributeComparison
+	Contains(val string) dynamodb.AttributeComparison
+	In(vals ...string) dynamodb.AttributeComparison
+	Exists() dynamodb.AttributeComparison
+	NotExists() dynamodb.AttributeComparison
 	Is(val ...string) dynamodb.Attribute
 	Def() *dynamodb.AttributeDefinitionT
 	From(map[string]*dynamodb.Attribute) string
@@ -24,16 +37,23 @@ type AttributeDefinitionProvider interface {
 type tAttr struct {
 	*dynamodb.AttributeDefinitionT
 	updateAttrTypeInTable func(string)
+	// options of the table of non-key attributes
+	opts *tTableOptions
 }
 
 func (self *tAttr) From(attrMap map[string]*dynamodb.Attribute) string {
-	if val, ok := attrMap[self.Name]; ok {
+	if val, ok := lookupPath(attrMap, self.Name); ok {
 		return val.Value
 	} else {
 		return ""
 	}
 }
 
+// native tells whether the table uses BOOL and NULL types, see NativeTypes
+func (self *tAttr) native() bool {
+	return self.opts != nil && self.opts.nativeTypes
+}
+
 func (self *tAttr) Def() *dynamodb.AttributeDefinitionT {
 	return self.AttributeDefinitionT
 }
@@ -76,8 +96,85 @@ func (self *tAttr) NotEquals(val string) dynamodb.AttributeComparison {
 	}
 }
 
+func (self *tAttr) LessThan(val string) dynamodb.AttributeComparison {
+	return self.compare(dynamodb.COMPARISON_LESS_THAN, val)
+}
+
+func (self *tAttr) LessOrEqual(val string) dynamodb.AttributeComparison {
+	return self.compare(dynamodb.COMPARISON_LESS_THAN_OR_EQUAL, val)
+}
+
+func (self *tAttr) GreaterThan(val string) dynamodb.AttributeComparison {
+	return self.compare(dynamodb.COMPARISON_GREATER_THAN, val)
+}
+
+func (self *tAttr) GreaterOrEqual(val string) dynamodb.AttributeComparison {
+	return self.compare(dynamodb.COMPARISON_GREATER_THAN_OR_EQUAL, val)
+}
+
+// Between holds for values from..to inclusive
+func (self *tAttr) Between(from, to string) dynamodb.AttributeComparison {
+	return self.compare(dynamodb.COMPARISON_BETWEEN, from, to)
+}
+
+func (self *tAttr) BeginsWith(prefix string) dynamodb.AttributeComparison {
+	return self.compare(dynamodb.COMPARISON_BEGINS_WITH, prefix)
+}
+
+// Contains holds for a substring of string and binary attributes and for an
+// element of set attributes
+func (self *tAttr) Contains(val string) dynamodb.AttributeComparison {
+	return self.compare(dynamodb.COMPARISON_CONTAINS, val)
+}
+
+func (self *tAttr) In(vals ...string) dynamodb.AttributeComparison {
+	if len(vals) == 0 {
+		panic("Invalid empty set of values")
+	}
+	return self.compare(dynamodb.COMPARISON_IN, vals...)
+}
+
+func (self *tAttr) Exists() dynamodb.AttributeComparison {
+	return self.compare(dynamodb.COMPARISON_ATTRIBUTE_EXISTS)
+}
+
+func (self *tAttr) NotExists() dynamodb.AttributeComparison {
+	return self.compare(dynamodb.COMPARISON_ATTRIBUTE_DOES_NOT_EXIST)
+}
+
+func (self *tAttr) compare(op string, vals ...string) dynamodb.AttributeComparison {
+	attrs := make([]dynamodb.Attribute, len(vals))
+	for n, v := range vals {
+		attrs[n] = self.value(v)
+	}
+	return dynamodb.AttributeComparison{self.Name, op, attrs}
+}
+
+// value is a scalar comparison argument, an element for set attributes
+func (self *tAttr) value(val string) dynamodb.Attribute {
+	if val == "" {
+		panic("Invalid empty value is not allowed")
+	}
+	typ := self.Type
+	switch typ {
+	case dynamodb.TYPE_STRING_SET:
+		typ = dynamodb.TYPE_STRING
+	case dynamodb.TYPE_NUMBER_SET:
+		typ = dynamodb.TYPE_NUMBER
+	case dynamodb.TYPE_BINARY_SET:
+		typ = dynamodb.TYPE_BINARY
+	}
+	return dynamodb.Attribute{Type: typ, Name: self.Name, Value: val}
+}
+
 func makeAttr(attr *dynamodb.AttributeDefinitionT, typeSetter func(string)) *tAttr {
-	return &tAttr{attr, typeSetter}
+	return &tAttr{attr, typeSetter, nil}
+}
+
+// ExpectMissing is an expected attribute of conditional writes that holds
+// when the item has no such attribute, e.g. the item doesn't exist yet
+func ExpectMissing(attr AttributeDefinitionProvider) dynamodb.Attribute {
+	return dynamodb.Attribute{Type: attr.Def().Type, Name: attr.Def().Name, Exists: "false"}
 }
 
 /*
@@ -99,14 +196,18 @@ type tBoolAttr struct {
 }
 
 func (self *tBoolAttr) Is(val bool) dynamodb.Attribute {
+	if self.native() {
+		return MakeNativeBoolAttr(self.Name, val)
+	}
 	return self.tAttr.Is(FromBool(val))
 }
 
+// From reads both numeric and BOOL values, NULL is the same as missing value
 func (self *tBoolAttr) From(attrMap map[string]*dynamodb.Attribute) (bool, error) {
-	if val := self.tAttr.From(attrMap); val != "" {
-		return ToBool(self.Name, val)
-	} else {
+	if val, ok := lookupPath(attrMap, self.Name); !ok || val.Type == Null || val.Value == "" {
 		return false, AttrNotFoundErr
+	} else {
+		return ToBool(self.Name, val.Value)
 	}
 }
 
@@ -140,6 +241,42 @@ func (self *tBinaryAttr) From(attrMap map[string]*dynamodb.Attribute) ([]byte, e
 	}
 }
 
+func (self *tBinaryAttr) LessThan(val []byte) dynamodb.AttributeComparison {
+	return self.tAttr.LessThan(FromBinary(val))
+}
+
+func (self *tBinaryAttr) LessOrEqual(val []byte) dynamodb.AttributeComparison {
+	return self.tAttr.LessOrEqual(FromBinary(val))
+}
+
+func (self *tBinaryAttr) GreaterThan(val []byte) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterThan(FromBinary(val))
+}
+
+func (self *tBinaryAttr) GreaterOrEqual(val []byte) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterOrEqual(FromBinary(val))
+}
+
+func (self *tBinaryAttr) Between(from, to []byte) dynamodb.AttributeComparison {
+	return self.tAttr.Between(FromBinary(from), FromBinary(to))
+}
+
+func (self *tBinaryAttr) In(vals ...[]byte) dynamodb.AttributeComparison {
+	strs := make([]string, len(vals))
+	for n, v := range vals {
+		strs[n] = FromBinary(v)
+	}
+	return self.tAttr.In(strs...)
+}
+
+func (self *tBinaryAttr) BeginsWith(prefix []byte) dynamodb.AttributeComparison {
+	return self.tAttr.BeginsWith(FromBinary(prefix))
+}
+
+func (self *tBinaryAttr) Contains(val []byte) dynamodb.AttributeComparison {
+	return self.tAttr.Contains(FromBinary(val))
+}
+
 /*
  time.Time attribute serialization/deserialization
 */
@@ -174,6 +311,34 @@ func (self *tTimeTimeAttr) From(attrMap map[string]*dynamodb.Attribute) (*time.T
 	}
 }
 
+func (self *tTimeTimeAttr) LessThan(val time.Time) dynamodb.AttributeComparison {
+	return self.tAttr.LessThan(FromTimeTime(val))
+}
+
+func (self *tTimeTimeAttr) LessOrEqual(val time.Time) dynamodb.AttributeComparison {
+	return self.tAttr.LessOrEqual(FromTimeTime(val))
+}
+
+func (self *tTimeTimeAttr) GreaterThan(val time.Time) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterThan(FromTimeTime(val))
+}
+
+func (self *tTimeTimeAttr) GreaterOrEqual(val time.Time) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterOrEqual(FromTimeTime(val))
+}
+
+func (self *tTimeTimeAttr) Between(from, to time.Time) dynamodb.AttributeComparison {
+	return self.tAttr.Between(FromTimeTime(from), FromTimeTime(to))
+}
+
+func (self *tTimeTimeAttr) In(vals ...time.Time) dynamodb.AttributeComparison {
+	strs := make([]string, len(vals))
+	for n, v := range vals {
+		strs[n] = FromTimeTime(v)
+	}
+	return self.tAttr.In(strs...)
+}
+
 /*
  string attribute serialization/deserialization
 */
@@ -193,12 +358,19 @@ type tStringAttr struct {
 }
 
 func (self *tStringAttr) Is(val string) dynamodb.Attribute {
+	if self.native() && FromString(val) == "" {
+		return MakeNullAttr(self.Name)
+	}
 	return self.tAttr.Is(FromString(val))
 }
 
+// From reads NULL values as empty strings, with native types so is the
+// legacy "NULL" string
 func (self *tStringAttr) From(attrMap map[string]*dynamodb.Attribute) (string, error) {
-	if val := self.tAttr.From(attrMap); val != "" {
-		return ToString(val), nil
+	if val, ok := lookupPath(attrMap, self.Name); ok && (val.Type == Null || (self.native() && val.Value == NullString)) {
+		return "", nil
+	} else if ok && val.Value != "" {
+		return ToString(val.Value), nil
 	} else {
 		return "", AttrNotFoundErr
 	}
@@ -234,6 +406,34 @@ func (self *tFloat32Attr) From(attrMap map[string]*dynamodb.Attribute) (float32,
 	}
 }
 
+func (self *tFloat32Attr) LessThan(val float32) dynamodb.AttributeComparison {
+	return self.tAttr.LessThan(FromFloat32(val))
+}
+
+func (self *tFloat32Attr) LessOrEqual(val float32) dynamodb.AttributeComparison {
+	return self.tAttr.LessOrEqual(FromFloat32(val))
+}
+
+func (self *tFloat32Attr) GreaterThan(val float32) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterThan(FromFloat32(val))
+}
+
+func (self *tFloat32Attr) GreaterOrEqual(val float32) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterOrEqual(FromFloat32(val))
+}
+
+func (self *tFloat32Attr) Between(from, to float32) dynamodb.AttributeComparison {
+	return self.tAttr.Between(FromFloat32(from), FromFloat32(to))
+}
+
+func (self *tFloat32Attr) In(vals ...float32) dynamodb.AttributeComparison {
+	strs := make([]string, len(vals))
+	for n, v := range vals {
+		strs[n] = FromFloat32(v)
+	}
+	return self.tAttr.In(strs...)
+}
+
 /*
  float64 attribute serialization/deserialization
 */
@@ -264,6 +464,34 @@ func (self *tFloat64Attr) From(attrMap map[string]*dynamodb.Attribute) (float64,
 	}
 }
 
+func (self *tFloat64Attr) LessThan(val float64) dynamodb.AttributeComparison {
+	return self.tAttr.LessThan(FromFloat64(val))
+}
+
+func (self *tFloat64Attr) LessOrEqual(val float64) dynamodb.AttributeComparison {
+	return self.tAttr.LessOrEqual(FromFloat64(val))
+}
+
+func (self *tFloat64Attr) GreaterThan(val float64) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterThan(FromFloat64(val))
+}
+
+func (self *tFloat64Attr) GreaterOrEqual(val float64) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterOrEqual(FromFloat64(val))
+}
+
+func (self *tFloat64Attr) Between(from, to float64) dynamodb.AttributeComparison {
+	return self.tAttr.Between(FromFloat64(from), FromFloat64(to))
+}
+
+func (self *tFloat64Attr) In(vals ...float64) dynamodb.AttributeComparison {
+	strs := make([]string, len(vals))
+	for n, v := range vals {
+		strs[n] = FromFloat64(v)
+	}
+	return self.tAttr.In(strs...)
+}
+
 /*
  int attribute serialization/deserialization
 */
@@ -294,6 +522,34 @@ func (self *tIntAttr) From(attrMap map[string]*dynamodb.Attribute) (int, error)
 	}
 }
 
+func (self *tIntAttr) LessThan(val int) dynamodb.AttributeComparison {
+	return self.tAttr.LessThan(FromInt(val))
+}
+
+func (self *tIntAttr) LessOrEqual(val int) dynamodb.AttributeComparison {
+	return self.tAttr.LessOrEqual(FromInt(val))
+}
+
+func (self *tIntAttr) GreaterThan(val int) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterThan(FromInt(val))
+}
+
+func (self *tIntAttr) GreaterOrEqual(val int) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterOrEqual(FromInt(val))
+}
+
+func (self *tIntAttr) Between(from, to int) dynamodb.AttributeComparison {
+	return self.tAttr.Between(FromInt(from), FromInt(to))
+}
+
+func (self *tIntAttr) In(vals ...int) dynamodb.AttributeComparison {
+	strs := make([]string, len(vals))
+	for n, v := range vals {
+		strs[n] = FromInt(v)
+	}
+	return self.tAttr.In(strs...)
+}
+
 /*
  int32 attribute serialization/deserialization
 */
@@ -324,6 +580,34 @@ func (self *tInt32Attr) From(attrMap map[string]*dynamodb.Attribute) (int32, err
 	}
 }
 
+func (self *tInt32Attr) LessThan(val int32) dynamodb.AttributeComparison {
+	return self.tAttr.LessThan(FromInt32(val))
+}
+
+func (self *tInt32Attr) LessOrEqual(val int32) dynamodb.AttributeComparison {
+	return self.tAttr.LessOrEqual(FromInt32(val))
+}
+
+func (self *tInt32Attr) GreaterThan(val int32) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterThan(FromInt32(val))
+}
+
+func (self *tInt32Attr) GreaterOrEqual(val int32) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterOrEqual(FromInt32(val))
+}
+
+func (self *tInt32Attr) Between(from, to int32) dynamodb.AttributeComparison {
+	return self.tAttr.Between(FromInt32(from), FromInt32(to))
+}
+
+func (self *tInt32Attr) In(vals ...int32) dynamodb.AttributeComparison {
+	strs := make([]string, len(vals))
+	for n, v := range vals {
+		strs[n] = FromInt32(v)
+	}
+	return self.tAttr.In(strs...)
+}
+
 /*
  int64 attribute serialization/deserialization
 */
@@ -353,3 +637,210 @@ func (self *tInt64Attr) From(attrMap map[string]*dynamodb.Attribute) (int64, err
 		return 0, AttrNotFoundErr
 	}
 }
+
+func (self *tInt64Attr) LessThan(val int64) dynamodb.AttributeComparison {
+	return self.tAttr.LessThan(FromInt64(val))
+}
+
+func (self *tInt64Attr) LessOrEqual(val int64) dynamodb.AttributeComparison {
+	return self.tAttr.LessOrEqual(FromInt64(val))
+}
+
+func (self *tInt64Attr) GreaterThan(val int64) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterThan(FromInt64(val))
+}
+
+func (self *tInt64Attr) GreaterOrEqual(val int64) dynamodb.AttributeComparison {
+	return self.tAttr.GreaterOrEqual(FromInt64(val))
+}
+
+func (self *tInt64Attr) Between(from, to int64) dynamodb.AttributeComparison {
+	return self.tAttr.Between(FromInt64(from), FromInt64(to))
+}
+
+func (self *tInt64Attr) In(vals ...int64) dynamodb.AttributeComparison {
+	strs := make([]string, len(vals))
+	for n, v := range vals {
+		strs[n] = FromInt64(v)
+	}
+	return self.tAttr.In(strs...)
+}
+
+/*
+ map attribute serialization/deserialization
+*/
+
+// convenience method
+
+func (self *tAttr) AsMap() tMapAttr {
+	self.Type = Map
+	self.updateAttrTypeInTable(Map)
+	return tMapAttr{self}
+}
+
+// serializer
+
+type tMapAttr struct {
+	*tAttr
+}
+
+func (self *tMapAttr) Is(val map[string]interface{}) dynamodb.Attribute {
+	data, err := FromMap(val)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid map value: %s", err))
+	}
+	return self.tAttr.Is(data)
+}
+
+func (self *tMapAttr) From(attrMap map[string]*dynamodb.Attribute) (map[string]interface{}, error) {
+	if val := self.tAttr.From(attrMap); val != "" {
+		return ToMap(self.Name, val)
+	} else {
+		return nil, AttrNotFoundErr
+	}
+}
+
+// Key addresses a value of the map, e.g. for updates and conditions
+func (self *tMapAttr) Key(name string) *TPath {
+	return Path(self).Key(name)
+}
+
+/*
+ list attribute serialization/deserialization
+*/
+
+// convenience method
+
+func (self *tAttr) AsList() tListAttr {
+	self.Type = List
+	self.updateAttrTypeInTable(List)
+	return tListAttr{self}
+}
+
+// serializer
+
+type tListAttr struct {
+	*tAttr
+}
+
+func (self *tListAttr) Is(val []interface{}) dynamodb.Attribute {
+	data, err := FromList(val)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid list value: %s", err))
+	}
+	return self.tAttr.Is(data)
+}
+
+func (self *tListAttr) From(attrMap map[string]*dynamodb.Attribute) ([]interface{}, error) {
+	if val := self.tAttr.From(attrMap); val != "" {
+		return ToList(self.Name, val)
+	} else {
+		return nil, AttrNotFoundErr
+	}
+}
+
+// Index addresses an element of the list, e.g. for updates and conditions
+func (self *tListAttr) Index(n int) *TPath {
+	return Path(self).Index(n)
+}
+
+/*
+ set attributes serialization/deserialization
+*/
+
+// setValues returns elements of the set attribute, AttrNotFoundErr if there is none
+func (self *tAttr) setValues(attrMap map[string]*dynamodb.Attribute) ([]string, error) {
+	val, ok := lookupPath(attrMap, self.Name)
+	if !ok {
+		return nil, AttrNotFoundErr
+	}
+	if val.Type != self.Type {
+		return nil, MakeAttrInvalidErr(self.Name, val.Type)
+	}
+	return val.SetValues, nil
+}
+
+func (self *tAttr) set(vals []string) dynamodb.Attribute {
+	if len(vals) == 0 {
+		panic("Invalid empty set of values")
+	}
+	return self.Is(vals...)
+}
+
+// convenience methods
+
+func (self *tAttr) AsStringSet() tStringSetAttr {
+	self.Type = dynamodb.TYPE_STRING_SET
+	self.updateAttrTypeInTable(dynamodb.TYPE_STRING_SET)
+	return tStringSetAttr{self}
+}
+
+func (self *tAttr) AsIntSet() tIntSetAttr {
+	self.Type = dynamodb.TYPE_NUMBER_SET
+	self.updateAttrTypeInTable(dynamodb.TYPE_NUMBER_SET)
+	return tIntSetAttr{self}
+}
+
+func (self *tAttr) AsBinarySet() tBinarySetAttr {
+	self.Type = dynamodb.TYPE_BINARY_SET
+	self.updateAttrTypeInTable(dynamodb.TYPE_BINARY_SET)
+	return tBinarySetAttr{self}
+}
+
+// serializers
+
+type tStringSetAttr struct {
+	*tAttr
+}
+
+func (self *tStringSetAttr) Is(vals []string) dynamodb.Attribute {
+	return self.set(FromStringSet(vals))
+}
+
+func (self *tStringSetAttr) From(attrMap map[string]*dynamodb.Attribute) ([]string, error) {
+	if vals, err := self.setValues(attrMap); err != nil {
+		return nil, err
+	} else {
+		return ToStringSet(self.Name, vals)
+	}
+}
+
+type tIntSetAttr struct {
+	*tAttr
+}
+
+func (self *tIntSetAttr) Is(vals []int) dynamodb.Attribute {
+	return self.set(FromIntSet(vals))
+}
+
+func (self *tIntSetAttr) From(attrMap map[string]*dynamodb.Attribute) ([]int, error) {
+	if vals, err := self.setValues(attrMap); err != nil {
+		return nil, err
+	} else {
+		return ToIntSet(self.Name, vals)
+	}
+}
+
+func (self *tIntSetAttr) Contains(val int) dynamodb.AttributeComparison {
+	return self.tAttr.Contains(FromInt(val))
+}
+
+type tBinarySetAttr struct {
+	*tAttr
+}
+
+func (self *tBinarySetAttr) Is(vals [][]byte) dynamodb.Attribute {
+	return self.set(FromBinarySet(vals))
+}
+
+func (self *tBinarySetAttr) From(attrMap map[string]*dynamodb.Attribute) ([][]byte, error) {
+	if vals, err := self.setValues(attrMap); err != nil {
+		return nil, err
+	} else {
+		return ToBinarySet(self.Name, vals)
+	}
+}
+
+func (self *tBinarySetAttr) Contains(val []byte) dynamodb.AttributeComparison {
+	return self.tAttr.Contains(FromBinary(val))
+}
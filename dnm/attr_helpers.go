@@ -2,6 +2,7 @@ package dnm
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
@@ -10,7 +11,7 @@ import (
 )
 
 func MakeAttrNotFoundErr(attr string) error {
-	return fmt.Errorf("DeSerialization error: attribute %s not found")
+	return fmt.Errorf("DeSerialization error: attribute %s not found", attr)
 }
 
 func MakeAttrInvalidErr(attr, value string) error {
@@ -26,6 +27,8 @@ const BoolAttrType = dynamodb.TYPE_NUMBER
 const (
 	DynamoBoolTrue  = "1"
 	DynamoBoolFalse = "0"
+	NativeBoolTrue  = "true"
+	NativeBoolFalse = "false"
 )
 
 func FromBool(value bool) string {
@@ -38,10 +41,11 @@ func FromBool(value bool) string {
 	return converted
 }
 
+// ToBool accepts both numeric and BOOL values
 func ToBool(name, val string) (bool, error) {
-	if val == DynamoBoolTrue {
+	if val == DynamoBoolTrue || val == NativeBoolTrue {
 		return true, nil
-	} else if val == DynamoBoolFalse {
+	} else if val == DynamoBoolFalse || val == NativeBoolFalse {
 		return false, nil
 	} else {
 		return false, MakeAttrInvalidErr(name, val)
@@ -52,8 +56,13 @@ func MakeBoolAttr(name string, value bool) dynamodb.Attribute {
 	return *dynamodb.NewNumericAttribute(name, FromBool(value))
 }
 
+// MakeNativeBoolAttr makes an attribute of DynamoDB BOOL type
+func MakeNativeBoolAttr(name string, value bool) dynamodb.Attribute {
+	return dynamodb.Attribute{Type: Bool, Name: name, Value: strconv.FormatBool(value)}
+}
+
 func GetBoolAttr(name string, attrs map[string]*dynamodb.Attribute) (bool, error) {
-	if val, ok := attrs[name]; !ok {
+	if val, ok := attrs[name]; !ok || val.Type == Null {
 		return false, MakeAttrNotFoundErr(name)
 	} else {
 		return ToBool(name, val.Value)
@@ -239,7 +248,7 @@ func GetBinaryAttr(name string, attrs map[string]*dynamodb.Attribute) ([]byte, e
 	if val, ok := attrs[name]; !ok {
 		return nil, MakeAttrNotFoundErr(name)
 	} else {
-		if val.Value == NullString {
+		if val.Type == Null || val.Value == NullString {
 			return []byte{}, nil
 		} else {
 			return ToBinary(name, val.Value)
@@ -291,7 +300,7 @@ func ToTimeTimeNano(name, value string) (time.Time, error) {
 	if timestamp, err := strconv.ParseInt(value, 10, 64); err != nil {
 		return time.Time{}, MakeAttrInvalidErr(name, value)
 	} else {
-		return time.Unix(timestamp, 0), nil
+		return time.Unix(0, timestamp), nil
 	}
 }
 
@@ -335,6 +344,8 @@ func ToString(val string) string {
 func GetStringAttr(name string, attrs map[string]*dynamodb.Attribute) (string, error) {
 	if val, ok := attrs[name]; !ok {
 		return "", MakeAttrNotFoundErr(name)
+	} else if val.Type == Null {
+		return "", nil
 	} else {
 		return FromString(val.Value), nil
 	}
@@ -343,3 +354,216 @@ func GetStringAttr(name string, attrs map[string]*dynamodb.Attribute) (string, e
 func MakeStringAttr(name string, value string) dynamodb.Attribute {
 	return *dynamodb.NewStringAttribute(name, ToString(value))
 }
+
+// MakeNullAttr makes an attribute of DynamoDB NULL type, the native
+// counterpart of the "NULL" string
+func MakeNullAttr(name string) dynamodb.Attribute {
+	return dynamodb.Attribute{Type: Null, Name: name, Value: NativeBoolTrue}
+}
+
+/**
+Map, values are converted as described in document.go
+*/
+
+const MapAttrType = Map
+
+func FromMap(val map[string]interface{}) (string, error) {
+	wv, err := toDocValue(val)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(wv[Map])
+	return string(data), err
+}
+
+func ToMap(name, val string) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(val), &m); err != nil || m == nil {
+		return nil, MakeAttrInvalidErr(name, val)
+	}
+	res, err := fromDocMap(m)
+	if err != nil {
+		return nil, MakeAttrInvalidErr(name, val)
+	}
+	return res, nil
+}
+
+func MakeMapAttr(name string, value map[string]interface{}) (dynamodb.Attribute, error) {
+	val, err := FromMap(value)
+	return dynamodb.Attribute{Type: Map, Name: name, Value: val}, err
+}
+
+func GetMapAttr(name string, attrs map[string]*dynamodb.Attribute) (map[string]interface{}, error) {
+	if val, ok := attrs[name]; !ok {
+		return nil, MakeAttrNotFoundErr(name)
+	} else {
+		return ToMap(name, val.Value)
+	}
+}
+
+/**
+List, values are converted as described in document.go
+*/
+
+const ListAttrType = List
+
+func FromList(val []interface{}) (string, error) {
+	wv, err := toDocValue(val)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(wv[List])
+	return string(data), err
+}
+
+func ToList(name, val string) ([]interface{}, error) {
+	var l []interface{}
+	if err := json.Unmarshal([]byte(val), &l); err != nil || l == nil {
+		return nil, MakeAttrInvalidErr(name, val)
+	}
+	res, err := fromDocList(l)
+	if err != nil {
+		return nil, MakeAttrInvalidErr(name, val)
+	}
+	return res, nil
+}
+
+func MakeListAttr(name string, value []interface{}) (dynamodb.Attribute, error) {
+	val, err := FromList(value)
+	return dynamodb.Attribute{Type: List, Name: name, Value: val}, err
+}
+
+func GetListAttr(name string, attrs map[string]*dynamodb.Attribute) ([]interface{}, error) {
+	if val, ok := attrs[name]; !ok {
+		return nil, MakeAttrNotFoundErr(name)
+	} else {
+		return ToList(name, val.Value)
+	}
+}
+
+/**
+Sets, DynamoDB rejects empty sets and sets with duplicate elements, From
+converters drop duplicates
+*/
+
+func dedupSet(typ string, vals []string) []string {
+	res := make([]string, 0, len(vals))
+	for _, v := range vals {
+		if !setContains(typ, res, v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func getSetAttr(name, typ string, attrs map[string]*dynamodb.Attribute) ([]string, error) {
+	if val, ok := attrs[name]; !ok {
+		return nil, MakeAttrNotFoundErr(name)
+	} else if val.Type != typ {
+		return nil, MakeAttrInvalidErr(name, val.Type)
+	} else {
+		return val.SetValues, nil
+	}
+}
+
+/**
+String set
+*/
+
+const StringSetAttrType = dynamodb.TYPE_STRING_SET
+
+func FromStringSet(vals []string) []string {
+	return dedupSet(dynamodb.TYPE_STRING_SET, vals)
+}
+
+func ToStringSet(name string, vals []string) ([]string, error) {
+	return vals, nil
+}
+
+func MakeStringSetAttr(name string, value []string) dynamodb.Attribute {
+	return dynamodb.Attribute{Type: dynamodb.TYPE_STRING_SET, Name: name, SetValues: FromStringSet(value)}
+}
+
+func GetStringSetAttr(name string, attrs map[string]*dynamodb.Attribute) ([]string, error) {
+	if vals, err := getSetAttr(name, dynamodb.TYPE_STRING_SET, attrs); err != nil {
+		return nil, err
+	} else {
+		return ToStringSet(name, vals)
+	}
+}
+
+/**
+Int set
+*/
+
+const IntSetAttrType = dynamodb.TYPE_NUMBER_SET
+
+func FromIntSet(vals []int) []string {
+	strs := make([]string, len(vals))
+	for n, v := range vals {
+		strs[n] = FromInt(v)
+	}
+	return dedupSet(dynamodb.TYPE_NUMBER_SET, strs)
+}
+
+func ToIntSet(name string, vals []string) ([]int, error) {
+	res := make([]int, len(vals))
+	for n, v := range vals {
+		i, err := ToInt(name, v)
+		if err != nil {
+			return nil, err
+		}
+		res[n] = i
+	}
+	return res, nil
+}
+
+func MakeIntSetAttr(name string, value []int) dynamodb.Attribute {
+	return dynamodb.Attribute{Type: dynamodb.TYPE_NUMBER_SET, Name: name, SetValues: FromIntSet(value)}
+}
+
+func GetIntSetAttr(name string, attrs map[string]*dynamodb.Attribute) ([]int, error) {
+	if vals, err := getSetAttr(name, dynamodb.TYPE_NUMBER_SET, attrs); err != nil {
+		return nil, err
+	} else {
+		return ToIntSet(name, vals)
+	}
+}
+
+/**
+Binary set
+*/
+
+const BinarySetAttrType = dynamodb.TYPE_BINARY_SET
+
+func FromBinarySet(vals [][]byte) []string {
+	strs := make([]string, len(vals))
+	for n, v := range vals {
+		strs[n] = FromBinary(v)
+	}
+	return dedupSet(dynamodb.TYPE_BINARY_SET, strs)
+}
+
+func ToBinarySet(name string, vals []string) ([][]byte, error) {
+	res := make([][]byte, len(vals))
+	for n, v := range vals {
+		b, err := ToBinary(name, v)
+		if err != nil {
+			return nil, MakeAttrInvalidErr(name, v)
+		}
+		res[n] = b
+	}
+	return res, nil
+}
+
+func MakeBinarySetAttr(name string, value [][]byte) dynamodb.Attribute {
+	return dynamodb.Attribute{Type: dynamodb.TYPE_BINARY_SET, Name: name, SetValues: FromBinarySet(value)}
+}
+
+func GetBinarySetAttr(name string, attrs map[string]*dynamodb.Attribute) ([][]byte, error) {
+	if vals, err := getSetAttr(name, dynamodb.TYPE_BINARY_SET, attrs); err != nil {
+		return nil, err
+	} else {
+		return ToBinarySet(name, vals)
+	}
+}
@@ -0,0 +1,95 @@
+package dnm_test
+
+import (
+	"time"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Comparison helpers", func() {
+	var (
+		forumName dnm.IAttr
+		pkQuery   dnm.IIndex
+	)
+	t0 := time.Unix(1400000000, 0)
+	// typed attributes are unexported, they are used through their methods
+	var createdAttr interface {
+		Is(time.Time) dynamodb.Attribute
+		Between(from, to time.Time) dynamodb.AttributeComparison
+		GreaterThan(time.Time) dynamodb.AttributeComparison
+		In(...time.Time) dynamodb.AttributeComparison
+	}
+	var (
+		count interface {
+			LessOrEqual(int) dynamodb.AttributeComparison
+		}
+		blob interface {
+			BeginsWith([]byte) dynamodb.AttributeComparison
+		}
+		tags    dnm.IAttr
+		subject dnm.IAttr
+	)
+	d := dnm.Describe("Threads", func(t dnm.ITable) {
+		forumName = t.KeyAttr("ForumName", dnm.String)
+		c := t.KeyAttr("Created").AsTimeTime()
+		createdAttr = &c
+		n := t.NonKeyAttr("Count").AsInt()
+		count = &n
+		b := t.NonKeyAttr("Blob").AsBinary()
+		blob = &b
+		tags = t.NonKeyAttr("Tags", dynamodb.TYPE_STRING_SET)
+		subject = t.NonKeyAttr("Subject", dnm.String)
+		pk := t.PrimaryKey()
+		pk.Hash(forumName)
+		pk.Range(c)
+		pkQuery = pk
+	})
+
+	It("should serialize typed values", func() {
+		c := createdAttr.Between(t0, t0.Add(time.Hour))
+		Expect(c.ComparisonOperator).To(Equal(dynamodb.COMPARISON_BETWEEN))
+		Expect(c.AttributeValueList).To(Equal([]dynamodb.Attribute{createdAttr.Is(t0), createdAttr.Is(t0.Add(time.Hour))}))
+
+		c = count.LessOrEqual(5)
+		Expect(c.ComparisonOperator).To(Equal(dynamodb.COMPARISON_LESS_THAN_OR_EQUAL))
+		Expect(c.AttributeValueList[0].Type).To(Equal(dnm.Number))
+		Expect(c.AttributeValueList[0].Value).To(Equal("5"))
+
+		c = blob.BeginsWith([]byte("ab"))
+		Expect(c.AttributeValueList[0].Type).To(Equal(dnm.Binary))
+		Expect(c.AttributeValueList[0].Value).To(Equal(dnm.FromBinary([]byte("ab"))))
+
+		Expect(createdAttr.In(t0, t0.Add(time.Second)).AttributeValueList).To(HaveLen(2))
+		Expect(subject.Exists().AttributeValueList).To(BeEmpty())
+	})
+
+	It("should compare set attributes with elements", func() {
+		c := tags.Contains("go")
+		Expect(c.AttributeValueList).To(Equal([]dynamodb.Attribute{{Type: dynamodb.TYPE_STRING, Name: "Tags", Value: "go"}}))
+	})
+
+	It("should evaluate comparisons in queries", func() {
+		store := dnm.MakeMemStore(&d)
+		for n := 0; n < 5; n++ {
+			attrs := []dynamodb.Attribute{forumName.Is("go"), createdAttr.Is(t0.Add(time.Duration(n) * time.Hour))}
+			if n%2 == 0 {
+				attrs = append(attrs, subject.Is("post"))
+			}
+			Expect(store.Save(attrs...)).To(BeNil())
+		}
+		items, err := store.Find(pkQuery.Query().Where(forumName.Equals("go"), createdAttr.Between(t0.Add(time.Hour), t0.Add(3*time.Hour))))
+		Expect(err).To(BeNil())
+		Expect(items).To(HaveLen(3))
+
+		items, err = store.Find(pkQuery.Query().Where(forumName.Equals("go"), createdAttr.GreaterThan(t0)).Filter(subject.NotExists()))
+		Expect(err).To(BeNil())
+		Expect(items).To(HaveLen(2))
+
+		items, err = store.Find(pkQuery.Query().Where(forumName.Equals("go")).Filter(subject.BeginsWith("po"), subject.In("post", "reply")))
+		Expect(err).To(BeNil())
+		Expect(items).To(HaveLen(3))
+	})
+})
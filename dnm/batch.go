@@ -0,0 +1,294 @@
+package dnm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+const (
+	BatchGetLimit   = 100
+	BatchWriteLimit = 25
+)
+
+// TWriteRequest is a single put or delete of a batch write
+type TWriteRequest struct {
+	Put    []dynamodb.Attribute
+	Delete *dynamodb.Key
+}
+
+func MakePutRequest(attrs ...dynamodb.Attribute) TWriteRequest {
+	return TWriteRequest{Put: attrs}
+}
+
+func MakeDeleteRequest(key *dynamodb.Key) TWriteRequest {
+	return TWriteRequest{Delete: key}
+}
+
+type tWireWriteRequest struct {
+	PutRequest *struct {
+		Item tWireItem
+	} `json:",omitempty"`
+	DeleteRequest *struct {
+		Key tWireItem
+	} `json:",omitempty"`
+}
+
+// batchErrors returns nil when every item of the batch succeeded
+func batchErrors(errs []*TError) []*TError {
+	for _, v := range errs {
+		if v != nil {
+			return errs
+		}
+	}
+	return nil
+}
+
+/**
+BatchGet
+*/
+
+// BatchGet returns items and errors in order of keys, missing items are reported
+// with NotFoundErr and keys DynamoDB kept unprocessed after all attempts of
+// the retry policy with BatchUnprocessedErr. Errors are nil when every item was found.
+func (self *TStore) BatchGet(keys []dynamodb.Key) ([]map[string]*dynamodb.Attribute, []*TError) {
+	items := make([]map[string]*dynamodb.Attribute, len(keys))
+	errs := make([]*TError, len(keys))
+	for start := 0; start < len(keys); start += BatchGetLimit {
+		end := start + BatchGetLimit
+		if end > len(keys) {
+			end = len(keys)
+		}
+		self.batchGetChunk(keys, start, end, items, errs)
+	}
+	for n := range keys {
+		if items[n] == nil && errs[n] == nil {
+			errs[n] = self.makeError(NotFoundErr, "BatchGet", dynamodb.ErrNotFound)
+		}
+	}
+	return items, batchErrors(errs)
+}
+
+func (self *TStore) batchGetChunk(keys []dynamodb.Key, start, end int, items []map[string]*dynamodb.Attribute, errs []*TError) {
+	tableName := self.tableDesc.TableName
+	pending := map[string][]int{}
+	wireKeys := []tWireItem{}
+	for n := start; n < end; n++ {
+		id := canonicalKeyId(self.tableDesc, keys[n])
+		if _, ok := pending[id]; !ok {
+			wireKeys = append(wireKeys, toWireItem(tableKeyItem(self.tableDesc, &keys[n])))
+		}
+		pending[id] = append(pending[id], n)
+	}
+	policy := self.cfg.Retry
+	for attempt := 0; len(wireKeys) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt >= policy.attempts() {
+				break
+			}
+			log.WithFields(log.Fields{
+				LogTable:       tableName,
+				LogAttempt:     attempt,
+				LogUnprocessed: len(wireKeys),
+			}).Debug("Resubmitting unprocessed batch keys")
+			if err := self.sleep(policy.delay(attempt)); err != nil {
+				self.failKeys(wireKeys, pending, errs, self.makeError(LookupErr, "BatchGet", err))
+				return
+			}
+		}
+		req := map[string]interface{}{
+			"RequestItems": map[string]interface{}{
+				tableName: map[string]interface{}{"Keys": wireKeys},
+			},
+		}
+		var resp struct {
+			Responses       map[string][]tWireItem
+			UnprocessedKeys map[string]struct {
+				Keys []tWireItem
+			}
+		}
+		if err := self.rpc("BatchGetItem", req, &resp); err != nil {
+			log.WithFields(log.Fields{
+				LogTable:      tableName,
+				fhlog.FHError: err.Error(),
+			}).Error("Error in BatchGet()")
+			self.failKeys(wireKeys, pending, errs, self.makeError(LookupErr, "BatchGet", err))
+			return
+		}
+		now := time.Now()
+		for _, wi := range resp.Responses[tableName] {
+			item := fromWireItem(wi)
+			if self.opts.expired(item, now) {
+				continue
+			}
+			for _, n := range pending[canonicalKeyId(self.tableDesc, itemTableKey(self.tableDesc, item))] {
+				items[n] = copyItem(item)
+			}
+		}
+		wireKeys = resp.UnprocessedKeys[tableName].Keys
+	}
+	err := fmt.Errorf("key left unprocessed after %d attempts", policy.attempts())
+	self.failKeys(wireKeys, pending, errs, self.makeError(BatchUnprocessedErr, "BatchGet", err))
+}
+
+func (self *TStore) failKeys(wireKeys []tWireItem, pending map[string][]int, errs []*TError, tErr *TError) {
+	for _, wk := range wireKeys {
+		for _, n := range pending[canonicalKeyId(self.tableDesc, itemTableKey(self.tableDesc, fromWireItem(wk)))] {
+			errs[n] = tErr
+		}
+	}
+}
+
+/**
+BatchWrite
+*/
+
+// BatchWrite returns errors in order of requests, nil when every request succeeded
+func (self *TStore) BatchWrite(reqs ...TWriteRequest) []*TError {
+	errs := make([]*TError, len(reqs))
+	if err := self.opts.unversioned(); err != nil {
+		for n := range errs {
+			errs[n] = self.makeError(NotSupportedErr, "BatchWrite", err)
+		}
+		return errs
+	}
+	for start := 0; start < len(reqs); start += BatchWriteLimit {
+		end := start + BatchWriteLimit
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+		self.batchWriteChunk(reqs, start, end, errs)
+	}
+	return batchErrors(errs)
+}
+
+func (self *TStore) wireWriteRequestKey(wr *tWireWriteRequest) dynamodb.Key {
+	if wr.PutRequest != nil {
+		return itemTableKey(self.tableDesc, fromWireItem(wr.PutRequest.Item))
+	}
+	return itemTableKey(self.tableDesc, fromWireItem(wr.DeleteRequest.Key))
+}
+
+func (self *TStore) batchWriteChunk(reqs []TWriteRequest, start, end int, errs []*TError) {
+	tableName := self.tableDesc.TableName
+	pending := map[string][]int{}
+	slots := map[string]int{}
+	wireReqs := []*tWireWriteRequest{}
+	for n := start; n < end; n++ {
+		wr := &tWireWriteRequest{}
+		switch {
+		case reqs[n].Put != nil:
+			item := makeItem(reqs[n].Put)
+			if err := validateItemKey(self.tableDesc, item); err != nil {
+				errs[n] = self.makeError(SaveErr, "BatchWrite", err)
+				continue
+			}
+			wr.PutRequest = &struct{ Item tWireItem }{toWireItem(item)}
+		case reqs[n].Delete != nil:
+			if err := validateKey(self.tableDesc, reqs[n].Delete); err != nil {
+				errs[n] = self.makeError(DeleteErr, "BatchWrite", err)
+				continue
+			}
+			wr.DeleteRequest = &struct{ Key tWireItem }{toWireItem(tableKeyItem(self.tableDesc, reqs[n].Delete))}
+		default:
+			errs[n] = self.makeError(SaveErr, "BatchWrite", fmt.Errorf("write request has neither put nor delete"))
+			continue
+		}
+		// DynamoDB rejects a batch touching one key twice, the last request wins
+		id := canonicalKeyId(self.tableDesc, self.wireWriteRequestKey(wr))
+		pending[id] = append(pending[id], n)
+		if slot, ok := slots[id]; ok {
+			wireReqs[slot] = wr
+			continue
+		}
+		slots[id] = len(wireReqs)
+		wireReqs = append(wireReqs, wr)
+	}
+	policy := self.cfg.Retry
+	for attempt := 0; len(wireReqs) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt >= policy.attempts() {
+				break
+			}
+			log.WithFields(log.Fields{
+				LogTable:       tableName,
+				LogAttempt:     attempt,
+				LogUnprocessed: len(wireReqs),
+			}).Debug("Resubmitting unprocessed batch items")
+			if err := self.sleep(policy.delay(attempt)); err != nil {
+				self.failWrites(reqs, wireReqs, pending, errs, err)
+				return
+			}
+		}
+		req := map[string]interface{}{
+			"RequestItems": map[string]interface{}{tableName: wireReqs},
+		}
+		var resp struct {
+			UnprocessedItems map[string][]*tWireWriteRequest
+		}
+		if err := self.rpc("BatchWriteItem", req, &resp); err != nil {
+			log.WithFields(log.Fields{
+				LogTable:      tableName,
+				fhlog.FHError: err.Error(),
+			}).Error("Error in BatchWrite()")
+			self.failWrites(reqs, wireReqs, pending, errs, err)
+			return
+		}
+		wireReqs = resp.UnprocessedItems[tableName]
+	}
+	for _, wr := range wireReqs {
+		for _, n := range pending[canonicalKeyId(self.tableDesc, self.wireWriteRequestKey(wr))] {
+			errs[n] = self.makeError(BatchUnprocessedErr, "BatchWrite", fmt.Errorf("item left unprocessed after %d attempts", policy.attempts()))
+		}
+	}
+}
+
+func (self *TStore) failWrites(reqs []TWriteRequest, wireReqs []*tWireWriteRequest, pending map[string][]int, errs []*TError, err error) {
+	for _, wr := range wireReqs {
+		for _, n := range pending[canonicalKeyId(self.tableDesc, self.wireWriteRequestKey(wr))] {
+			if reqs[n].Put != nil {
+				errs[n] = self.makeError(SaveErr, "BatchWrite", err)
+			} else {
+				errs[n] = self.makeError(DeleteErr, "BatchWrite", err)
+			}
+		}
+	}
+}
+
+/**
+In-memory batches, applied item by item
+*/
+
+func (self *TMemStore) BatchGet(keys []dynamodb.Key) ([]map[string]*dynamodb.Attribute, []*TError) {
+	items := make([]map[string]*dynamodb.Attribute, len(keys))
+	errs := make([]*TError, len(keys))
+	for n := range keys {
+		items[n], errs[n] = self.Get(&keys[n])
+	}
+	return items, batchErrors(errs)
+}
+
+func (self *TMemStore) BatchWrite(reqs ...TWriteRequest) []*TError {
+	errs := make([]*TError, len(reqs))
+	vErr := self.opts.unversioned()
+	for n, v := range reqs {
+		switch {
+		case vErr != nil:
+			errs[n] = self.makeError(NotSupportedErr, "BatchWrite", vErr)
+		case v.Put != nil:
+			errs[n] = self.put(v.Put, nil, nil)
+		case v.Delete != nil:
+			if err := validateKey(self.tableDesc, v.Delete); err != nil {
+				errs[n] = self.makeError(DeleteErr, "BatchWrite", err)
+				continue
+			}
+			errs[n] = self.remove(v.Delete, nil, nil)
+		default:
+			errs[n] = self.makeError(SaveErr, "BatchWrite", fmt.Errorf("write request has neither put nor delete"))
+		}
+	}
+	return batchErrors(errs)
+}
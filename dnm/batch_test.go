@@ -0,0 +1,235 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type tFakeKey map[string]map[string]string
+
+// fakeBatchServer answers batch requests, leaving the last key or item of
+// every first submission unprocessed
+type tFakeBatchServer struct {
+	lock     sync.Mutex
+	requests []string
+	sizes    []int
+	users    []string
+	seen     map[string]bool
+}
+
+func (self *tFakeBatchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	target := r.Header.Get("X-Amz-Target")
+	self.requests = append(self.requests, target)
+	var body map[string]map[string]json.RawMessage
+	json.NewDecoder(r.Body).Decode(&body)
+	switch {
+	case strings.HasSuffix(target, "BatchGetItem"):
+		var req struct{ Keys []tFakeKey }
+		json.Unmarshal(body["RequestItems"]["Sessions"], &req)
+		self.sizes = append(self.sizes, len(req.Keys))
+		found, unprocessed := []tFakeKey{}, []tFakeKey{}
+		for n, k := range req.Keys {
+			id := k["Id"]["S"]
+			if n == len(req.Keys)-1 && !self.seen[id] {
+				self.seen[id] = true
+				unprocessed = append(unprocessed, k)
+			} else if id != "missing" {
+				found = append(found, tFakeKey{"Id": k["Id"], "UserId": {"S": "user:" + id}})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Responses":       map[string]interface{}{"Sessions": found},
+			"UnprocessedKeys": map[string]interface{}{"Sessions": map[string]interface{}{"Keys": unprocessed}},
+		})
+	case strings.HasSuffix(target, "BatchWriteItem"):
+		var reqs []map[string]map[string]tFakeKey
+		json.Unmarshal(body["RequestItems"]["Sessions"], &reqs)
+		self.sizes = append(self.sizes, len(reqs))
+		for _, v := range reqs {
+			if put, ok := v["PutRequest"]; ok {
+				self.users = append(self.users, put["Item"]["UserId"]["S"])
+			}
+		}
+		unprocessed := []interface{}{}
+		last := reqs[len(reqs)-1]
+		id := last["PutRequest"]["Item"]["Id"]["S"] + last["DeleteRequest"]["Key"]["Id"]["S"]
+		if !self.seen[id] {
+			self.seen[id] = true
+			unprocessed = append(unprocessed, last)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"UnprocessedItems": map[string]interface{}{"Sessions": unprocessed},
+		})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ValidationException","message":"unexpected"}`)
+	}
+}
+
+var _ = Describe("Batch", func() {
+	var (
+		id, userId dnm.IAttr
+		pk         dnm.IKeyFactory
+	)
+	d := dnm.Describe("Sessions", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		userId = t.NonKeyAttr("UserId", dnm.String)
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+	})
+	makeKeys := func(ids ...string) []dynamodb.Key {
+		keys := []dynamodb.Key{}
+		for _, v := range ids {
+			keys = append(keys, pk.Key(id.Is(v)))
+		}
+		return keys
+	}
+
+	Context("TStore", func() {
+		var (
+			fake   *tFakeBatchServer
+			server *httptest.Server
+			cfg    *dnm.TStoreConfig
+			store  dnm.IStore
+		)
+		BeforeEach(func() {
+			fake = &tFakeBatchServer{seen: map[string]bool{}}
+			server, cfg = fakeEndpoint(fake)
+			var err *dnm.TError
+			store, err = dnm.MakeStore(&d, cfg)
+			Expect(err).To(BeNil())
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should chunk keys and resubmit unprocessed ones", func() {
+			ids := []string{}
+			for n := 0; n < 150; n++ {
+				ids = append(ids, fmt.Sprintf("sid:%d", n))
+			}
+			items, errs := store.BatchGet(makeKeys(ids...))
+			Expect(errs).To(BeNil())
+			Expect(items).To(HaveLen(150))
+			for n, v := range items {
+				Expect(userId.From(v)).To(Equal("user:" + ids[n]))
+			}
+			Expect(fake.sizes).To(Equal([]int{100, 1, 50, 1}))
+		})
+
+		It("should resubmit unprocessed keys by the retry policy of the store", func() {
+			cfg.Retry = dnm.MakeNoRetryPolicy()
+			single, _ := dnm.MakeStore(&d, cfg)
+			_, errs := single.BatchGet(makeKeys("sid:1", "sid:2"))
+			Expect(errs[0]).To(BeNil())
+			Expect(errs[1].Is(dnm.BatchUnprocessedErr)).To(BeTrue())
+			errs = single.BatchWrite(dnm.MakePutRequest(id.Is("sid:3"), userId.Is("uid:1")))
+			Expect(errs[0].Is(dnm.BatchUnprocessedErr)).To(BeTrue())
+			Expect(fake.sizes).To(Equal([]int{2, 1}))
+		})
+
+		It("should report missing keys per item", func() {
+			items, errs := store.BatchGet(makeKeys("missing", "sid:1"))
+			Expect(items[0]).To(BeNil())
+			Expect(errs).To(HaveLen(2))
+			Expect(errs[0].IsNotFound()).To(BeTrue())
+			Expect(errs[1]).To(BeNil())
+		})
+
+		It("should chunk writes and resubmit unprocessed ones", func() {
+			reqs := []dnm.TWriteRequest{}
+			for n := 0; n < 30; n++ {
+				reqs = append(reqs, dnm.MakePutRequest(id.Is(fmt.Sprintf("sid:%d", n)), userId.Is("uid:1")))
+			}
+			key := pk.Key(id.Is("sid:gone"))
+			reqs = append(reqs, dnm.MakeDeleteRequest(&key))
+			Expect(store.BatchWrite(reqs...)).To(BeNil())
+			Expect(fake.sizes).To(Equal([]int{25, 1, 6, 1}))
+		})
+
+		It("should collapse writes of one key in a batch", func() {
+			Expect(store.BatchWrite(
+				dnm.MakePutRequest(id.Is("sid:1"), userId.Is("uid:1")),
+				dnm.MakePutRequest(id.Is("sid:2"), userId.Is("uid:2")),
+				dnm.MakePutRequest(id.Is("sid:1"), userId.Is("uid:3")),
+			)).To(BeNil())
+			Expect(fake.sizes).To(Equal([]int{2, 1}))
+			Expect(fake.users).To(Equal([]string{"uid:3", "uid:2", "uid:2"}))
+		})
+
+		It("should report invalid requests per item", func() {
+			errs := store.BatchWrite(dnm.MakePutRequest(userId.Is("uid:1")), dnm.MakePutRequest(id.Is("sid:1")))
+			Expect(errs).To(HaveLen(2))
+			Expect(errs[0]).ToNot(BeNil())
+			Expect(errs[1]).To(BeNil())
+		})
+	})
+
+	Context("numeric keys", func() {
+		var num dnm.IAttr
+		nd := dnm.Describe("Invoices", func(t dnm.ITable) {
+			num = t.KeyAttr("Number", dnm.Number)
+			t.PrimaryKey().Hash(num)
+		})
+
+		It("should match keys DynamoDB returns normalized", func() {
+			server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"Responses":{"Invoices":[{"Number":{"N":"1"}},{"Number":{"N":"2.5"}}]}}`)
+			}))
+			defer server.Close()
+			store, _ := dnm.MakeStore(&nd, cfg)
+			items, errs := store.BatchGet([]dynamodb.Key{{HashKey: "01"}, {HashKey: "2.50"}, {HashKey: "3"}})
+			Expect(items[0]).ToNot(BeNil())
+			Expect(items[1]).ToNot(BeNil())
+			Expect(errs[0]).To(BeNil())
+			Expect(errs[1]).To(BeNil())
+			Expect(errs[2].IsNotFound()).To(BeTrue())
+		})
+	})
+
+	It("should validate keys of delete requests", func() {
+		requests := 0
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			fmt.Fprint(w, "{}")
+		}))
+		defer server.Close()
+		tStore, _ := dnm.MakeStore(&d, cfg)
+		for _, store := range []dnm.IStore{tStore, dnm.MakeMemStore(&d)} {
+			errs := store.BatchWrite(dnm.MakeDeleteRequest(&dynamodb.Key{}), dnm.MakeDeleteRequest(&dynamodb.Key{HashKey: "sid:1", RangeKey: "1"}))
+			Expect(errs).To(HaveLen(2))
+			Expect(errs[0].Is(dnm.DeleteErr)).To(BeTrue())
+			Expect(errs[1].Is(dnm.DeleteErr)).To(BeTrue())
+		}
+		Expect(requests).To(BeZero())
+	})
+
+	Context("MemStore", func() {
+		store := dnm.MakeMemStore(&d)
+
+		It("should write and read batches", func() {
+			key := pk.Key(id.Is("sid:1"))
+			Expect(store.BatchWrite(
+				dnm.MakePutRequest(id.Is("sid:1"), userId.Is("uid:1")),
+				dnm.MakePutRequest(id.Is("sid:2"), userId.Is("uid:2")),
+				dnm.MakeDeleteRequest(&key),
+			)).To(BeNil())
+			items, errs := store.BatchGet(makeKeys("sid:1", "sid:2"))
+			Expect(errs[0].IsNotFound()).To(BeTrue())
+			Expect(errs[1]).To(BeNil())
+			Expect(userId.From(items[1])).To(Equal("uid:2"))
+		})
+	})
+})
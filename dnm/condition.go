@@ -0,0 +1,426 @@
+package dnm
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Condition expression builder, conditions are composed from attribute handles
+and rendered into expression text with name and value placeholders.
+
+	cond := dnm.And(
+		dnm.Compare(Status.Equals("draft"), Revision.LessThan("5")),
+		dnm.Or(dnm.AttributeNotExists(LockedBy), dnm.Size(Tags).LessThan(10)),
+	)
+	err := store.SaveWithCondition(attrs, cond)
+
+BuildCondition renders a condition for requests made outside of the store.
+*/
+
+type ICondition interface {
+	// expression renders the condition, registering placeholders in ctx
+	expression(ctx *tExprContext) string
+	// match evaluates the condition against an item, nil if it doesn't exist
+	match(item map[string]*dynamodb.Attribute) bool
+}
+
+// TExpression is a rendered expression along with its placeholders
+type TExpression struct {
+	Text   string
+	Names  map[string]string
+	Values map[string]*dynamodb.Attribute
+}
+
+func BuildCondition(cond ICondition) *TExpression {
+	ctx := makeExprContext()
+	text := cond.expression(ctx)
+	return ctx.build(text)
+}
+
+func (self *tExprContext) build(text string) *TExpression {
+	expr := &TExpression{Text: text, Names: map[string]string{}, Values: map[string]*dynamodb.Attribute{}}
+	for k, v := range self.names {
+		expr.Names[k] = v
+	}
+	for k, v := range self.values {
+		expr.Values[k] = fromWireValue(k, v)
+	}
+	return expr
+}
+
+/**
+Logical operators
+*/
+
+type tLogicalCond struct {
+	op    string
+	conds []ICondition
+}
+
+// And holds when all conditions hold
+func And(conds ...ICondition) ICondition {
+	return &tLogicalCond{"AND", conds}
+}
+
+// Or holds when any of conditions holds
+func Or(conds ...ICondition) ICondition {
+	return &tLogicalCond{"OR", conds}
+}
+
+func (self *tLogicalCond) expression(ctx *tExprContext) string {
+	if len(self.conds) == 0 {
+		// DynamoDB has no boolean literals
+		panic(fmt.Sprintf("Illegal condition, %s of no conditions", self.op))
+	}
+	parts := make([]string, len(self.conds))
+	for n, v := range self.conds {
+		parts[n] = "(" + v.expression(ctx) + ")"
+	}
+	return strings.Join(parts, " "+self.op+" ")
+}
+
+func (self *tLogicalCond) match(item map[string]*dynamodb.Attribute) bool {
+	and := self.op == "AND"
+	for _, v := range self.conds {
+		if v.match(item) != and {
+			return !and
+		}
+	}
+	return and
+}
+
+type tNotCond struct {
+	cond ICondition
+}
+
+func Not(cond ICondition) ICondition {
+	return &tNotCond{cond}
+}
+
+func (self *tNotCond) expression(ctx *tExprContext) string {
+	return "NOT (" + self.cond.expression(ctx) + ")"
+}
+
+func (self *tNotCond) match(item map[string]*dynamodb.Attribute) bool {
+	return !self.cond.match(item)
+}
+
+/**
+Comparisons
+*/
+
+type tCompareCond struct {
+	cmp dynamodb.AttributeComparison
+}
+
+var comparisonOperators = map[string]string{
+	dynamodb.COMPARISON_EQUAL:                 "=",
+	dynamodb.COMPARISON_NOT_EQUAL:             "<>",
+	dynamodb.COMPARISON_LESS_THAN:             "<",
+	dynamodb.COMPARISON_LESS_THAN_OR_EQUAL:    "<=",
+	dynamodb.COMPARISON_GREATER_THAN:          ">",
+	dynamodb.COMPARISON_GREATER_THAN_OR_EQUAL: ">=",
+}
+
+// Compare turns attribute comparisons, e.g. made by IAttr.LessThan, into
+// a condition which holds when all of them hold
+func Compare(cmps ...dynamodb.AttributeComparison) ICondition {
+	if len(cmps) == 1 {
+		return &tCompareCond{cmps[0]}
+	}
+	conds := make([]ICondition, len(cmps))
+	for n, v := range cmps {
+		conds[n] = &tCompareCond{v}
+	}
+	return And(conds...)
+}
+
+func (self *tCompareCond) expression(ctx *tExprContext) string {
+	name := ctx.path(self.cmp.AttributeName)
+	vals := make([]string, len(self.cmp.AttributeValueList))
+	for n := range self.cmp.AttributeValueList {
+		vals[n] = ctx.value(&self.cmp.AttributeValueList[n])
+	}
+	arity := map[string]int{
+		dynamodb.COMPARISON_ATTRIBUTE_EXISTS:         0,
+		dynamodb.COMPARISON_ATTRIBUTE_DOES_NOT_EXIST: 0,
+		dynamodb.COMPARISON_BETWEEN:                  2,
+	}
+	if n, ok := arity[self.cmp.ComparisonOperator]; (!ok && len(vals) < 1) || (ok && len(vals) != n) {
+		panic(fmt.Sprintf("Illegal condition, wrong number of values for %s on %s", self.cmp.ComparisonOperator, self.cmp.AttributeName))
+	}
+	if op, ok := comparisonOperators[self.cmp.ComparisonOperator]; ok {
+		return fmt.Sprintf("%s %s %s", name, op, vals[0])
+	}
+	switch self.cmp.ComparisonOperator {
+	case dynamodb.COMPARISON_BETWEEN:
+		return fmt.Sprintf("%s BETWEEN %s AND %s", name, vals[0], vals[1])
+	case dynamodb.COMPARISON_BEGINS_WITH:
+		return fmt.Sprintf("begins_with(%s, %s)", name, vals[0])
+	case dynamodb.COMPARISON_CONTAINS:
+		return fmt.Sprintf("contains(%s, %s)", name, vals[0])
+	case dynamodb.COMPARISON_DOES_NOT_CONTAIN:
+		return fmt.Sprintf("NOT contains(%s, %s)", name, vals[0])
+	case dynamodb.COMPARISON_IN:
+		return fmt.Sprintf("%s IN (%s)", name, strings.Join(vals, ", "))
+	case dynamodb.COMPARISON_ATTRIBUTE_EXISTS:
+		return fmt.Sprintf("attribute_exists(%s)", name)
+	case dynamodb.COMPARISON_ATTRIBUTE_DOES_NOT_EXIST:
+		return fmt.Sprintf("attribute_not_exists(%s)", name)
+	}
+	panic(fmt.Sprintf("Illegal condition, unsupported comparison %s", self.cmp.ComparisonOperator))
+}
+
+func (self *tCompareCond) match(item map[string]*dynamodb.Attribute) bool {
+	return matchComparison(item, self.cmp)
+}
+
+/**
+Functions
+*/
+
+type tFuncCond struct {
+	fn   string
+	name string
+	typ  string
+}
+
+func AttributeExists(attr AttributeDefinitionProvider) ICondition {
+	return &tFuncCond{fn: "attribute_exists", name: attr.Def().Name}
+}
+
+func AttributeNotExists(attr AttributeDefinitionProvider) ICondition {
+	return &tFuncCond{fn: "attribute_not_exists", name: attr.Def().Name}
+}
+
+// AttributeType holds when the attribute is of DynamoDB type typ, e.g. dnm.Number
+func AttributeType(attr AttributeDefinitionProvider, typ string) ICondition {
+	return &tFuncCond{fn: "attribute_type", name: attr.Def().Name, typ: typ}
+}
+
+func (self *tFuncCond) expression(ctx *tExprContext) string {
+	if self.fn == "attribute_type" {
+		typ := dynamodb.Attribute{Type: dynamodb.TYPE_STRING, Value: self.typ}
+		return fmt.Sprintf("%s(%s, %s)", self.fn, ctx.path(self.name), ctx.value(&typ))
+	}
+	return fmt.Sprintf("%s(%s)", self.fn, ctx.path(self.name))
+}
+
+func (self *tFuncCond) match(item map[string]*dynamodb.Attribute) bool {
+	attr, ok := lookupPath(item, self.name)
+	switch self.fn {
+	case "attribute_exists":
+		return ok
+	case "attribute_not_exists":
+		return !ok
+	default:
+		return ok && attr.Type == self.typ
+	}
+}
+
+type TSize struct {
+	name string
+}
+
+// Size compares length of string and binary attributes, number of
+// elements of set and list attributes and number of map entries
+func Size(attr AttributeDefinitionProvider) *TSize {
+	return &TSize{attr.Def().Name}
+}
+
+func (self *TSize) Equals(n int) ICondition {
+	return self.compare(dynamodb.COMPARISON_EQUAL, n)
+}
+
+func (self *TSize) NotEquals(n int) ICondition {
+	return self.compare(dynamodb.COMPARISON_NOT_EQUAL, n)
+}
+
+func (self *TSize) LessThan(n int) ICondition {
+	return self.compare(dynamodb.COMPARISON_LESS_THAN, n)
+}
+
+func (self *TSize) LessOrEqual(n int) ICondition {
+	return self.compare(dynamodb.COMPARISON_LESS_THAN_OR_EQUAL, n)
+}
+
+func (self *TSize) GreaterThan(n int) ICondition {
+	return self.compare(dynamodb.COMPARISON_GREATER_THAN, n)
+}
+
+func (self *TSize) GreaterOrEqual(n int) ICondition {
+	return self.compare(dynamodb.COMPARISON_GREATER_THAN_OR_EQUAL, n)
+}
+
+func (self *TSize) Between(from, to int) ICondition {
+	return self.compare(dynamodb.COMPARISON_BETWEEN, from, to)
+}
+
+func (self *TSize) compare(op string, sizes ...int) ICondition {
+	vals := make([]dynamodb.Attribute, len(sizes))
+	for n, v := range sizes {
+		vals[n] = dynamodb.Attribute{Type: dynamodb.TYPE_NUMBER, Name: self.name, Value: FromInt(v)}
+	}
+	return &tSizeCond{dynamodb.AttributeComparison{self.name, op, vals}}
+}
+
+type tSizeCond struct {
+	cmp dynamodb.AttributeComparison
+}
+
+func (self *tSizeCond) expression(ctx *tExprContext) string {
+	name := ctx.path(self.cmp.AttributeName)
+	vals := make([]string, len(self.cmp.AttributeValueList))
+	for n := range self.cmp.AttributeValueList {
+		vals[n] = ctx.value(&self.cmp.AttributeValueList[n])
+	}
+	if self.cmp.ComparisonOperator == dynamodb.COMPARISON_BETWEEN {
+		return fmt.Sprintf("size(%s) BETWEEN %s AND %s", name, vals[0], vals[1])
+	}
+	return fmt.Sprintf("size(%s) %s %s", name, comparisonOperators[self.cmp.ComparisonOperator], vals[0])
+}
+
+func (self *tSizeCond) match(item map[string]*dynamodb.Attribute) bool {
+	attr, ok := lookupPath(item, self.cmp.AttributeName)
+	if !ok {
+		return false
+	}
+	size, ok := attrSize(attr)
+	if !ok {
+		return false
+	}
+	sized := map[string]*dynamodb.Attribute{
+		self.cmp.AttributeName: {Type: dynamodb.TYPE_NUMBER, Name: self.cmp.AttributeName, Value: FromInt(size)},
+	}
+	return matchComparison(sized, self.cmp)
+}
+
+func attrSize(attr *dynamodb.Attribute) (int, bool) {
+	switch {
+	case isSetType(attr.Type):
+		return len(attr.SetValues), true
+	case attr.Type == dynamodb.TYPE_STRING:
+		return len(attr.Value), true
+	case attr.Type == dynamodb.TYPE_BINARY:
+		data, err := base64.StdEncoding.DecodeString(attr.Value)
+		return len(data), err == nil
+	case isDocType(attr.Type):
+		return docLen(attr)
+	}
+	return 0, false
+}
+
+/**
+Store implementations
+*/
+
+func (self *TStore) SaveWithCondition(attrs []dynamodb.Attribute, cond ICondition) *TError {
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		var err error
+		if attrs, check, err = self.opts.versionSave(keyAttrName(self.tableDesc.KeySchema, KeyHash), attrs); err != nil {
+			return self.makeError(SaveErr, "SaveWithCondition", err)
+		}
+	}
+	ctx := makeExprContext()
+	req := map[string]interface{}{"TableName": self.tableDesc.TableName, "Item": toWireAttrs(attrs)}
+	conds := withVersionCondition(cond, check)
+	if conds != nil {
+		req["ConditionExpression"] = conds.expression(ctx)
+	}
+	ctx.apply(req)
+	if err := self.rpcWrite("PutItem", conds == nil, req, nil); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			key := itemTableKey(self.tableDesc, makeItem(attrs))
+			return self.conditionalError("SaveWithCondition", &key, check, cond != nil, err)
+		}
+		log.WithFields(log.Fields{
+			LogTable:      self.tableDesc.TableName,
+			LogCondition:  req["ConditionExpression"],
+			fhlog.FHError: err.Error(),
+		}).Error("Error in SaveWithCondition()")
+		return self.makeError(SaveErr, "SaveWithCondition", err)
+	}
+	return nil
+}
+
+func (self *TStore) DeleteWithCondition(key *dynamodb.Key, cond ICondition) *TError {
+	if err := self.opts.unversioned(); err != nil {
+		return self.makeError(NotSupportedErr, "DeleteWithCondition", err)
+	}
+	ctx := makeExprContext()
+	req := map[string]interface{}{"TableName": self.tableDesc.TableName, "Key": toWireItem(tableKeyItem(self.tableDesc, key))}
+	if cond != nil {
+		req["ConditionExpression"] = cond.expression(ctx)
+	}
+	ctx.apply(req)
+	if err := self.rpcWrite("DeleteItem", cond == nil, req, nil); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			return self.makeError(ConditionalErr, "DeleteWithCondition", err)
+		}
+		log.WithFields(log.Fields{
+			LogTable:      self.tableDesc.TableName,
+			LogKey:        key,
+			LogCondition:  req["ConditionExpression"],
+			fhlog.FHError: err.Error(),
+		}).Error("Error in DeleteWithCondition()")
+		return self.makeError(DeleteErr, "DeleteWithCondition", err)
+	}
+	return nil
+}
+
+func (self *TMemStore) SaveWithCondition(attrs []dynamodb.Attribute, cond ICondition) *TError {
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		var err error
+		if attrs, check, err = self.opts.versionSave(self.hashName(), attrs); err != nil {
+			return self.makeError(SaveErr, "SaveWithCondition", err)
+		}
+	}
+	item := makeItem(attrs)
+	key, err := self.itemKey(item)
+	if err != nil {
+		return self.makeError(SaveErr, "SaveWithCondition", err)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if conds := withVersionCondition(cond, check); conds != nil && !conds.match(self.items[key]) {
+		return self.conditionalError("SaveWithCondition", self.items[key], check, fmt.Errorf("item doesn't match condition"))
+	}
+	self.items[key] = item
+	return nil
+}
+
+func (self *TMemStore) DeleteWithCondition(key *dynamodb.Key, cond ICondition) *TError {
+	if err := self.opts.unversioned(); err != nil {
+		return self.makeError(NotSupportedErr, "DeleteWithCondition", err)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	mk := self.memKey(key)
+	if cond != nil && !cond.match(self.items[mk]) {
+		return self.makeError(ConditionalErr, "DeleteWithCondition", fmt.Errorf("item doesn't match condition"))
+	}
+	delete(self.items, mk)
+	return nil
+}
+
+func (self *tContextStore) SaveWithCondition(attrs []dynamodb.Attribute, cond ICondition) *TError {
+	if err := self.cancelled("SaveWithCondition"); err != nil {
+		return err
+	}
+	return self.store.SaveWithCondition(attrs, cond)
+}
+
+func (self *tContextStore) DeleteWithCondition(key *dynamodb.Key, cond ICondition) *TError {
+	if err := self.cancelled("DeleteWithCondition"); err != nil {
+		return err
+	}
+	return self.store.DeleteWithCondition(key, cond)
+}
@@ -0,0 +1,85 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Condition expressions", func() {
+	var (
+		id, status, revision, tags dnm.IAttr
+		pk                         dnm.IKeyFactory
+		store                      dnm.IStore
+	)
+	d := dnm.Describe("Documents", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		status = t.NonKeyAttr("Status", dnm.String)
+		revision = t.NonKeyAttr("Revision", dnm.Number)
+		tags = t.NonKeyAttr("Tags", dynamodb.TYPE_STRING_SET)
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+	})
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+		Expect(store.Save(id.Is("doc:1"), status.Is("draft"), revision.Is("3"), tags.Is("a", "b"))).To(BeNil())
+	})
+
+	It("should render expression with placeholders", func() {
+		expr := dnm.BuildCondition(dnm.And(
+			dnm.Compare(status.Equals("draft"), revision.Between("1", "5")),
+			dnm.Or(dnm.AttributeNotExists(status), dnm.Not(dnm.Size(tags).GreaterThan(2))),
+		))
+		Expect(expr.Text).To(Equal("((#n0 = :v0) AND (#n1 BETWEEN :v1 AND :v2)) AND ((attribute_not_exists(#n0)) OR (NOT (size(#n2) > :v3)))"))
+		Expect(expr.Names).To(Equal(map[string]string{"#n0": "Status", "#n1": "Revision", "#n2": "Tags"}))
+		Expect(expr.Values[":v0"].Value).To(Equal("draft"))
+		Expect(expr.Values[":v3"].Type).To(Equal(dnm.Number))
+
+		expr = dnm.BuildCondition(dnm.AttributeType(revision, dnm.Number))
+		Expect(expr.Text).To(Equal("attribute_type(#n0, :v0)"))
+		Expect(expr.Values[":v0"].Value).To(Equal(dnm.Number))
+	})
+
+	It("should evaluate conditions of in-memory writes", func() {
+		err := store.SaveWithCondition([]dynamodb.Attribute{id.Is("doc:1"), status.Is("published")},
+			dnm.Compare(status.Equals("published")))
+		Expect(err.IsConditional()).To(BeTrue())
+
+		err = store.SaveWithCondition([]dynamodb.Attribute{id.Is("doc:1"), status.Is("published")},
+			dnm.And(dnm.Compare(revision.LessThan("5"), tags.Contains("a")), dnm.Size(tags).Equals(2)))
+		Expect(err).To(BeNil())
+
+		err = store.SaveWithCondition([]dynamodb.Attribute{id.Is("doc:2")}, dnm.AttributeExists(id))
+		Expect(err.IsConditional()).To(BeTrue())
+		Expect(store.SaveWithCondition([]dynamodb.Attribute{id.Is("doc:2")}, dnm.AttributeNotExists(id))).To(BeNil())
+
+		key := pk.Key(id.Is("doc:1"))
+		Expect(store.DeleteWithCondition(&key, dnm.Not(dnm.AttributeType(status, dnm.String))).IsConditional()).To(BeTrue())
+		Expect(store.DeleteWithCondition(&key, dnm.Compare(status.In("draft", "published")))).To(BeNil())
+		_, err = store.Get(&key)
+		Expect(err.IsNotFound()).To(BeTrue())
+	})
+
+	It("should send condition to DynamoDB", func() {
+		var req map[string]interface{}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&req)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`)
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&d, cfg)
+		err := store.SaveWithCondition([]dynamodb.Attribute{id.Is("doc:1")}, dnm.AttributeNotExists(id))
+		Expect(err.IsConditional()).To(BeTrue())
+		Expect(req["ConditionExpression"]).To(Equal("attribute_not_exists(#n0)"))
+		Expect(req["ExpressionAttributeNames"]).To(Equal(map[string]interface{}{"#n0": "Id"}))
+		Expect(req).ToNot(HaveKey("ExpressionAttributeValues"))
+	})
+})
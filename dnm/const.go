@@ -11,6 +11,10 @@ const (
 	String                    = dynamodb.TYPE_STRING
 	Number                    = dynamodb.TYPE_NUMBER
 	Binary                    = dynamodb.TYPE_BINARY
+	List                      = "L"
+	Map                       = "M"
+	Bool                      = "BOOL"
+	Null                      = "NULL"
 	KeyRange                  = "RANGE"
 	KeyHash                   = "HASH"
 )
@@ -0,0 +1,261 @@
+package dnm
+
+import (
+	"context"
+	"time"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Context support. TStore checks its context between retries and polls, aborts
+wire requests and stops waiting for goamz requests once the context is done,
+writes which aren't idempotent are cancelled between attempts only.
+In-memory store operations are instant, so they only check the context before
+they start.
+*/
+
+// WithContext binds the store to ctx. A goamz request can't be aborted, once
+// the context is done the store stops waiting for it and reports CancelledErr
+// while the request may still complete in background. Requests of writes
+// which aren't idempotent are neither aborted nor abandoned, they are
+// cancelled between attempts only, so CancelledErr never hides a write
+// applied after it.
+func (self *TStore) WithContext(ctx context.Context) IStore {
+	store := *self
+	store.ctx = ctx
+	return &store
+}
+
+func (self *TStore) context() context.Context {
+	if self.ctx == nil {
+		return context.Background()
+	}
+	return self.ctx
+}
+
+// sleep waits for d unless the store context is done first
+func (self *TStore) sleep(d time.Duration) error {
+	ctx := self.context()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// callWithContext returns as soon as ctx is done, goamz requests can't be
+// aborted so fn keeps running in background and its result is dropped.
+// Unless abandon is set fn is waited for once it has started.
+func callWithContext(ctx context.Context, abandon bool, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !abandon || ctx.Done() == nil {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (self *TMemStore) WithContext(ctx context.Context) IStore {
+	return &tContextStore{store: self, table: self.tableDesc.TableName, ctx: ctx}
+}
+
+type tContextStore struct {
+	store IStore
+	table string
+	ctx   context.Context
+}
+
+func (self *tContextStore) cancelled(op string) *TError {
+	if err := self.ctx.Err(); err != nil {
+		return makeOpError(CancelledErr, self.table, op, err)
+	}
+	return nil
+}
+
+func (self *tContextStore) WithContext(ctx context.Context) IStore {
+	return &tContextStore{store: self.store, table: self.table, ctx: ctx}
+}
+
+func (self *tContextStore) Get(key *dynamodb.Key) (map[string]*dynamodb.Attribute, *TError) {
+	if err := self.cancelled("Get"); err != nil {
+		return nil, err
+	}
+	return self.store.Get(key)
+}
+
+func (self *tContextStore) Find(query IQuery) ([]map[string]*dynamodb.Attribute, *TError) {
+	if err := self.cancelled("Find"); err != nil {
+		return nil, err
+	}
+	return self.store.Find(query)
+}
+
+func (self *tContextStore) FindIter(query IQuery, pageSize, limit int64, cursor string) IQueryIterator {
+	return &tContextIterator{IQueryIterator: self.store.FindIter(query, pageSize, limit, cursor), store: self}
+}
+
+func (self *tContextStore) Save(attrs ...dynamodb.Attribute) *TError {
+	if err := self.cancelled("Save"); err != nil {
+		return err
+	}
+	return self.store.Save(attrs...)
+}
+
+func (self *tContextStore) SaveConditional(attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TError {
+	if err := self.cancelled("SaveConditional"); err != nil {
+		return err
+	}
+	return self.store.SaveConditional(attrs, expected)
+}
+
+func (self *tContextStore) SaveConditionalWithConditionExpression(attrs []dynamodb.Attribute, condition *dynamodb.ConditionExpression) *TError {
+	if err := self.cancelled("SaveConditionalWithConditionExpression"); err != nil {
+		return err
+	}
+	return self.store.SaveConditionalWithConditionExpression(attrs, condition)
+}
+
+func (self *tContextStore) DeleteAttributesWithUpdateExpression(key *dynamodb.Key, returnValues string,
+	attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
+	if err := self.cancelled("DeleteAttributesWithUpdateExpression"); err != nil {
+		return nil, err
+	}
+	return self.store.DeleteAttributesWithUpdateExpression(key, returnValues, attrs...)
+}
+
+func (self *tContextStore) ModifyAttributesWithUpdateExpression(key *dynamodb.Key, condition *dynamodb.ConditionExpression,
+	actions []string, returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
+	if err := self.cancelled("ModifyAttributesWithUpdateExpression"); err != nil {
+		return nil, err
+	}
+	return self.store.ModifyAttributesWithUpdateExpression(key, condition, actions, returnValues, attrs...)
+}
+
+func (self *tContextStore) UpdateWithUpdateExpression(key *dynamodb.Key, returnValues string,
+	attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
+	if err := self.cancelled("UpdateWithUpdateExpression"); err != nil {
+		return nil, err
+	}
+	return self.store.UpdateWithUpdateExpression(key, returnValues, attrs...)
+}
+
+func (self *tContextStore) UpdateConditionalWithUpdateExpression(key *dynamodb.Key, condition *dynamodb.ConditionExpression,
+	returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
+	if err := self.cancelled("UpdateConditionalWithUpdateExpression"); err != nil {
+		return nil, err
+	}
+	return self.store.UpdateConditionalWithUpdateExpression(key, condition, returnValues, attrs...)
+}
+
+func (self *tContextStore) Update(key *dynamodb.Key, attrs ...dynamodb.Attribute) *TError {
+	if err := self.cancelled("Update"); err != nil {
+		return err
+	}
+	return self.store.Update(key, attrs...)
+}
+
+func (self *tContextStore) UpdateConditional(key *dynamodb.Key, attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TError {
+	if err := self.cancelled("UpdateConditional"); err != nil {
+		return err
+	}
+	return self.store.UpdateConditional(key, attrs, expected)
+}
+
+func (self *tContextStore) Delete(key *dynamodb.Key) *TError {
+	if err := self.cancelled("Delete"); err != nil {
+		return err
+	}
+	return self.store.Delete(key)
+}
+
+func (self *tContextStore) DeleteConditional(key *dynamodb.Key, expected []dynamodb.Attribute) *TError {
+	if err := self.cancelled("DeleteConditional"); err != nil {
+		return err
+	}
+	return self.store.DeleteConditional(key, expected)
+}
+
+func (self *tContextStore) DeleteVersioned(key *dynamodb.Key, version string) *TError {
+	if err := self.cancelled("DeleteVersioned"); err != nil {
+		return err
+	}
+	return self.store.DeleteVersioned(key, version)
+}
+
+func (self *tContextStore) ParallelScanPartialLimit(attributeComparisons []dynamodb.AttributeComparison, exclusiveStartKey *dynamodb.Key,
+	segment, totalSegments int, limit int64) ([]map[string]*dynamodb.Attribute, *dynamodb.Key, *TError) {
+	if err := self.cancelled("ParallelScanPartialLimit"); err != nil {
+		return nil, nil, err
+	}
+	return self.store.ParallelScanPartialLimit(attributeComparisons, exclusiveStartKey, segment, totalSegments, limit)
+}
+
+func (self *tContextStore) BatchGet(keys []dynamodb.Key) ([]map[string]*dynamodb.Attribute, []*TError) {
+	if err := self.cancelled("BatchGet"); err != nil {
+		errs := make([]*TError, len(keys))
+		for n := range errs {
+			errs[n] = err
+		}
+		return make([]map[string]*dynamodb.Attribute, len(keys)), errs
+	}
+	return self.store.BatchGet(keys)
+}
+
+func (self *tContextStore) BatchWrite(reqs ...TWriteRequest) []*TError {
+	if err := self.cancelled("BatchWrite"); err != nil {
+		errs := make([]*TError, len(reqs))
+		for n := range errs {
+			errs[n] = err
+		}
+		return errs
+	}
+	return self.store.BatchWrite(reqs...)
+}
+
+func (self *tContextStore) Init() *TError {
+	if err := self.cancelled("Init"); err != nil {
+		return err
+	}
+	return self.store.Init()
+}
+
+func (self *tContextStore) Destroy() *TError {
+	if err := self.cancelled("Destroy"); err != nil {
+		return err
+	}
+	return self.store.Destroy()
+}
+
+type tContextIterator struct {
+	IQueryIterator
+	store *tContextStore
+	err   *TError
+}
+
+func (self *tContextIterator) Next() bool {
+	if self.err == nil {
+		self.err = self.store.cancelled("FindIter")
+	}
+	return self.err == nil && self.IQueryIterator.Next()
+}
+
+func (self *tContextIterator) Err() *TError {
+	if self.err != nil {
+		return self.err
+	}
+	return self.IQueryIterator.Err()
+}
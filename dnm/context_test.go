@@ -0,0 +1,112 @@
+package dnm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Context", func() {
+	var (
+		id, user dnm.IAttr
+		pk       dnm.IKeyFactory
+		pkQuery  dnm.IIndex
+	)
+	d := dnm.Describe("Sessions", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		user = t.NonKeyAttr("UserId", dnm.String)
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+		pkQuery = p
+	})
+
+	Context("TStore", func() {
+		var (
+			server *httptest.Server
+			cfg    *dnm.TStoreConfig
+		)
+		BeforeEach(func() {
+			server, cfg = fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(time.Second):
+				case <-r.Context().Done():
+				}
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException"}`)
+			}))
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should stop request on deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			started := time.Now()
+			store, _ := dnm.MakeStore(&d, cfg)
+			_, errs := store.WithContext(ctx).BatchGet([]dynamodb.Key{pk.Key(id.Is("sid:1"))})
+			Expect(time.Since(started)).To(BeNumerically("<", 500*time.Millisecond))
+			Expect(errs[0].IsCancelled()).To(BeTrue())
+			Expect(errs[0].Op).To(Equal("BatchGet"))
+			Expect(errors.Is(errs[0], dnm.CancelledErr)).To(BeTrue())
+			Expect(errors.Is(errs[0], context.DeadlineExceeded)).To(BeTrue())
+		})
+
+		It("should stop retrying once cancelled", func() {
+			cfg.Retry = &dnm.TRetryPolicy{MaxAttempts: 10, BaseDelay: time.Minute}
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			started := time.Now()
+			store, _ := dnm.MakeStore(&d, cfg)
+			it := store.WithContext(ctx).FindIter(pkQuery.Where(id.Equals("sid:1")), 0, 0, "")
+			Expect(it.Next()).To(BeFalse())
+			Expect(it.Err().IsCancelled()).To(BeTrue())
+			Expect(time.Since(started)).To(BeNumerically("<", 500*time.Millisecond))
+		})
+
+		It("should wait for writes which aren't idempotent", func() {
+			applied, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(100 * time.Millisecond)
+				fmt.Fprint(w, "{}")
+			}))
+			defer applied.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			store, _ := dnm.MakeStore(&d, cfg)
+			key := pk.Key(id.Is("sid:1"))
+			_, err := store.WithContext(ctx).UpdateWithCondition(&key, dnm.MakeUpdate().Set(user.Is("uid:1")), dnm.AttributeExists(id), "")
+			Expect(err).To(BeNil())
+			_, err = store.WithContext(ctx).UpdateWithCondition(&key, dnm.MakeUpdate().Set(user.Is("uid:1")), dnm.AttributeExists(id), "")
+			Expect(err.IsCancelled()).To(BeTrue())
+		})
+	})
+
+	Context("MemStore", func() {
+		It("should refuse operations once cancelled", func() {
+			store := dnm.MakeMemStore(&d)
+			Expect(store.Save(id.Is("sid:1"))).To(BeNil())
+			ctx, cancel := context.WithCancel(context.Background())
+			bound := store.WithContext(ctx)
+			key := pk.Key(id.Is("sid:1"))
+			_, err := bound.Get(&key)
+			Expect(err).To(BeNil())
+
+			it := bound.FindIter(pkQuery.Where(id.Equals("sid:1")), 0, 0, "")
+			cancel()
+			_, err = bound.Get(&key)
+			Expect(err.IsCancelled()).To(BeTrue())
+			Expect(err.Table).To(Equal("Sessions"))
+			Expect(it.Next()).To(BeFalse())
+			Expect(it.Err().IsCancelled()).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,133 @@
+package dnm
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Atomic counters, number attributes changed in place by a single update:
+
+	logins, err := store.Increment(&key, Logins, 1, nil)
+	posts, err := store.Decrement(&key, Posts, 1, &dnm.TCounterConfig{Min: dnm.Bound(0)})
+
+A counter that doesn't exist yet starts from the initial value. Bounds are
+checked by DynamoDB, an update that would move the counter out of them fails
+with CounterBoundsErr and leaves the counter unchanged. Updates failed with a
+transport or internal server error may have been applied already, they are
+not retried to not count twice, throttled ones are. Counters of versioned
+tables fail with NotSupportedErr, an update can't carry the read version.
+*/
+
+type TCounterConfig struct {
+	// value of the counter before the first update
+	Initial int64
+	// inclusive bounds of the counter, nil means unbounded
+	Min *int64
+	Max *int64
+}
+
+func Bound(n int64) *int64 {
+	return &n
+}
+
+// condition keeps the counter within bounds after delta is added, nil when
+// the counter is unbounded
+func (self *TCounterConfig) condition(attr AttributeDefinitionProvider, delta int64) ICondition {
+	cmps := []dynamodb.AttributeComparison{}
+	first := self.Initial + delta
+	inBounds := true
+	if self.Min != nil {
+		cmps = append(cmps, counterComparison(attr, dynamodb.COMPARISON_GREATER_THAN_OR_EQUAL, *self.Min-delta))
+		inBounds = inBounds && first >= *self.Min
+	}
+	if self.Max != nil {
+		cmps = append(cmps, counterComparison(attr, dynamodb.COMPARISON_LESS_THAN_OR_EQUAL, *self.Max-delta))
+		inBounds = inBounds && first <= *self.Max
+	}
+	if len(cmps) == 0 {
+		return nil
+	}
+	// comparisons fail for a missing counter, it is created only if its first
+	// value is within bounds
+	if inBounds {
+		return Or(AttributeNotExists(attr), Compare(cmps...))
+	}
+	return Compare(cmps...)
+}
+
+func counterComparison(attr AttributeDefinitionProvider, op string, val int64) dynamodb.AttributeComparison {
+	name := attr.Def().Name
+	return dynamodb.AttributeComparison{
+		AttributeName:      name,
+		ComparisonOperator: op,
+		AttributeValueList: []dynamodb.Attribute{{Type: Number, Name: name, Value: strconv.FormatInt(val, 10)}},
+	}
+}
+
+// incrementCounter is shared by stores, it is built on top of UpdateWithCondition
+func incrementCounter(store IStore, table, op string, key *dynamodb.Key, attr AttributeDefinitionProvider,
+	delta int64, cfg *TCounterConfig) (int64, *TError) {
+
+	if err := storeOptions(store).unversioned(); err != nil {
+		return 0, makeOpError(NotSupportedErr, table, op, err)
+	}
+	def := attr.Def()
+	if def.Type != "" && def.Type != Number {
+		return 0, makeOpError(UpdateErr, table, op, fmt.Errorf("counter attribute %s is not a number", def.Name))
+	}
+	if cfg == nil {
+		cfg = &TCounterConfig{}
+	}
+	update := MakeUpdate().counter(def.Name, delta, cfg.Initial)
+	item, err := store.UpdateWithCondition(key, update, cfg.condition(attr, delta), ReturnValuesUpdatedNew)
+	if err != nil {
+		if err.IsConditional() {
+			return 0, makeOpError(CounterBoundsErr, table, op, err)
+		}
+		return 0, err
+	}
+	attrVal, ok := lookupPath(item, def.Name)
+	if !ok {
+		return 0, makeOpError(UnmarshalErr, table, op, fmt.Errorf("counter attribute %s wasn't returned", def.Name))
+	}
+	val, perr := strconv.ParseInt(attrVal.Value, 10, 64)
+	if perr != nil {
+		return 0, makeOpError(UnmarshalErr, table, op, perr)
+	}
+	return val, nil
+}
+
+// Increment adds delta to the counter and returns its new value
+func (self *TStore) Increment(key *dynamodb.Key, attr AttributeDefinitionProvider, delta int64, cfg *TCounterConfig) (int64, *TError) {
+	return incrementCounter(self, self.tableDesc.TableName, "Increment", key, attr, delta, cfg)
+}
+
+// Decrement subtracts delta from the counter and returns its new value
+func (self *TStore) Decrement(key *dynamodb.Key, attr AttributeDefinitionProvider, delta int64, cfg *TCounterConfig) (int64, *TError) {
+	return incrementCounter(self, self.tableDesc.TableName, "Decrement", key, attr, -delta, cfg)
+}
+
+func (self *TMemStore) Increment(key *dynamodb.Key, attr AttributeDefinitionProvider, delta int64, cfg *TCounterConfig) (int64, *TError) {
+	return incrementCounter(self, self.tableDesc.TableName, "Increment", key, attr, delta, cfg)
+}
+
+func (self *TMemStore) Decrement(key *dynamodb.Key, attr AttributeDefinitionProvider, delta int64, cfg *TCounterConfig) (int64, *TError) {
+	return incrementCounter(self, self.tableDesc.TableName, "Decrement", key, attr, -delta, cfg)
+}
+
+func (self *tContextStore) Increment(key *dynamodb.Key, attr AttributeDefinitionProvider, delta int64, cfg *TCounterConfig) (int64, *TError) {
+	if err := self.cancelled("Increment"); err != nil {
+		return 0, err
+	}
+	return self.store.Increment(key, attr, delta, cfg)
+}
+
+func (self *tContextStore) Decrement(key *dynamodb.Key, attr AttributeDefinitionProvider, delta int64, cfg *TCounterConfig) (int64, *TError) {
+	if err := self.cancelled("Decrement"); err != nil {
+		return 0, err
+	}
+	return self.store.Decrement(key, attr, delta, cfg)
+}
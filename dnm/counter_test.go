@@ -0,0 +1,106 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Atomic counters", func() {
+	var (
+		forum, subject dnm.IAttr
+		posts          interface {
+			Def() *dynamodb.AttributeDefinitionT
+		}
+		pk    dnm.IKeyFactory
+		store dnm.IStore
+	)
+	d := dnm.Describe("ForumCounters", func(t dnm.ITable) {
+		forum = t.KeyAttr("Forum", dnm.String)
+		subject = t.NonKeyAttr("Subject", dnm.String)
+		n := t.NonKeyAttr("Posts").AsInt64()
+		posts = &n
+		p := t.PrimaryKey()
+		p.Hash(forum)
+		pk = p.Factory()
+	})
+	key := pk.Key(forum.Is("go"))
+	nonNegative := &dnm.TCounterConfig{Min: dnm.Bound(0)}
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+	})
+
+	It("should start counters from the initial value", func() {
+		Expect(store.Increment(&key, posts, 1, nil)).To(Equal(int64(1)))
+		Expect(store.Increment(&key, posts, 2, nil)).To(Equal(int64(3)))
+		Expect(store.Decrement(&key, posts, 1, nil)).To(Equal(int64(2)))
+
+		other := pk.Key(forum.Is("rust"))
+		Expect(store.Increment(&other, posts, 1, &dnm.TCounterConfig{Initial: 10})).To(Equal(int64(11)))
+		item, _ := store.Get(&other)
+		Expect(forum.From(item)).To(Equal("rust"))
+	})
+
+	It("should keep counters within bounds", func() {
+		_, err := store.Decrement(&key, posts, 1, nonNegative)
+		Expect(err.Is(dnm.CounterBoundsErr)).To(BeTrue())
+		Expect(err.IsConditional()).To(BeTrue())
+		_, err = store.Get(&key)
+		Expect(err.IsNotFound()).To(BeTrue())
+
+		Expect(store.Increment(&key, posts, 2, nonNegative)).To(Equal(int64(2)))
+		Expect(store.Decrement(&key, posts, 2, nonNegative)).To(Equal(int64(0)))
+		_, err = store.Decrement(&key, posts, 1, nonNegative)
+		Expect(err.Is(dnm.CounterBoundsErr)).To(BeTrue())
+
+		limited := &dnm.TCounterConfig{Max: dnm.Bound(1)}
+		Expect(store.Increment(&key, posts, 1, limited)).To(Equal(int64(1)))
+		_, err = store.Increment(&key, posts, 1, limited)
+		Expect(err.Is(dnm.CounterBoundsErr)).To(BeTrue())
+	})
+
+	It("should reject non-number counters", func() {
+		_, err := store.Increment(&key, subject, 1, nil)
+		Expect(err.Is(dnm.UpdateErr)).To(BeTrue())
+		_, err = store.Increment(&key, forum, 1, nil)
+		Expect(err.Is(dnm.UpdateErr)).To(BeTrue())
+	})
+
+	It("should send a single conditional update", func() {
+		var req map[string]interface{}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&req)
+			fmt.Fprint(w, `{"Attributes":{"Posts":{"N":"4"}}}`)
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&d, cfg)
+		Expect(store.Decrement(&key, posts, 1, &dnm.TCounterConfig{Initial: 5, Min: dnm.Bound(0)})).To(Equal(int64(4)))
+		Expect(req["UpdateExpression"]).To(Equal("SET #n0 = if_not_exists(#n0, :v0) + :v1"))
+		Expect(req["ConditionExpression"]).To(Equal("(attribute_not_exists(#n0)) OR (#n0 >= :v2)"))
+		Expect(req["ReturnValues"]).To(Equal(dnm.ReturnValuesUpdatedNew))
+		values := req["ExpressionAttributeValues"].(map[string]interface{})
+		Expect(values[":v0"]).To(Equal(map[string]interface{}{"N": "5"}))
+		Expect(values[":v1"]).To(Equal(map[string]interface{}{"N": "-1"}))
+		Expect(values[":v2"]).To(Equal(map[string]interface{}{"N": "1"}))
+	})
+
+	It("should not repeat an update failed after it was applied", func() {
+		applied := 0
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			applied++
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#InternalServerError","message":"failed"}`)
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&d, cfg)
+		_, err := store.Increment(&key, posts, 1, nil)
+		Expect(err.Code).To(Equal(dnm.ErrCodeInternal))
+		Expect(applied).To(Equal(1))
+	})
+})
@@ -0,0 +1,307 @@
+package dnm
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/**
+Table definition derived from an annotated struct, an alternative to the
+closure based Describe. The definition is replayed through the same ITable
+calls, so both styles are validated identically.
+
+Keys and projections are declared with options of the "dnm" mapper tag:
+ - hash, range: primary key
+ - gsi=Index:hash, gsi=Index:range: global secondary index key
+ - lsi=Index:range: local secondary index range key
+ - project=Index: non-key attribute included into index projection
+ - version: optimistic locking version attribute, see VersionAttr
+
+Table and index settings are declared with tags on blank fields:
+ _ struct{} `dnmtable:"read=5,write=5"`
+ _ struct{} `dnmindex:"UserIndex,projection=keys_only,read=1,write=1"`
+
+Projection is ALL unless the index has included attributes or declares
+one explicitly, throughput defaults to DefaultReadCapacity/DefaultWriteCapacity.
+*/
+
+const (
+	TableTag = "dnmtable"
+	IndexTag = "dnmindex"
+)
+
+type tStructAttr struct {
+	name     string
+	typ      string
+	isKey    bool
+	included bool
+	version  bool
+}
+
+type tStructIndex struct {
+	name       string
+	local      bool
+	kindKnown  bool
+	hash       []string
+	rang       []string
+	projection string
+	includes   []string
+	read       int64
+	write      int64
+}
+
+type tStructSchema struct {
+	attrs   []*tStructAttr
+	hash    []string
+	rang    []string
+	indexes []*tStructIndex
+	read    int64
+	write   int64
+}
+
+func DescribeStruct(name string, model interface{}) TTableDescription {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("Incorrect table definition: expected struct, got %T", model))
+	}
+	schema := parseStructSchema(t)
+	return Describe(name, schema.define)
+}
+
+func parseStructSchema(t reflect.Type) *tStructSchema {
+	schema := &tStructSchema{read: DefaultReadCapacity, write: DefaultWriteCapacity}
+	for n := 0; n < t.NumField(); n++ {
+		sf := t.Field(n)
+		if tag := sf.Tag.Get(TableTag); tag != "" {
+			schema.parseTableTag(tag)
+		}
+		if tag := sf.Tag.Get(IndexTag); tag != "" {
+			schema.parseIndexTag(tag)
+		}
+	}
+	fields, err := structFields(t)
+	if err != nil {
+		panic(fmt.Sprintf("Incorrect table definition: %v", err))
+	}
+	for _, f := range fields {
+		attr := &tStructAttr{name: f.name, typ: attrTypeOf(f.typ)}
+		schema.attrs = append(schema.attrs, attr)
+		for _, o := range f.opts {
+			schema.parseAttrOpt(attr, o)
+		}
+	}
+	return schema
+}
+
+func attrTypeOf(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return String
+	case reflect.Slice:
+		return Binary
+	default:
+		return Number
+	}
+}
+
+func parseSettings(tag string, settings func(k, v string)) {
+	for _, o := range strings.Split(tag, ",") {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 {
+			panic(fmt.Sprintf("Incorrect table definition: malformed setting %s", o))
+		}
+		settings(kv[0], kv[1])
+	}
+}
+
+func parseCapacity(v string) int64 {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("Incorrect table definition: malformed capacity %s", v))
+	}
+	return n
+}
+
+func (self *tStructSchema) parseTableTag(tag string) {
+	parseSettings(tag, func(k, v string) {
+		switch k {
+		case "read":
+			self.read = parseCapacity(v)
+		case "write":
+			self.write = parseCapacity(v)
+		default:
+			panic(fmt.Sprintf("Incorrect table definition: unknown table setting %s", k))
+		}
+	})
+}
+
+func (self *tStructSchema) parseIndexTag(tag string) {
+	name, settings := parseTag(tag)
+	idx := self.index(name)
+	if len(settings) == 0 {
+		return
+	}
+	parseSettings(strings.Join(settings, ","), func(k, v string) {
+		switch k {
+		case "projection":
+			switch v {
+			case "all":
+				idx.projection = ProjectionTypeAll
+			case "keys_only":
+				idx.projection = ProjectionTypeKeysOnly
+			case "include":
+				idx.projection = ProjectionTypeInclude
+			default:
+				panic(fmt.Sprintf("Incorrect table definition: unknown projection %s", v))
+			}
+		case "read":
+			idx.read = parseCapacity(v)
+		case "write":
+			idx.write = parseCapacity(v)
+		default:
+			panic(fmt.Sprintf("Incorrect table definition: unknown index setting %s", k))
+		}
+	})
+}
+
+func (self *tStructSchema) parseAttrOpt(attr *tStructAttr, opt string) {
+	kv := strings.SplitN(opt, "=", 2)
+	switch kv[0] {
+	case "hash":
+		self.hash = append(self.hash, attr.name)
+		attr.isKey = true
+	case "range":
+		self.rang = append(self.rang, attr.name)
+		attr.isKey = true
+	case "gsi", "lsi":
+		if len(kv) != 2 {
+			panic(fmt.Sprintf("Incorrect table definition: malformed index key %s", opt))
+		}
+		ref := strings.SplitN(kv[1], ":", 2)
+		if len(ref) != 2 {
+			panic(fmt.Sprintf("Incorrect table definition: malformed index key %s", opt))
+		}
+		idx := self.index(ref[0])
+		local := kv[0] == "lsi"
+		if idx.kindKnown && idx.local != local {
+			panic(fmt.Sprintf("Incorrect table definition: index %s is declared both global and local", idx.name))
+		}
+		idx.local, idx.kindKnown = local, true
+		switch ref[1] {
+		case "hash":
+			if local {
+				panic(fmt.Sprintf("Incorrect table definition: local index %s shares hash key with the table", idx.name))
+			}
+			idx.hash = append(idx.hash, attr.name)
+		case "range":
+			idx.rang = append(idx.rang, attr.name)
+		default:
+			panic(fmt.Sprintf("Incorrect table definition: unknown index key type %s", ref[1]))
+		}
+		attr.isKey = true
+	case "project":
+		if len(kv) != 2 {
+			panic(fmt.Sprintf("Incorrect table definition: malformed projection %s", opt))
+		}
+		idx := self.index(kv[1])
+		idx.includes = append(idx.includes, attr.name)
+		attr.included = true
+	case "version":
+		attr.version = true
+	}
+}
+
+func (self *tStructSchema) index(name string) *tStructIndex {
+	for _, v := range self.indexes {
+		if v.name == name {
+			return v
+		}
+	}
+	idx := &tStructIndex{name: name, read: DefaultReadCapacity, write: DefaultWriteCapacity}
+	self.indexes = append(self.indexes, idx)
+	return idx
+}
+
+// define replays parsed struct schema through the table DSL
+func (self *tStructSchema) define(t ITable) {
+	attrs := map[string]*tAttr{}
+	for _, v := range self.attrs {
+		if v.isKey {
+			attrs[v.name] = t.KeyAttr(v.name, v.typ)
+		}
+	}
+	for _, v := range self.attrs {
+		if v.version {
+			if v.isKey {
+				panic(fmt.Sprintf("Incorrect table definition: version attr %s can't be a key attr", v.name))
+			}
+			attrs[v.name] = t.VersionAttr(v.name)
+		} else if !v.isKey && v.included {
+			attrs[v.name] = t.NonKeyAttr(v.name, v.typ)
+		}
+	}
+	if len(self.hash) == 0 {
+		panic("Incorrect table definition: hash key is not declared")
+	}
+	// duplicate keys are left for tIndex.tryAddKey to reject
+	{
+		pk := t.PrimaryKey()
+		for _, name := range self.hash {
+			pk.Hash(attrs[name])
+		}
+		for _, name := range self.rang {
+			pk.Range(attrs[name])
+		}
+	}
+	{
+		p := t.ProvisionedThroughput()
+		p.ReadCapacity(self.read)
+		p.WriteCapacity(self.write)
+	}
+	for _, v := range self.indexes {
+		if !v.kindKnown {
+			panic(fmt.Sprintf("Incorrect table definition: index %s has no key attributes", v.name))
+		}
+		var idx SecondaryIndexProvider
+		if v.local {
+			idx = t.LocalIndex(v.name)
+		} else {
+			g := t.GlobalIndex(v.name)
+			p := g.ProvisionedThroughput()
+			p.ReadCapacity(v.read)
+			p.WriteCapacity(v.write)
+			idx = g
+		}
+		for _, name := range v.hash {
+			idx.Hash(attrs[name])
+		}
+		for _, name := range v.rang {
+			idx.Range(attrs[name])
+		}
+		includes := []IAttr{}
+		for _, name := range v.includes {
+			includes = append(includes, attrs[name])
+		}
+		switch {
+		case len(includes) > 0:
+			if v.projection != "" && v.projection != ProjectionTypeInclude {
+				panic(fmt.Sprintf("Incorrect table definition: index %s projects attributes but is not of %s type", v.name, ProjectionTypeInclude))
+			}
+			idx.Projection().Include(includes...)
+		case v.projection == ProjectionTypeInclude:
+			panic(fmt.Sprintf("Incorrect table definition: index %s has no projected attributes", v.name))
+		case v.projection == ProjectionTypeKeysOnly:
+			idx.Projection().KeysOnly()
+		default:
+			idx.Projection().All()
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package dnm_test
+
+import (
+	"time"
+
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type tThread struct {
+	_         struct{}  `dnmtable:"read=1,write=1"`
+	_         struct{}  `dnmindex:"OtherIndex,projection=all"`
+	ForumName string    `dnm:"ForumName,hash,gsi=UserIndex:range"`
+	Subject   string    `dnm:"Subject,lsi=OtherIndex:range,project=UserIndex"`
+	Created   time.Time `dnm:"Created,range"`
+	UserId    string    `dnm:"UserId,gsi=UserIndex:hash"`
+	Views     int       `dnm:"Views"`
+}
+
+var _ = Describe("DescribeStruct", func() {
+	fromDSL := dnm.Describe("Threads", func(t dnm.ITable) {
+		forumName := t.KeyAttr("ForumName", dnm.String)
+		subject := t.KeyAttr("Subject", dnm.String)
+		created := t.KeyAttr("Created", dnm.Number)
+		userId := t.KeyAttr("UserId", dnm.String)
+		{
+			pk := t.PrimaryKey()
+			pk.Hash(forumName)
+			pk.Range(created)
+		}
+		{
+			p := t.ProvisionedThroughput()
+			p.ReadCapacity(1)
+			p.WriteCapacity(1)
+		}
+		{
+			g := t.LocalIndex("OtherIndex")
+			g.Range(subject)
+			g.Projection().All()
+		}
+		{
+			g := t.GlobalIndex("UserIndex")
+			p := g.ProvisionedThroughput()
+			p.ReadCapacity(1)
+			p.WriteCapacity(1)
+			g.Hash(userId)
+			g.Range(forumName)
+			g.Projection().Include(subject)
+		}
+	})
+
+	It("should produce the same definition as the DSL", func() {
+		Expect(dnm.DescribeStruct("Threads", &tThread{})).To(Equal(fromDSL))
+	})
+
+	It("should declare only key attributes", func() {
+		d := dnm.DescribeStruct("Threads", tThread{})
+		for _, v := range d.AttributeDefinitions {
+			Expect(v.Name).ToNot(Equal("Views"))
+		}
+	})
+
+	It("should default projection and throughput", func() {
+		d := dnm.DescribeStruct("Sessions", struct {
+			Id     string `dnm:"Id,hash,gsi=UserIndex:range"`
+			UserId string `dnm:"UserId,gsi=UserIndex:hash"`
+		}{})
+		Expect(d.ProvisionedThroughput.ReadCapacityUnits).To(Equal(int64(dnm.DefaultReadCapacity)))
+		Expect(d.GlobalSecondaryIndexes).To(HaveLen(1))
+		Expect(d.GlobalSecondaryIndexes[0].Projection.ProjectionType).To(Equal(dnm.ProjectionTypeAll))
+		Expect(d.GlobalSecondaryIndexes[0].ProvisionedThroughput.WriteCapacityUnits).To(Equal(int64(dnm.DefaultWriteCapacity)))
+	})
+
+	It("should validate like the DSL", func() {
+		Expect(func() {
+			dnm.DescribeStruct("Broken", struct {
+				Id    string `dnm:"Id,hash"`
+				Other string `dnm:"Other,hash"`
+			}{})
+		}).To(Panic())
+		Expect(func() {
+			dnm.DescribeStruct("Broken", struct {
+				Id    string `dnm:"Id,hash,gsi=IdIndex:hash"`
+				Other string `dnm:"Other,project=IdIndex"`
+				Flags []bool
+			}{})
+		}).To(Panic())
+		Expect(func() {
+			dnm.DescribeStruct("Broken", struct {
+				Id string `dnm:"Id"`
+			}{})
+		}).To(Panic())
+	})
+})
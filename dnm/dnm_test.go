@@ -28,7 +28,7 @@ func assertHasNonKeyAttr(d dynamodb.ProjectionT, name string) bool {
 	return false
 }
 
-func assertHasAttr(d dynamodb.TableDescriptionT, name, typ string) bool {
+func assertHasAttr(d dnm.TTableDescription, name, typ string) bool {
 	for _, v := range d.AttributeDefinitions {
 		if v.Name == name || v.Type == typ {
 			return true
@@ -0,0 +1,455 @@
+package dnm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Document attributes. Map and list attributes hold nested values, which are
+kept in Attribute.Value in their wire representation, e.g.
+{"City":{"S":"Boston"}} for a map.
+
+	address := t.NonKeyAttr("Address").AsMap()
+	tags := t.NonKeyAttr("Tags").AsList()
+	store.Save(id.Is("1"), address.Is(map[string]interface{}{"City": "Boston"}), tags.Is([]interface{}{"a", "b"}))
+
+	city := address.Key("City").Attr(dnm.String)
+	update := dnm.MakeUpdate().Set(city.Is("Cambridge")).Remove(tags.Index(0))
+	store.UpdateWithCondition(&key, update, dnm.Compare(city.Equals("Boston")), dnm.ReturnValuesNone)
+
+Attribute names of update and condition expressions are document paths,
+e.g. Address.City or Tags[2], so top level attributes with . or [ in their
+names can't be referenced by them.
+
+goamz encodes scalars and sets only, TStore writes items with documents and
+reads every item by its own wire codec.
+*/
+
+// TPath addresses a value nested in a document attribute
+type TPath struct {
+	path string
+}
+
+func Path(attr AttributeDefinitionProvider) *TPath {
+	return &TPath{attr.Def().Name}
+}
+
+// Key addresses a value of a map
+func (self *TPath) Key(name string) *TPath {
+	if name == "" || strings.ContainsAny(name, ".[]") {
+		panic(fmt.Sprintf("Illegal path, invalid map key %q of %s", name, self.path))
+	}
+	return &TPath{self.path + "." + name}
+}
+
+// Index addresses an element of a list
+func (self *TPath) Index(n int) *TPath {
+	if n < 0 {
+		panic(fmt.Sprintf("Illegal path, negative index %d of %s", n, self.path))
+	}
+	return &TPath{fmt.Sprintf("%s[%d]", self.path, n)}
+}
+
+func (self *TPath) Def() *dynamodb.AttributeDefinitionT {
+	return &dynamodb.AttributeDefinitionT{Name: self.path}
+}
+
+// Attr is a handle of the addressed value, e.g. for comparisons and updates
+func (self *TPath) Attr(maybeTyp ...string) *tAttr {
+	attr := dynamodb.AttributeDefinitionT{Name: self.path, Type: maybeStrArg(maybeTyp).GetOr("")}
+	return makeAttr(&attr, func(v string) {})
+}
+
+func (self *TPath) String() string {
+	return self.path
+}
+
+/**
+Path resolution
+*/
+
+type tPathElem struct {
+	key string
+	// index of list element, -1 for map keys
+	index int
+}
+
+func parsePath(path string) ([]tPathElem, error) {
+	elems := []tPathElem{}
+	rest := path
+	for {
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 || strings.ContainsRune(rest[:end], ']') {
+			return nil, fmt.Errorf("invalid document path %s", path)
+		}
+		elems = append(elems, tPathElem{key: rest[:end], index: -1})
+		rest = rest[end:]
+		for strings.HasPrefix(rest, "[") {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid document path %s", path)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid document path %s", path)
+			}
+			elems = append(elems, tPathElem{index: n})
+			rest = rest[end+1:]
+		}
+		if rest == "" {
+			return elems, nil
+		}
+		if rest[0] != '.' {
+			return nil, fmt.Errorf("invalid document path %s", path)
+		}
+		rest = rest[1:]
+	}
+}
+
+// pathRoot is the top level attribute of the path
+func pathRoot(path string) string {
+	if elems, err := parsePath(path); err == nil {
+		return elems[0].key
+	}
+	return path
+}
+
+// nestedPath returns elements of a path into a document, nil for top level
+// attributes and names which aren't paths
+func nestedPath(path string) []tPathElem {
+	if elems, err := parsePath(path); err == nil && len(elems) > 1 {
+		return elems
+	}
+	return nil
+}
+
+func pathsOverlap(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return strings.HasPrefix(b, a) && (b[len(a)] == '.' || b[len(a)] == '[')
+}
+
+// decodeDoc returns the attribute value as decoded wire JSON
+func decodeDoc(attr *dynamodb.Attribute) (map[string]interface{}, error) {
+	data, err := json.Marshal(toWireValue(attr))
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	err = json.Unmarshal(data, &doc)
+	return doc, err
+}
+
+// child returns the nested value addressed by elem, nil if there is none
+func child(node map[string]interface{}, elem tPathElem) map[string]interface{} {
+	if elem.index < 0 {
+		m, _ := node[Map].(map[string]interface{})
+		res, _ := m[elem.key].(map[string]interface{})
+		return res
+	}
+	l, _ := node[List].([]interface{})
+	if elem.index >= len(l) {
+		return nil
+	}
+	res, _ := l[elem.index].(map[string]interface{})
+	return res
+}
+
+// lookupPath finds the attribute or the document value the path addresses,
+// names of top level attributes are matched as is first
+func lookupPath(item map[string]*dynamodb.Attribute, path string) (*dynamodb.Attribute, bool) {
+	if attr, ok := item[path]; ok {
+		return attr, true
+	}
+	elems := nestedPath(path)
+	if elems == nil {
+		return nil, false
+	}
+	root, ok := item[elems[0].key]
+	if !ok {
+		return nil, false
+	}
+	node, err := decodeDoc(root)
+	for _, v := range elems[1:] {
+		if err != nil || node == nil {
+			return nil, false
+		}
+		node = child(node, v)
+	}
+	if node == nil {
+		return nil, false
+	}
+	return fromWireValue(path, tWireValue(node)), true
+}
+
+// setPath sets the addressed value, the document must have its parent,
+// list elements past the end are appended
+func setPath(item map[string]*dynamodb.Attribute, path string, attr *dynamodb.Attribute) error {
+	elems := nestedPath(path)
+	if elems == nil {
+		item[path] = copyAttr(attr)
+		return nil
+	}
+	return updateDoc(item, path, elems, func(parent map[string]interface{}, last tPathElem) error {
+		value, err := decodeDoc(attr)
+		if err != nil {
+			return err
+		}
+		if last.index < 0 {
+			m, ok := parent[Map].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("document path %s is invalid for update", path)
+			}
+			m[last.key] = value
+			return nil
+		}
+		l, ok := parent[List].([]interface{})
+		if !ok {
+			return fmt.Errorf("document path %s is invalid for update", path)
+		}
+		if last.index < len(l) {
+			l[last.index] = value
+		} else {
+			parent[List] = append(l, value)
+		}
+		return nil
+	})
+}
+
+// removePath removes the addressed value, missing values are ignored
+func removePath(item map[string]*dynamodb.Attribute, path string) error {
+	elems := nestedPath(path)
+	if elems == nil {
+		delete(item, path)
+		return nil
+	}
+	if _, ok := item[elems[0].key]; !ok {
+		return nil
+	}
+	return updateDoc(item, path, elems, func(parent map[string]interface{}, last tPathElem) error {
+		if last.index < 0 {
+			if m, ok := parent[Map].(map[string]interface{}); ok {
+				delete(m, last.key)
+			}
+			return nil
+		}
+		if l, ok := parent[List].([]interface{}); ok && last.index < len(l) {
+			parent[List] = append(l[:last.index], l[last.index+1:]...)
+		}
+		return nil
+	})
+}
+
+// updateDoc decodes the root document, changes the parent of the last path
+// element in place and stores the document back
+func updateDoc(item map[string]*dynamodb.Attribute, path string, elems []tPathElem,
+	change func(parent map[string]interface{}, last tPathElem) error) error {
+
+	root, ok := item[elems[0].key]
+	if !ok {
+		return fmt.Errorf("document path %s is invalid for update", path)
+	}
+	doc, err := decodeDoc(root)
+	if err != nil {
+		return err
+	}
+	parent := doc
+	for _, v := range elems[1 : len(elems)-1] {
+		if parent = child(parent, v); parent == nil {
+			return fmt.Errorf("document path %s is invalid for update", path)
+		}
+	}
+	if err := change(parent, elems[len(elems)-1]); err != nil {
+		return err
+	}
+	item[elems[0].key] = fromWireValue(elems[0].key, tWireValue(doc))
+	return nil
+}
+
+/**
+Go values of documents. Strings, numbers, []byte, time.Time as epoch seconds,
+bool, nil, slices and maps with string keys are supported. Numbers are read
+back as int64 unless they have a fraction, then as float64.
+*/
+
+func toDocValue(val interface{}) (tWireValue, error) {
+	switch val := val.(type) {
+	case nil:
+		return tWireValue{"NULL": true}, nil
+	case bool:
+		return tWireValue{"BOOL": val}, nil
+	case string:
+		return tWireValue{String: val}, nil
+	case []byte:
+		return tWireValue{Binary: FromBinary(val)}, nil
+	case time.Time:
+		return tWireValue{Number: FromTimeTime(val)}, nil
+	case json.Number:
+		return tWireValue{Number: val.String()}, nil
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return tWireValue{Number: strconv.FormatInt(rv.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return tWireValue{Number: strconv.FormatUint(rv.Uint(), 10)}, nil
+	case reflect.Float32:
+		return tWireValue{Number: FromFloat32(float32(rv.Float()))}, nil
+	case reflect.Float64:
+		return tWireValue{Number: FromFloat64(rv.Float())}, nil
+	case reflect.Slice, reflect.Array:
+		list := make([]tWireValue, rv.Len())
+		for n := range list {
+			v, err := toDocValue(rv.Index(n).Interface())
+			if err != nil {
+				return nil, err
+			}
+			list[n] = v
+		}
+		return tWireValue{List: list}, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", rv.Type().Key())
+		}
+		m := make(map[string]tWireValue, rv.Len())
+		for _, k := range rv.MapKeys() {
+			v, err := toDocValue(rv.MapIndex(k).Interface())
+			if err != nil {
+				return nil, err
+			}
+			m[k.String()] = v
+		}
+		return tWireValue{Map: m}, nil
+	}
+	return nil, fmt.Errorf("unsupported document value type %T", val)
+}
+
+func fromDocValue(val interface{}) (interface{}, error) {
+	wv, ok := val.(map[string]interface{})
+	if !ok || len(wv) != 1 {
+		return nil, fmt.Errorf("invalid document value %v", val)
+	}
+	for typ, v := range wv {
+		switch typ {
+		case "NULL":
+			return nil, nil
+		case "BOOL":
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+		case String:
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+		case Number:
+			if s, ok := v.(string); ok {
+				if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+					return i, nil
+				}
+				return strconv.ParseFloat(s, 64)
+			}
+		case Binary:
+			if s, ok := v.(string); ok {
+				return base64.StdEncoding.DecodeString(s)
+			}
+		case Map:
+			if m, ok := v.(map[string]interface{}); ok {
+				return fromDocMap(m)
+			}
+		case List:
+			if l, ok := v.([]interface{}); ok {
+				return fromDocList(l)
+			}
+		case dynamodb.TYPE_STRING_SET, dynamodb.TYPE_NUMBER_SET, dynamodb.TYPE_BINARY_SET:
+			if l, ok := v.([]interface{}); ok {
+				set := make([]string, len(l))
+				for n := range l {
+					set[n], _ = l[n].(string)
+				}
+				return set, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid %s document value %v", typ, v)
+	}
+	return nil, nil
+}
+
+func fromDocMap(m map[string]interface{}) (map[string]interface{}, error) {
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		val, err := fromDocValue(v)
+		if err != nil {
+			return nil, err
+		}
+		res[k] = val
+	}
+	return res, nil
+}
+
+func fromDocList(l []interface{}) ([]interface{}, error) {
+	res := make([]interface{}, len(l))
+	for n, v := range l {
+		val, err := fromDocValue(v)
+		if err != nil {
+			return nil, err
+		}
+		res[n] = val
+	}
+	return res, nil
+}
+
+// docLen is the number of map entries or list elements
+func docLen(attr *dynamodb.Attribute) (int, bool) {
+	doc, err := decodeDoc(attr)
+	if err != nil {
+		return 0, false
+	}
+	switch v := doc[attr.Type].(type) {
+	case map[string]interface{}:
+		return len(v), true
+	case []interface{}:
+		return len(v), true
+	}
+	return 0, false
+}
+
+// equalDocs compares documents regardless of order of map entries
+func equalDocs(a, b *dynamodb.Attribute) bool {
+	ad, aerr := decodeDoc(a)
+	bd, berr := decodeDoc(b)
+	return aerr == nil && berr == nil && reflect.DeepEqual(ad, bd)
+}
+
+// listContains holds when a list has an element equal to val
+func listContains(attr, val *dynamodb.Attribute) bool {
+	doc, err := decodeDoc(attr)
+	if err != nil {
+		return false
+	}
+	l, _ := doc[List].([]interface{})
+	for _, v := range l {
+		if m, ok := v.(map[string]interface{}); ok && equalAttrs(fromWireValue(attr.Name, tWireValue(m)), val) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDocType(typ string) bool {
+	return typ == Map || typ == List
+}
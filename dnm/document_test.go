@@ -0,0 +1,177 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Document attributes", func() {
+	var (
+		id      dnm.IAttr
+		address interface {
+			dnm.AttributeDefinitionProvider
+			Is(map[string]interface{}) dynamodb.Attribute
+			From(map[string]*dynamodb.Attribute) (map[string]interface{}, error)
+			Key(string) *dnm.TPath
+		}
+		tags interface {
+			dnm.AttributeDefinitionProvider
+			Is([]interface{}) dynamodb.Attribute
+			From(map[string]*dynamodb.Attribute) ([]interface{}, error)
+			Index(int) *dnm.TPath
+		}
+		pk    dnm.IKeyFactory
+		store dnm.IStore
+	)
+	d := dnm.Describe("Profiles", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		a := t.NonKeyAttr("Address").AsMap()
+		address = &a
+		l := t.NonKeyAttr("Tags").AsList()
+		tags = &l
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+	})
+	key := pk.Key(id.Is("uid:1"))
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+		Expect(store.Save(id.Is("uid:1"),
+			address.Is(map[string]interface{}{"City": "Boston", "Zip": 2134, "Geo": map[string]interface{}{"Lat": 42.36}}),
+			tags.Is([]interface{}{"a", "b", "c"}))).To(BeNil())
+	})
+
+	It("should read documents back into Go values", func() {
+		item, err := store.Get(&key)
+		Expect(err).To(BeNil())
+		Expect(address.From(item)).To(Equal(map[string]interface{}{
+			"City": "Boston", "Zip": int64(2134), "Geo": map[string]interface{}{"Lat": 42.36},
+		}))
+		Expect(tags.From(item)).To(Equal([]interface{}{"a", "b", "c"}))
+
+		city := address.Key("City").Attr(dnm.String)
+		Expect(city.From(item)).To(Equal("Boston"))
+		Expect(tags.Index(1).Attr(dnm.String).From(item)).To(Equal("b"))
+		lat := address.Key("Geo").Key("Lat").Attr().AsFloat64()
+		Expect(lat.From(item)).To(Equal(42.36))
+	})
+
+	It("should save, read and update documents of a table", func() {
+		var (
+			item json.RawMessage
+			req  struct {
+				Item                      json.RawMessage
+				UpdateExpression          string
+				ExpressionAttributeValues map[string]interface{}
+			}
+		)
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&req)
+			switch target := r.Header.Get("X-Amz-Target"); {
+			case strings.HasSuffix(target, "PutItem"):
+				item = req.Item
+				fmt.Fprint(w, "{}")
+			case strings.HasSuffix(target, "GetItem") && item != nil:
+				fmt.Fprintf(w, `{"Item":%s}`, item)
+			default:
+				fmt.Fprint(w, "{}")
+			}
+		}))
+		defer server.Close()
+		s, _ := dnm.MakeStore(&d, cfg)
+		_, err := s.Get(&key)
+		Expect(err.IsNotFound()).To(BeTrue())
+
+		Expect(s.Save(id.Is("uid:1"), address.Is(map[string]interface{}{"City": "Boston", "Zip": 2134}),
+			tags.Is([]interface{}{"a", "b"}))).To(BeNil())
+		stored, err := s.Get(&key)
+		Expect(err).To(BeNil())
+		Expect(address.From(stored)).To(Equal(map[string]interface{}{"City": "Boston", "Zip": int64(2134)}))
+		Expect(tags.From(stored)).To(Equal([]interface{}{"a", "b"}))
+
+		Expect(s.Update(&key, address.Is(map[string]interface{}{"City": "Cambridge"}))).To(BeNil())
+		Expect(req.UpdateExpression).To(Equal("SET #n0 = :v0"))
+		Expect(req.ExpressionAttributeValues[":v0"]).To(Equal(map[string]interface{}{
+			"M": map[string]interface{}{"City": map[string]interface{}{"S": "Cambridge"}},
+		}))
+	})
+
+	It("should scan documents of a table", func() {
+		var req struct {
+			Segment           int
+			ExclusiveStartKey map[string]interface{}
+		}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&req)
+			fmt.Fprint(w, `{"Items":[{"Id":{"S":"uid:2"},"Address":{"M":{"City":{"S":"Boston"}}},"Tags":{"L":[{"S":"a"}]}}],`+
+				`"LastEvaluatedKey":{"Id":{"S":"uid:2"}}}`)
+		}))
+		defer server.Close()
+		s, _ := dnm.MakeStore(&d, cfg)
+		items, last, err := s.ParallelScanPartialLimit(nil, &key, 1, 2, 10)
+		Expect(err).To(BeNil())
+		Expect(req.Segment).To(Equal(1))
+		Expect(req.ExclusiveStartKey).To(Equal(map[string]interface{}{"Id": map[string]interface{}{"S": "uid:1"}}))
+		Expect(items).To(HaveLen(1))
+		Expect(address.From(items[0])).To(Equal(map[string]interface{}{"City": "Boston"}))
+		Expect(tags.From(items[0])).To(Equal([]interface{}{"a"}))
+		Expect(last.HashKey).To(Equal("uid:2"))
+	})
+
+	It("should render paths with a placeholder per name", func() {
+		expr := dnm.BuildCondition(dnm.And(
+			dnm.Compare(address.Key("City").Attr(dnm.String).Equals("Boston")),
+			dnm.AttributeExists(tags.Index(2)),
+			dnm.Size(address.Key("Geo")).GreaterThan(0),
+		))
+		Expect(expr.Text).To(Equal("(#n0.#n1 = :v0) AND (attribute_exists(#n2[2])) AND (size(#n0.#n3) > :v1)"))
+		Expect(expr.Names).To(Equal(map[string]string{"#n0": "Address", "#n1": "City", "#n2": "Tags", "#n3": "Geo"}))
+	})
+
+	It("should update and match nested values in memory", func() {
+		city := address.Key("City").Attr(dnm.String)
+		update := dnm.MakeUpdate().
+			Set(city.Is("Cambridge")).
+			SetIfNotExists(address.Key("Country").Attr(dnm.String).Is("US")).
+			Remove(tags.Index(0), address.Key("Geo"))
+		_, err := store.UpdateWithCondition(&key, update, dnm.Compare(city.Equals("Boston")), "")
+		Expect(err).To(BeNil())
+
+		item, _ := store.Get(&key)
+		Expect(address.From(item)).To(Equal(map[string]interface{}{"City": "Cambridge", "Zip": int64(2134), "Country": "US"}))
+		Expect(tags.From(item)).To(Equal([]interface{}{"b", "c"}))
+
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Set(city.Is("Boston")), dnm.Compare(city.Equals("Boston")), "")
+		Expect(err.IsConditional()).To(BeTrue())
+
+		item, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Set(tags.Index(5).Attr(dnm.String).Is("d")),
+			dnm.Size(tags).Equals(2), dnm.ReturnValuesUpdatedNew)
+		Expect(err).To(BeNil())
+		Expect(tags.From(item)).To(Equal([]interface{}{"b", "c", "d"}))
+
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Set(address.Key("Geo").Key("Lat").Attr(dnm.Number).Is("1")), nil, "")
+		Expect(err.Is(dnm.UpdateErr)).To(BeTrue())
+	})
+
+	It("should count nested counters", func() {
+		visits := address.Key("Visits")
+		Expect(store.Increment(&key, visits, 2, nil)).To(Equal(int64(2)))
+		Expect(store.Increment(&key, visits, 3, nil)).To(Equal(int64(5)))
+	})
+
+	It("should reject illegal paths and updates", func() {
+		Expect(func() { address.Key("Geo.Lat") }).To(Panic())
+		Expect(func() { tags.Index(-1) }).To(Panic())
+		Expect(func() { dnm.MakeUpdate().Add(address.Key("Zip").Attr(dnm.Number).Is("1")) }).To(Panic())
+		Expect(func() { dnm.MakeUpdate().Remove(address).Remove(address.Key("Zip")) }).To(Panic())
+		Expect(func() { tags.Is([]interface{}{struct{}{}}) }).To(Panic())
+	})
+})
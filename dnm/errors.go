@@ -1,30 +1,230 @@
 package dnm
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Error codes, machine-readable class of the failure. Codes of DynamoDB failures
+are derived from the exception type DynamoDB has responded with.
+*/
+
+type TErrorCode string
+
+const (
+	ErrCodeUnknown          TErrorCode = ""
+	ErrCodeConditional      TErrorCode = "ConditionalCheckFailed"
+	ErrCodeThrottled        TErrorCode = "Throttled"
+	ErrCodeValidation       TErrorCode = "Validation"
+	ErrCodeResourceNotFound TErrorCode = "ResourceNotFound"
+	ErrCodeInternal         TErrorCode = "InternalServerError"
+	ErrCodeTransport        TErrorCode = "Transport"
+	ErrCodeNotFound         TErrorCode = "NotFound"
+	ErrCodeCancelled        TErrorCode = "Cancelled"
+	// conditional check of the item version has failed, see version.go
+	ErrCodeVersionConflict TErrorCode = "VersionConflict"
+)
+
+var dynamoErrorCodes = map[string]TErrorCode{
+	ConditionalDynamoError:                   ErrCodeConditional,
+	"ProvisionedThroughputExceededException": ErrCodeThrottled,
+	"ThrottlingException":                    ErrCodeThrottled,
+	"RequestLimitExceeded":                   ErrCodeThrottled,
+	"ValidationException":                    ErrCodeValidation,
+	"SerializationException":                 ErrCodeValidation,
+	"ResourceNotFoundException":              ErrCodeResourceNotFound,
+	"InternalServerError":                    ErrCodeInternal,
+	"ServiceUnavailable":                     ErrCodeInternal,
+}
 
 type TError struct {
 	Summary     string
 	Description string
+	// class of the failure
+	Code TErrorCode
+	// table and IStore method the failure happened in
+	Table string
+	Op    string
+	// underlying error, e.g. *dynamodb.Error
+	Err error
+	// predefined error this one was made from
+	kind *TError
 }
 
 func (self *TError) Error() string {
 	return fmt.Sprintf("DynamoDB Err: %s, desc: %s", self.Summary, self.Description)
 }
 
+func (self *TError) Unwrap() error {
+	return self.Err
+}
+
+// Is reports whether the error was made from the given predefined error,
+// e.g. err.Is(ConditionalErr)
+func (self *TError) Is(target error) bool {
+	t, ok := target.(*TError)
+	if !ok || self == nil || t == nil {
+		return false
+	}
+	return self == t || self.kind == t || (t.kind != nil && self.kind == t.kind)
+}
+
+func (self *TError) IsConditional() bool {
+	return self != nil && self.Code == ErrCodeConditional
+}
+
+// IsVersionConflict reports whether the item was modified since it was read,
+// versioned writes don't report these failures as conditional ones
+func (self *TError) IsVersionConflict() bool {
+	return self != nil && self.Code == ErrCodeVersionConflict
+}
+
+func (self *TError) IsNotFound() bool {
+	return self != nil && self.Code == ErrCodeNotFound
+}
+
+// IsCancelled reports whether the request was interrupted by cancellation or
+// deadline of the store context
+func (self *TError) IsCancelled() bool {
+	return self != nil && self.Code == ErrCodeCancelled
+}
+
+func (self *TError) IsThrottled() bool {
+	return self != nil && self.Code == ErrCodeThrottled
+}
+
+// IsRetryable reports whether the error is transient: throttling, internal
+// server and transport errors. It doesn't tell whether repeating the request
+// is safe, internal server and transport errors of writes which aren't
+// idempotent, e.g. conditional writes and counters, may come after the write
+// was applied. TStore repeats such writes only when they are throttled.
+func (self *TError) IsRetryable() bool {
+	if self == nil {
+		return false
+	}
+	switch self.Code {
+	case ErrCodeThrottled, ErrCodeInternal, ErrCodeTransport:
+		return true
+	}
+	return false
+}
+
 func MakeError(summary, description string) *TError {
-	return &TError{summary, description}
+	return &TError{Summary: summary, Description: description}
+}
+
+func makeCodedError(code TErrorCode, summary, description string) *TError {
+	return &TError{Summary: summary, Description: description, Code: code}
+}
+
+// makeOpError derives error of the operation from a predefined one, code is
+// taken from the underlying error when it can be classified
+func makeOpError(tErr *TError, table, op string, details error) *TError {
+	kind := tErr
+	if tErr.kind != nil {
+		kind = tErr.kind
+	}
+	code := errorCode(details)
+	if code == ErrCodeUnknown {
+		code = tErr.Code
+	}
+	if code == ErrCodeCancelled {
+		tErr, kind = CancelledErr, CancelledErr
+	}
+	return &TError{
+		Summary:     tErr.Summary,
+		Description: fmt.Sprintf("table: %s, err: %v, desc: %s", table, details, tErr.Description),
+		Code:        code,
+		Table:       table,
+		Op:          op,
+		Err:         details,
+		kind:        kind,
+	}
+}
+
+func wrapError(tErr *TError, details error) *TError {
+	err := makeOpError(tErr, "", "", details)
+	err.Description = fmt.Sprintf("err: %v, desc: %s", details, tErr.Description)
+	return err
+}
+
+// errorCode classifies an error returned by goamz or the wire client
+func errorCode(err error) TErrorCode {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrCodeCancelled
+	}
+	switch err := err.(type) {
+	case nil:
+		return ErrCodeUnknown
+	case *TError:
+		return err.Code
+	case *TTransactionCanceled:
+		return err.code()
+	case net.Error:
+		return ErrCodeTransport
+	}
+	var dErr *dynamodb.Error
+	if errors.As(err, &dErr) {
+		if code, ok := dynamoErrorCodes[dErr.Code]; ok {
+			return code
+		}
+		if dErr.StatusCode >= 500 {
+			return ErrCodeInternal
+		}
+		return ErrCodeUnknown
+	}
+	if err == dynamodb.ErrNotFound {
+		return ErrCodeNotFound
+	}
+	// goamz reports some failures as plain errors formatted "Code: Message"
+	msg := err.Error()
+	if n := strings.Index(msg, ":"); n > 0 {
+		if code, ok := dynamoErrorCodes[msg[:n]]; ok {
+			return code
+		}
+	}
+	return ErrCodeUnknown
 }
 
+// Predefined errors, failures are reported with errors made from them which
+// carry the table, the operation and the cause. Returned errors never equal
+// the predefined ones, check them with Is, e.g. err.Is(NotFoundErr), or with
+// IsConditional, IsNotFound and the other helpers instead of ==.
 var (
 	InitGeneralErr       = MakeError("Failed to initialize table", "...")
 	AttrNotFoundErr      = MakeError("Row attribute was not found", "...")
 	DestroyGeneralErr    = MakeError("Failed to destroy table", "...")
 	InitUnknownStatusErr = MakeError("Failed to initialize table", "DynamoDB has returned table status that is unknown")
 	DeleteErr            = MakeError("Failed to delete record", "...")
-	ConditionalErr       = MakeError("Failed to perform request because of conditonal constraint violation", "...")
+	ConditionalErr       = makeCodedError(ErrCodeConditional, "Failed to perform request because of conditonal constraint violation", "...")
 	SaveErr              = MakeError("Failed to save record", "...")
 	UpdateErr            = MakeError("Failed to update record", "...")
 	UpdateCollectionErr  = MakeError("Failed to update collection", "...")
 	LookupErr            = MakeError("Failed to lookup record", "...")
-	NotFoundErr          = MakeError("Record wasnt found", "...")
+	NotFoundErr          = makeCodedError(ErrCodeNotFound, "Record wasnt found", "...")
+	NotSupportedErr      = MakeError("Operation is not supported", "...")
+	MarshalErr           = MakeError("Failed to serialize record", "...")
+	UnmarshalErr         = MakeError("Failed to deserialize record", "...")
+	BatchUnprocessedErr  = MakeError("Record was left unprocessed by batch request", "...")
+	CancelledErr         = makeCodedError(ErrCodeCancelled, "Request was cancelled", "...")
+	InitTimeoutErr       = MakeError("Failed to initialize table", "Table hasn't become active in time")
+	ListTablesErr        = MakeError("Failed to list tables", "...")
+	CredentialsErr       = MakeError("Failed to obtain AWS credentials", "...")
+	TableDefinitionErr   = MakeError("Invalid table definition", "...")
+	TransactionErr       = MakeError("Failed to perform transaction", "...")
+	TransactionCancelErr = MakeError("Transaction was cancelled", "...")
+	DescribeErr          = MakeError("Failed to describe table", "...")
+	MigrateErr           = MakeError("Failed to migrate table", "...")
+	MigrateTimeoutErr    = MakeError("Failed to migrate table", "Table hasn't become active in time")
+	IncompatibleErr      = MakeError("Failed to migrate table", "Table definition differs in a way that requires the table to be recreated")
+	CounterBoundsErr     = makeCodedError(ErrCodeConditional, "Failed to update counter", "Counter would be out of bounds")
+	StreamErr            = MakeError("Failed to consume stream", "...")
+	TTLErr               = MakeError("Failed to enable time to live", "...")
+	VersionConflictErr   = makeCodedError(ErrCodeVersionConflict, "Failed to write record", "Record was modified since it was read")
 )
@@ -0,0 +1,77 @@
+package dnm_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Errors", func() {
+	var (
+		id, userId dnm.IAttr
+		pk         dnm.IKeyFactory
+	)
+	d := dnm.Describe("Sessions", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		userId = t.NonKeyAttr("UserId", dnm.String)
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+	})
+
+	It("should classify DynamoDB exceptions", func() {
+		for typ, code := range map[string]dnm.TErrorCode{
+			"ProvisionedThroughputExceededException": dnm.ErrCodeThrottled,
+			"ValidationException":                    dnm.ErrCodeValidation,
+			"ResourceNotFoundException":              dnm.ErrCodeResourceNotFound,
+			"InternalServerError":                    dnm.ErrCodeInternal,
+		} {
+			server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"__type":"com.amazonaws.dynamodb.v20120810#%s","message":"failed"}`, typ)
+			}))
+			cfg.Retry = dnm.MakeNoRetryPolicy()
+			store, _ := dnm.MakeStore(&d, cfg)
+			_, errs := store.BatchGet([]dynamodb.Key{pk.Key(id.Is("sid:1"))})
+			server.Close()
+
+			err := errs[0]
+			Expect(err.Code).To(Equal(code))
+			Expect(err.Table).To(Equal("Sessions"))
+			Expect(err.Op).To(Equal("BatchGet"))
+			Expect(err.Is(dnm.LookupErr)).To(BeTrue())
+			var dErr *dynamodb.Error
+			Expect(errors.As(err, &dErr)).To(BeTrue())
+			Expect(dErr.Code).To(Equal(typ))
+			Expect(err.IsRetryable()).To(Equal(code == dnm.ErrCodeThrottled || code == dnm.ErrCodeInternal))
+		}
+	})
+
+	It("should report conditional failures with table and operation", func() {
+		store := dnm.MakeMemStore(&d)
+		Expect(store.Save(id.Is("sid:1"), userId.Is("uid:1"))).To(BeNil())
+		err := store.SaveConditional([]dynamodb.Attribute{id.Is("sid:1")}, []dynamodb.Attribute{userId.Is("uid:2")})
+		Expect(err.IsConditional()).To(BeTrue())
+		Expect(err.IsRetryable()).To(BeFalse())
+		Expect(errors.Is(err, dnm.ConditionalErr)).To(BeTrue())
+		Expect(err.Table).To(Equal("Sessions"))
+		Expect(err.Op).To(Equal("SaveConditional"))
+	})
+
+	It("should report missing items", func() {
+		store := dnm.MakeMemStore(&d)
+		key := pk.Key(id.Is("sid:1"))
+		_, err := store.Get(&key)
+		Expect(err.IsNotFound()).To(BeTrue())
+		Expect(err.IsConditional()).To(BeFalse())
+		Expect(errors.Is(err, dnm.NotFoundErr)).To(BeTrue())
+		Expect(err).ToNot(BeIdenticalTo(dnm.NotFoundErr))
+		Expect(err.Table).To(Equal("Sessions"))
+		Expect(err.Op).To(Equal("Get"))
+	})
+})
@@ -0,0 +1,89 @@
+package dnm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Expression placeholders. Attribute names and values are never inlined into
+expression text, they are referenced by #nN and :vN placeholders which are
+sent in ExpressionAttributeNames and ExpressionAttributeValues.
+*/
+
+type tExprContext struct {
+	names  map[string]string
+	values map[string]tWireValue
+	ids    map[string]string
+}
+
+func makeExprContext() *tExprContext {
+	return &tExprContext{names: map[string]string{}, values: map[string]tWireValue{}, ids: map[string]string{}}
+}
+
+// name returns placeholder of the attribute name, the same for every reference
+func (self *tExprContext) name(attr string) string {
+	if id, ok := self.ids[attr]; ok {
+		return id
+	}
+	id := fmt.Sprintf("#n%d", len(self.ids))
+	self.ids[attr] = id
+	self.names[id] = attr
+	return id
+}
+
+// path returns placeholder of a document path, each of its names gets own
+// placeholder and list indexes are kept, e.g. Tags[2].Name is #n0[2].#n1
+func (self *tExprContext) path(path string) string {
+	elems, err := parsePath(path)
+	if err != nil {
+		return self.name(path)
+	}
+	res := ""
+	for n, v := range elems {
+		switch {
+		case v.index >= 0:
+			res += fmt.Sprintf("[%d]", v.index)
+		case n > 0:
+			res += "." + self.name(v.key)
+		default:
+			res += self.name(v.key)
+		}
+	}
+	return res
+}
+
+func (self *tExprContext) value(attr *dynamodb.Attribute) string {
+	id := fmt.Sprintf(":v%d", len(self.values))
+	self.values[id] = toWireValue(attr)
+	return id
+}
+
+// expected translates legacy Expected conditions into a condition expression
+func (self *tExprContext) expected(expected []dynamodb.Attribute) string {
+	conds := []string{}
+	for n := range expected {
+		exp := &expected[n]
+		switch {
+		case exp.Exists == "false":
+			conds = append(conds, fmt.Sprintf("attribute_not_exists(%s)", self.name(exp.Name)))
+		case exp.Value == "" && len(exp.SetValues) == 0:
+			conds = append(conds, fmt.Sprintf("attribute_exists(%s)", self.name(exp.Name)))
+		default:
+			conds = append(conds, fmt.Sprintf("%s = %s", self.name(exp.Name), self.value(exp)))
+		}
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// apply adds placeholder maps to a request, empty maps are rejected by DynamoDB
+func (self *tExprContext) apply(req map[string]interface{}) {
+	if len(self.names) > 0 {
+		req["ExpressionAttributeNames"] = self.names
+	}
+	if len(self.values) > 0 {
+		req["ExpressionAttributeValues"] = self.values
+	}
+}
@@ -0,0 +1,18 @@
+package dnm_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/flowhealth/goamz/aws"
+	"github.com/flowhealth/godnm/dnm"
+)
+
+// fakeEndpoint serves DynamoDB requests of stores made with the returned
+// config by handler, the server has to be closed by the caller
+func fakeEndpoint(handler http.Handler) (*httptest.Server, *dnm.TStoreConfig) {
+	server := httptest.NewServer(handler)
+	cfg := dnm.MakeStoreConfig(aws.Auth{AccessKey: "key", SecretKey: "secret"},
+		aws.Region{Name: "local", DynamoDBEndpoint: server.URL}, "1s", "1ms")
+	return server, cfg
+}
@@ -27,9 +27,9 @@ type tGlobalIndex struct {
 	tIndex
 }
 
-func makeGlobalIndex(tableName string, gidef *dynamodb.GlobalSecondaryIndexT) iGlobalIndex {
+func makeGlobalIndex(tableName string, tableKeySchema iKeySchema, gidef *dynamodb.GlobalSecondaryIndexT) iGlobalIndex {
 	schema := makeGlobalIndexKeySchema(gidef)
-	idx := tIndex{gidef.IndexName, tableName, schema}
+	idx := tIndex{gidef.IndexName, tableName, schema, tableKeySchema, true}
 	return &tGlobalIndex{gidef, idx}
 }
 
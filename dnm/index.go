@@ -21,6 +21,9 @@ type tIndex struct {
 	name      string
 	tableName string
 	keySchema iKeySchema
+	// key schema of the table the index belongs to
+	tableKeySchema iKeySchema
+	global         bool
 }
 
 func (self *tIndex) hasHashKey() bool {
@@ -85,6 +88,29 @@ func (self *tIndex) Where(conds ...dynamodb.AttributeComparison) *dynamodb.Query
 	return q
 }
 
+func (self *tIndex) Query() *TQuery {
+	return makeQuery(self)
+}
+
+// queryKeyNames returns hash and range key of the index, local indexes share
+// hash key with the table
+func (self *tIndex) queryKeyNames() (string, string) {
+	hash := self.attrNameByKeyType(KeyHash)
+	if hash == "" {
+		hash = keyAttrName(self.tableKeySchema.Items(), KeyHash)
+	}
+	return hash, self.attrNameByKeyType(KeyRange)
+}
+
+// keyNames returns key attributes of the index and the table
+func (self *tIndex) keyNames() []string {
+	names := []string{}
+	for _, v := range append(self.keySchema.Items(), self.tableKeySchema.Items()...) {
+		names = appendUnique(names, v.AttributeName)
+	}
+	return names
+}
+
 func (self *tIndex) Factory() IKeyFactory {
 	return self
 }
@@ -0,0 +1,237 @@
+package dnm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Query iterator, follows LastEvaluatedKey pagination of queries built with
+tIndex.Where or TQuery. Cursor can be used to resume iteration after the last item
+returned by Next, e.g. in a later request of a paginated API.
+
+	it := store.FindIter(UserIndex.Where(UserId.Equals(userId)), 100, 0, "")
+	defer it.Close()
+	for it.Next() {
+		process(it.Item())
+	}
+	if err := it.Err(); err != nil {
+		...
+	}
+*/
+
+type IQueryIterator interface {
+	Next() bool
+	Item() map[string]*dynamodb.Attribute
+	Err() *TError
+	Close()
+	Cursor() string
+}
+
+type tPageFetcher func(start tWireItem, limit int64) ([]map[string]*dynamodb.Attribute, tWireItem, *TError)
+
+type tQueryIterator struct {
+	fetch    tPageFetcher
+	keyNames []string
+	pageSize int64
+	limit    int64
+	returned int64
+	start    tWireItem
+	page     []map[string]*dynamodb.Attribute
+	pos      int
+	item     map[string]*dynamodb.Attribute
+	lastKey  tWireItem
+	done     bool
+	closed   bool
+	err      *TError
+}
+
+// pageSize limits number of items DynamoDB evaluates per request, limit caps the
+// total number of returned items, zero means no limit for both
+func makeQueryIterator(fetch tPageFetcher, keyNames []string, pageSize, limit int64, cursor string) *tQueryIterator {
+	it := &tQueryIterator{fetch: fetch, keyNames: keyNames, pageSize: pageSize, limit: limit}
+	if cursor != "" {
+		if start, err := decodeCursor(cursor); err != nil {
+			it.err = wrapError(LookupErr, err)
+		} else {
+			it.start = start
+		}
+	}
+	return it
+}
+
+func makeFailedIterator(err *TError) *tQueryIterator {
+	return &tQueryIterator{err: err}
+}
+
+func (self *tQueryIterator) Next() bool {
+	self.item = nil
+	if self.closed || self.err != nil {
+		return false
+	}
+	if self.limit > 0 && self.returned >= self.limit {
+		return false
+	}
+	for self.pos >= len(self.page) {
+		if self.done {
+			return false
+		}
+		pageLimit := self.pageSize
+		if self.limit > 0 {
+			if remaining := self.limit - self.returned; pageLimit <= 0 || remaining < pageLimit {
+				pageLimit = remaining
+			}
+		}
+		items, last, err := self.fetch(self.start, pageLimit)
+		if err != nil {
+			self.err = err
+			return false
+		}
+		self.page, self.pos = items, 0
+		self.start = last
+		self.done = len(last) == 0
+	}
+	self.item = self.page[self.pos]
+	self.pos++
+	self.returned++
+	self.lastKey = self.keyOf(self.item)
+	return true
+}
+
+func (self *tQueryIterator) Item() map[string]*dynamodb.Attribute {
+	return self.item
+}
+
+func (self *tQueryIterator) Err() *TError {
+	return self.err
+}
+
+func (self *tQueryIterator) Close() {
+	self.closed = true
+	self.page = nil
+	self.item = nil
+}
+
+// Cursor returns position after the last item returned by Next,
+// empty string means query has no more items
+func (self *tQueryIterator) Cursor() string {
+	if self.done && self.pos >= len(self.page) {
+		return ""
+	}
+	if self.lastKey != nil {
+		return encodeCursor(self.lastKey)
+	}
+	return encodeCursor(self.start)
+}
+
+func (self *tQueryIterator) keyOf(item map[string]*dynamodb.Attribute) tWireItem {
+	key := tWireItem{}
+	for _, name := range self.keyNames {
+		if v, ok := item[name]; ok {
+			key[name] = toWireValue(v)
+		}
+	}
+	return key
+}
+
+func encodeCursor(key tWireItem) string {
+	if len(key) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(key)
+	return base64.URLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (tWireItem, error) {
+	data, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %v", err)
+	}
+	key := tWireItem{}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("malformed cursor: %v", err)
+	}
+	return key, nil
+}
+
+/**
+Store implementations
+*/
+
+func (self *TStore) FindIter(query IQuery, pageSize, limit int64, cursor string) IQueryIterator {
+	body := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(query.String()), &body); err != nil {
+		return makeFailedIterator(self.makeError(LookupErr, "FindIter", err))
+	}
+	indexName, _ := body["IndexName"].(string)
+	if v, ok := body["Limit"].(float64); ok {
+		// limit of the query caps the whole iteration unless given explicitly
+		delete(body, "Limit")
+		if limit <= 0 {
+			limit = int64(v)
+		}
+	}
+	fetch := func(start tWireItem, limit int64) ([]map[string]*dynamodb.Attribute, tWireItem, *TError) {
+		req := make(map[string]interface{}, len(body)+2)
+		for k, v := range body {
+			req[k] = v
+		}
+		if len(start) > 0 {
+			req["ExclusiveStartKey"] = start
+		}
+		if limit > 0 {
+			req["Limit"] = limit
+		}
+		var resp struct {
+			Items            []tWireItem
+			LastEvaluatedKey tWireItem
+		}
+		if err := self.rpc("Query", req, &resp); err != nil {
+			log.WithFields(log.Fields{
+				LogTable:             self.tableDesc.TableName,
+				LogQuery:             query,
+				LogExclusiveStartKey: start,
+				LogLimit:             limit,
+				fhlog.FHError:        err.Error(),
+			}).Error("Error in FindIter()")
+			return nil, nil, self.makeError(LookupErr, "FindIter", err)
+		}
+		items := make([]map[string]*dynamodb.Attribute, 0, len(resp.Items))
+		for _, v := range resp.Items {
+			items = append(items, fromWireItem(v))
+		}
+		return self.opts.unexpired(items), resp.LastEvaluatedKey, nil
+	}
+	return makeQueryIterator(fetch, indexKeyNames(self.tableDesc, indexName), pageSize, limit, cursor)
+}
+
+func (self *TMemStore) FindIter(query IQuery, pageSize, limit int64, cursor string) IQueryIterator {
+	q, err := parseMemQuery(query)
+	if err != nil {
+		return makeFailedIterator(self.makeError(LookupErr, "FindIter", err))
+	}
+	if limit <= 0 {
+		limit = q.limit()
+	}
+	fetch := func(start tWireItem, limit int64) ([]map[string]*dynamodb.Attribute, tWireItem, *TError) {
+		var startItem map[string]*dynamodb.Attribute
+		if len(start) > 0 {
+			startItem = fromWireItem(start)
+		}
+		items, last, err := self.queryPage(q, startItem, limit)
+		if err != nil {
+			return nil, nil, self.makeError(LookupErr, "FindIter", err)
+		}
+		items = self.opts.unexpired(items)
+		if last == nil {
+			return items, nil, nil
+		}
+		return items, toWireItem(last), nil
+	}
+	return makeQueryIterator(fetch, indexKeyNames(self.tableDesc, q.IndexName), pageSize, limit, cursor)
+}
@@ -0,0 +1,85 @@
+package dnm_test
+
+import (
+	"fmt"
+
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QueryIterator", func() {
+	var (
+		forumName, created, userId dnm.IAttr
+		pkQuery, userIndex         dnm.IIndex
+		store                      dnm.IStore
+	)
+	d := dnm.Describe("Posts", func(t dnm.ITable) {
+		forumName = t.KeyAttr("ForumName", dnm.String)
+		created = t.KeyAttr("Created", dnm.Number)
+		userId = t.KeyAttr("UserId", dnm.String)
+		{
+			pk := t.PrimaryKey()
+			pk.Hash(forumName)
+			pk.Range(created)
+			pkQuery = pk
+		}
+		{
+			idx := t.GlobalIndex("UserIndex")
+			idx.Hash(userId)
+			idx.Projection().KeysOnly()
+			userIndex = idx
+		}
+	})
+
+	collect := func(it dnm.IQueryIterator) []string {
+		res := []string{}
+		for it.Next() {
+			res = append(res, created.From(it.Item()))
+		}
+		Expect(it.Err()).To(BeNil())
+		return res
+	}
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+		for n := 1; n <= 7; n++ {
+			Expect(store.Save(forumName.Is("go"), created.Is(fmt.Sprint(n)), userId.Is("uid:1"))).To(BeNil())
+		}
+	})
+
+	It("should follow pages", func() {
+		it := store.FindIter(pkQuery.Where(forumName.Equals("go")), 3, 0, "")
+		Expect(collect(it)).To(Equal([]string{"1", "2", "3", "4", "5", "6", "7"}))
+		Expect(it.Cursor()).To(Equal(""))
+	})
+
+	It("should stop at limit and resume from cursor", func() {
+		it := store.FindIter(pkQuery.Where(forumName.Equals("go")), 2, 3, "")
+		Expect(collect(it)).To(Equal([]string{"1", "2", "3"}))
+		cursor := it.Cursor()
+		Expect(cursor).ToNot(BeEmpty())
+		it = store.FindIter(pkQuery.Where(forumName.Equals("go")), 2, 0, cursor)
+		Expect(collect(it)).To(Equal([]string{"4", "5", "6", "7"}))
+	})
+
+	It("should resume on secondary index", func() {
+		it := store.FindIter(userIndex.Where(userId.Equals("uid:1")), 0, 4, "")
+		Expect(collect(it)).To(HaveLen(4))
+		it = store.FindIter(userIndex.Where(userId.Equals("uid:1")), 0, 0, it.Cursor())
+		Expect(collect(it)).To(HaveLen(3))
+	})
+
+	It("should stop after close", func() {
+		it := store.FindIter(pkQuery.Where(forumName.Equals("go")), 1, 0, "")
+		Expect(it.Next()).To(BeTrue())
+		it.Close()
+		Expect(it.Next()).To(BeFalse())
+	})
+
+	It("should report malformed cursor", func() {
+		it := store.FindIter(pkQuery.Where(forumName.Equals("go")), 1, 0, "%%%")
+		Expect(it.Next()).To(BeFalse())
+		Expect(it.Err()).ToNot(BeNil())
+	})
+})
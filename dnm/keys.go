@@ -0,0 +1,126 @@
+package dnm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Key helpers shared by store implementations, translate between dynamodb.Key
+and key attributes according to the table description
+*/
+
+func keyAttrName(schema []dynamodb.KeySchemaT, typ string) string {
+	for _, v := range schema {
+		if v.KeyType == typ {
+			return v.AttributeName
+		}
+	}
+	return ""
+}
+
+func tableAttrType(desc *dynamodb.TableDescriptionT, name string) string {
+	for _, v := range desc.AttributeDefinitions {
+		if v.Name == name && v.Type != "" {
+			return v.Type
+		}
+	}
+	return String
+}
+
+func validateItemKey(desc *dynamodb.TableDescriptionT, item map[string]*dynamodb.Attribute) error {
+	for _, k := range desc.KeySchema {
+		if v, ok := item[k.AttributeName]; !ok || v.Value == "" {
+			return fmt.Errorf("item is missing %s key attribute %s", k.KeyType, k.AttributeName)
+		}
+	}
+	return nil
+}
+
+// validateKey checks the key against the key schema of the table
+func validateKey(desc *dynamodb.TableDescriptionT, key *dynamodb.Key) error {
+	if key.RangeKey != "" && keyAttrName(desc.KeySchema, KeyRange) == "" {
+		return fmt.Errorf("key has range value %s, the table has no range key", key.RangeKey)
+	}
+	return validateItemKey(desc, tableKeyItem(desc, key))
+}
+
+func itemTableKey(desc *dynamodb.TableDescriptionT, item map[string]*dynamodb.Attribute) dynamodb.Key {
+	key := dynamodb.Key{}
+	if v, ok := item[keyAttrName(desc.KeySchema, KeyHash)]; ok {
+		key.HashKey = v.Value
+	}
+	if name := keyAttrName(desc.KeySchema, KeyRange); name != "" {
+		if v, ok := item[name]; ok {
+			key.RangeKey = v.Value
+		}
+	}
+	return key
+}
+
+func tableKeyItem(desc *dynamodb.TableDescriptionT, key *dynamodb.Key) map[string]*dynamodb.Attribute {
+	hashName := keyAttrName(desc.KeySchema, KeyHash)
+	item := map[string]*dynamodb.Attribute{
+		hashName: &dynamodb.Attribute{Type: tableAttrType(desc, hashName), Name: hashName, Value: key.HashKey},
+	}
+	if rangeName := keyAttrName(desc.KeySchema, KeyRange); rangeName != "" {
+		item[rangeName] = &dynamodb.Attribute{Type: tableAttrType(desc, rangeName), Name: rangeName, Value: key.RangeKey}
+	}
+	return item
+}
+
+// keyId identifies item key within a single table
+func keyId(key dynamodb.Key) string {
+	return key.HashKey + "\x00" + key.RangeKey
+}
+
+// canonicalKeyId identifies item key as keyId does, number values are
+// normalized as DynamoDB returns them, e.g. 01 and 1.0 are both 1
+func canonicalKeyId(desc *dynamodb.TableDescriptionT, key dynamodb.Key) string {
+	key.HashKey = canonicalValue(tableAttrType(desc, keyAttrName(desc.KeySchema, KeyHash)), key.HashKey)
+	if name := keyAttrName(desc.KeySchema, KeyRange); name != "" {
+		key.RangeKey = canonicalValue(tableAttrType(desc, name), key.RangeKey)
+	}
+	return keyId(key)
+}
+
+func canonicalValue(typ, val string) string {
+	if typ != Number {
+		return val
+	}
+	if n, ok := new(big.Rat).SetString(val); ok {
+		return n.RatString()
+	}
+	return val
+}
+
+// indexKeyNames lists attributes forming LastEvaluatedKey of a query on the index,
+// table key attributes always come first
+func indexKeyNames(desc *dynamodb.TableDescriptionT, indexName string) []string {
+	names := []string{}
+	add := func(schema []dynamodb.KeySchemaT) {
+		for _, k := range schema {
+			dup := false
+			for _, v := range names {
+				dup = dup || v == k.AttributeName
+			}
+			if !dup {
+				names = append(names, k.AttributeName)
+			}
+		}
+	}
+	add(desc.KeySchema)
+	for _, v := range desc.GlobalSecondaryIndexes {
+		if v.IndexName == indexName {
+			add(v.KeySchema)
+		}
+	}
+	for _, v := range desc.LocalSecondaryIndexes {
+		if v.IndexName == indexName {
+			add(v.KeySchema)
+		}
+	}
+	return names
+}
@@ -28,9 +28,9 @@ type tLocalIndex struct {
 	keySchemaProxy iKeySchema
 }
 
-func makeLocalIndex(tableName string, lidef *dynamodb.LocalSecondaryIndexT) iLocalIndex {
+func makeLocalIndex(tableName string, tableKeySchema iKeySchema, lidef *dynamodb.LocalSecondaryIndexT) iLocalIndex {
 	schema := makeLocalIndexKeySchemaProxy(lidef)
-	idx := tIndex{lidef.IndexName, tableName, schema}
+	idx := tIndex{lidef.IndexName, tableName, schema, tableKeySchema, false}
 	return &tLocalIndex{idx, lidef, schema}
 }
 
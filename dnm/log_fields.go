@@ -15,4 +15,9 @@ const (
 	LogSegment              = "segment"
 	LogTotalSegments        = "total_segments"
 	LogLimit                = "limit"
+	LogAttempt              = "attempt"
+	LogUnprocessed          = "unprocessed"
+	LogIndex                = "index"
+	LogChanges              = "changes"
+	LogShard                = "shard"
 )
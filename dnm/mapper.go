@@ -0,0 +1,401 @@
+package dnm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Struct mapper, turns tagged structs into item attributes and back.
+
+Fields are mapped by the "dnm" tag, `dnm:"Name,omitempty,nano"`:
+ - Name overrides the attribute name, by default field name is used
+ - omitempty skips zero values on Marshal
+ - nano stores time.Time with nanosecond precision
+ - `dnm:"-"` skips the field
+
+Supported field types are bool, int, int32, int64, float32, float64, string,
+[]byte, time.Time and pointers to them, anonymous struct fields are flattened.
+*/
+
+const MapperTag = "dnm"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type tField struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	opts      []string
+	omitEmpty bool
+	nano      bool
+}
+
+func MakeMarshalErr(field string, typ reflect.Type) error {
+	return fmt.Errorf("Serialization error: field %s has unsupported type %s", field, typ)
+}
+
+// Marshal uses the legacy encoding of bool and empty string values, SaveItem
+// marshals them as BOOL and NULL on tables with NativeTypes
+func Marshal(v interface{}) ([]dynamodb.Attribute, error) {
+	return marshal(v, false, nil)
+}
+
+// marshal encodes non-key fields natively when native is set, key attributes
+// keep the legacy encoding
+func marshal(v interface{}, native bool, keyNames []string) ([]dynamodb.Attribute, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := structFields(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	attrs := make([]dynamodb.Attribute, 0, len(fields))
+	for _, f := range fields {
+		fv, ok := fieldByIndex(rv, f.index)
+		if !ok {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if f.omitEmpty && isZero(fv) {
+			continue
+		}
+		isKey := false
+		for _, name := range keyNames {
+			isKey = isKey || name == f.name
+		}
+		attrs = append(attrs, marshalField(f, fv, native && !isKey))
+	}
+	return attrs, nil
+}
+
+func Unmarshal(attrs map[string]*dynamodb.Attribute, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("DeSerialization error: expected non-nil pointer to struct, got %T", v)
+	}
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("DeSerialization error: expected non-nil pointer to struct, got %T", v)
+	}
+	fields, err := structFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if _, ok := attrs[f.name]; !ok {
+			continue
+		}
+		fv := allocFieldByIndex(rv, f.index)
+		if fv.Kind() == reflect.Ptr {
+			fv.Set(reflect.New(fv.Type().Elem()))
+			fv = fv.Elem()
+		}
+		if err := unmarshalField(f, attrs, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+Store helpers
+*/
+
+// SaveItem saves the struct, on versioned tables the struct pointed by v gets
+// the version it was saved with
+func SaveItem(store IStore, v interface{}) *TError {
+	opts := storeOptions(store)
+	var keyNames []string
+	if desc := storeTableDesc(store); desc != nil {
+		keyNames = []string{keyAttrName(desc.KeySchema, KeyHash), keyAttrName(desc.KeySchema, KeyRange)}
+	}
+	attrs, err := marshal(v, opts.nativeTypes, keyNames)
+	if err != nil {
+		return wrapError(MarshalErr, err)
+	}
+	if tErr := store.Save(attrs...); tErr != nil {
+		return tErr
+	}
+	if !opts.versioned() || reflect.ValueOf(v).Kind() != reflect.Ptr {
+		return nil
+	}
+	next, _, err := opts.nextVersion(attrs)
+	if err != nil {
+		return wrapError(MarshalErr, err)
+	}
+	for n := range next {
+		if next[n].Name == opts.version {
+			if err := Unmarshal(map[string]*dynamodb.Attribute{opts.version: &next[n]}, v); err != nil {
+				return wrapError(UnmarshalErr, err)
+			}
+		}
+	}
+	return nil
+}
+
+func GetItem(store IStore, key *dynamodb.Key, v interface{}) *TError {
+	if attrs, tErr := store.Get(key); tErr != nil {
+		return tErr
+	} else if err := Unmarshal(attrs, v); err != nil {
+		return wrapError(UnmarshalErr, err)
+	}
+	return nil
+}
+
+// FindItems runs the query and appends found items to the slice pointed by v
+func FindItems(store IStore, query *dynamodb.Query, v interface{}) *TError {
+	sv := reflect.ValueOf(v)
+	if sv.Kind() != reflect.Ptr || sv.Elem().Kind() != reflect.Slice {
+		return wrapError(UnmarshalErr, fmt.Errorf("expected pointer to slice, got %T", v))
+	}
+	sv = sv.Elem()
+	items, tErr := store.Find(query)
+	if tErr != nil {
+		return tErr
+	}
+	elemType := sv.Type().Elem()
+	for _, attrs := range items {
+		var elem reflect.Value
+		if elemType.Kind() == reflect.Ptr {
+			elem = reflect.New(elemType.Elem())
+		} else {
+			elem = reflect.New(elemType)
+		}
+		if err := Unmarshal(attrs, elem.Interface()); err != nil {
+			return wrapError(UnmarshalErr, err)
+		}
+		if elemType.Kind() != reflect.Ptr {
+			elem = elem.Elem()
+		}
+		sv.Set(reflect.Append(sv, elem))
+	}
+	return nil
+}
+
+/**
+Field conversion
+*/
+
+// marshalField encodes bool and empty string values as BOOL and NULL when native is set
+func marshalField(f tField, v reflect.Value, native bool) dynamodb.Attribute {
+	if v.Type() == timeType {
+		if f.nano {
+			return MakeTimeTimeNanoAttr(f.name, v.Interface().(time.Time))
+		} else {
+			return MakeTimeTimeAttr(f.name, v.Interface().(time.Time))
+		}
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if native {
+			return MakeNativeBoolAttr(f.name, v.Bool())
+		}
+		return MakeBoolAttr(f.name, v.Bool())
+	case reflect.Int:
+		return MakeIntAttr(f.name, int(v.Int()))
+	case reflect.Int32:
+		return MakeInt32Attr(f.name, int32(v.Int()))
+	case reflect.Int64:
+		return MakeInt64Attr(f.name, v.Int())
+	case reflect.Float32:
+		return MakeFloat32Attr(f.name, float32(v.Float()))
+	case reflect.Float64:
+		return MakeFloat64Attr(f.name, v.Float())
+	case reflect.String:
+		if native && v.String() == "" {
+			return MakeNullAttr(f.name)
+		}
+		return MakeStringAttr(f.name, v.String())
+	default:
+		// []byte, field types are validated by structFields
+		if v.Len() == 0 {
+			return *dynamodb.NewBinaryAttribute(f.name, NullString)
+		}
+		return MakeBinaryAttr(f.name, v.Bytes())
+	}
+}
+
+func unmarshalField(f tField, attrs map[string]*dynamodb.Attribute, v reflect.Value) (err error) {
+	if v.Type() == timeType {
+		var t time.Time
+		if f.nano {
+			t, err = GetTimeTimeNanoAttr(f.name, attrs)
+		} else {
+			t, err = GetTimeTimeAttr(f.name, attrs)
+		}
+		v.Set(reflect.ValueOf(t))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		var b bool
+		b, err = GetBoolAttr(f.name, attrs)
+		v.SetBool(b)
+	case reflect.Int:
+		var i int
+		i, err = GetIntAttr(f.name, attrs)
+		v.SetInt(int64(i))
+	case reflect.Int32:
+		var i int32
+		i, err = GetInt32Attr(f.name, attrs)
+		v.SetInt(int64(i))
+	case reflect.Int64:
+		var i int64
+		i, err = GetInt64Attr(f.name, attrs)
+		v.SetInt(i)
+	case reflect.Float32:
+		var fl float32
+		fl, err = GetFloat32Attr(f.name, attrs)
+		v.SetFloat(float64(fl))
+	case reflect.Float64:
+		var fl float64
+		fl, err = GetFloat64Attr(f.name, attrs)
+		v.SetFloat(fl)
+	case reflect.String:
+		var s string
+		s, err = GetStringAttr(f.name, attrs)
+		v.SetString(s)
+	default:
+		var b []byte
+		b, err = GetBinaryAttr(f.name, attrs)
+		v.SetBytes(b)
+	}
+	return
+}
+
+/**
+Reflection helpers
+*/
+
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return rv, fmt.Errorf("Serialization error: expected struct, got %T", v)
+	}
+	return rv, nil
+}
+
+func supportedFieldType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+func parseTag(tag string) (name string, opts []string) {
+	parts := strings.Split(tag, ",")
+	return parts[0], parts[1:]
+}
+
+func structFields(t reflect.Type) ([]tField, error) {
+	fields := []tField{}
+	for n := 0; n < t.NumField(); n++ {
+		sf := t.Field(n)
+		tag := sf.Tag.Get(MapperTag)
+		if tag == "-" {
+			continue
+		}
+		name, opts := parseTag(tag)
+		ft := sf.Type
+		if sf.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				embedded, err := structFields(ft)
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range embedded {
+					f.index = append([]int{n}, f.index...)
+					fields = append(fields, f)
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			// unexported field
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if !supportedFieldType(ft) {
+			return nil, MakeMarshalErr(sf.Name, ft)
+		}
+		f := tField{name: name, index: []int{n}, typ: ft, opts: opts}
+		for _, o := range opts {
+			switch o {
+			case "omitempty":
+				f.omitEmpty = true
+			case "nano":
+				f.nano = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// fieldByIndex walks embedded structs, reports false when embedded pointer is nil
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for n, i := range index {
+		if n > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+func allocFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for n, i := range index {
+		if n > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+func isZero(v reflect.Value) bool {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).IsZero()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
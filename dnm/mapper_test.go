@@ -0,0 +1,179 @@
+package dnm_test
+
+import (
+	"time"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type tAudit struct {
+	Created time.Time `dnm:"Created,nano"`
+	Updated *time.Time
+}
+
+type tSession struct {
+	Id        string `dnm:"Id"`
+	UserId    string
+	UserAgent string  `dnm:",omitempty"`
+	Active    bool    `dnm:"Active"`
+	Logins    int     `dnm:"Logins"`
+	Port      int32   `dnm:"Port"`
+	Bytes     int64   `dnm:"Bytes"`
+	Ratio     float32 `dnm:"Ratio"`
+	Score     float64 `dnm:"Score"`
+	Token     []byte  `dnm:"Token"`
+	Secret    string  `dnm:"-"`
+	tAudit
+}
+
+var _ = Describe("Mapper", func() {
+	It("should round trip versioned structs", func() {
+		d := dnm.DescribeStruct("MappedNotes", tVersionedNote{})
+		store := dnm.MakeMemStore(&d)
+		note := tVersionedNote{Id: "note:1", Text: "draft"}
+		Expect(dnm.SaveItem(store, &note)).To(BeNil())
+		Expect(note.Version).To(Equal(int64(1)))
+		note.Text = "published"
+		Expect(dnm.SaveItem(store, &note)).To(BeNil())
+		Expect(note.Version).To(Equal(int64(2)))
+
+		var read tVersionedNote
+		key := dynamodb.Key{HashKey: "note:1"}
+		Expect(dnm.GetItem(store, &key, &read)).To(BeNil())
+		Expect(read).To(Equal(note))
+
+		stale := read
+		stale.Version = 1
+		Expect(dnm.SaveItem(store, &stale).IsVersionConflict()).To(BeTrue())
+		Expect(stale.Version).To(Equal(int64(1)))
+		Expect(dnm.SaveItem(store, &tVersionedNote{Id: "note:1"}).IsVersionConflict()).To(BeTrue())
+	})
+
+	now := time.Now()
+	session := tSession{
+		Id:     "sid:1",
+		UserId: "uid:1",
+		Active: true,
+		Logins: 3,
+		Port:   8080,
+		Bytes:  1 << 40,
+		Ratio:  0.5,
+		Score:  0.25,
+		Token:  []byte("token"),
+		Secret: "secret",
+		tAudit: tAudit{Created: now, Updated: &now},
+	}
+
+	Context("Marshal", func() {
+		attrs, err := dnm.Marshal(&session)
+		item := map[string]*dynamodb.Attribute{}
+		for n := range attrs {
+			item[attrs[n].Name] = &attrs[n]
+		}
+
+		It("should not fail", func() {
+			Expect(err).To(BeNil())
+		})
+		It("should use tag names and field names", func() {
+			Expect(item).To(HaveKey("Id"))
+			Expect(item).To(HaveKey("UserId"))
+			Expect(item).To(HaveKey("Updated"))
+		})
+		It("should skip ignored and empty omitempty fields", func() {
+			Expect(item).ToNot(HaveKey("Secret"))
+			Expect(item).ToNot(HaveKey("UserAgent"))
+		})
+		It("should reuse attribute converters", func() {
+			Expect(item["Active"].Value).To(Equal(dnm.DynamoBoolTrue))
+			Expect(item["Active"].Type).To(Equal(dnm.Number))
+			Expect(item["Token"].Value).To(Equal(dnm.FromBinary([]byte("token"))))
+			Expect(item["Created"].Value).To(Equal(dnm.FromTimeTimeNano(now)))
+			Expect(item["Updated"].Value).To(Equal(dnm.FromTimeTime(now)))
+		})
+		It("should refuse unsupported field types", func() {
+			_, err := dnm.Marshal(struct{ Tags map[string]string }{})
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Context("Unmarshal", func() {
+		attrs, _ := dnm.Marshal(session)
+		item := map[string]*dynamodb.Attribute{}
+		for n := range attrs {
+			item[attrs[n].Name] = &attrs[n]
+		}
+		var restored tSession
+		err := dnm.Unmarshal(item, &restored)
+
+		It("should restore every mapped field", func() {
+			Expect(err).To(BeNil())
+			Expect(restored.Id).To(Equal(session.Id))
+			Expect(restored.Active).To(BeTrue())
+			Expect(restored.Logins).To(Equal(session.Logins))
+			Expect(restored.Port).To(Equal(session.Port))
+			Expect(restored.Bytes).To(Equal(session.Bytes))
+			Expect(restored.Ratio).To(Equal(session.Ratio))
+			Expect(restored.Score).To(Equal(session.Score))
+			Expect(restored.Token).To(Equal(session.Token))
+			Expect(restored.Secret).To(BeEmpty())
+			Expect(restored.Created.UnixNano()).To(Equal(now.UnixNano()))
+			Expect(restored.Updated.Unix()).To(Equal(now.Unix()))
+		})
+		It("should follow the NullString convention", func() {
+			attr := dnm.MakeStringAttr("UserAgent", "")
+			var s tSession
+			Expect(dnm.Unmarshal(map[string]*dynamodb.Attribute{"UserAgent": &attr}, &s)).To(BeNil())
+			Expect(attr.Value).To(Equal(dnm.NullString))
+			Expect(s.UserAgent).To(BeEmpty())
+		})
+		It("should require a pointer", func() {
+			Expect(dnm.Unmarshal(item, restored)).ToNot(BeNil())
+		})
+	})
+
+	Context("Store helpers", func() {
+		var (
+			id, userId dnm.IAttr
+			pk         dnm.IKeyFactory
+			userIndex  dnm.IIndex
+		)
+		d := dnm.Describe("Sessions", func(t dnm.ITable) {
+			id = t.KeyAttr("Id", dnm.String)
+			userId = t.KeyAttr("UserId", dnm.String)
+			{
+				p := t.PrimaryKey()
+				p.Hash(id)
+				pk = p.Factory()
+			}
+			{
+				idx := t.GlobalIndex("UserIndex")
+				idx.Hash(userId)
+				idx.Range(id)
+				idx.Projection().All()
+				userIndex = idx
+			}
+		})
+		store := dnm.MakeMemStore(&d)
+
+		It("should save and get struct", func() {
+			Expect(dnm.SaveItem(store, &session)).To(BeNil())
+			key := pk.Key(id.Is("sid:1"))
+			var s tSession
+			Expect(dnm.GetItem(store, &key, &s)).To(BeNil())
+			Expect(s.UserId).To(Equal("uid:1"))
+		})
+		It("should find structs", func() {
+			other := session
+			other.Id = "sid:2"
+			Expect(dnm.SaveItem(store, session)).To(BeNil())
+			Expect(dnm.SaveItem(store, other)).To(BeNil())
+			var sessions []*tSession
+			Expect(dnm.FindItems(store, userIndex.Where(userId.Equals("uid:1")), &sessions)).To(BeNil())
+			Expect(sessions).To(HaveLen(2))
+			Expect(sessions[1].Id).To(Equal("sid:2"))
+		})
+	})
+})
@@ -0,0 +1,576 @@
+package dnm
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+In-memory IStore implementation, intended for tests and local development.
+It honors the table description produced by dnm.Describe: primary key hash/range
+semantics, local and global secondary indexes (including projections) and
+conditional writes.
+*/
+
+type TMemStore struct {
+	tableDesc *dynamodb.TableDescriptionT
+	opts      *tTableOptions
+	items     map[tMemKey]map[string]*dynamodb.Attribute
+	lock      sync.RWMutex
+}
+
+type tMemKey struct {
+	hash string
+	rang string
+}
+
+type tMemIndex struct {
+	hash       string
+	rang       string
+	projection *dynamodb.ProjectionT
+}
+
+func MakeMemStore(desc *TTableDescription) IStore {
+	return &TMemStore{tableDesc: &desc.TableDescriptionT, opts: desc.options(), items: map[tMemKey]map[string]*dynamodb.Attribute{}}
+}
+
+func (self *TMemStore) Init() *TError {
+	log.WithField(LogTable, self.tableDesc.TableName).Debug("Initializing dnm.TMemStore")
+	if self.hashName() == "" {
+		return self.makeError(InitGeneralErr, "Init", fmt.Errorf("hash key is not defined"))
+	}
+	return nil
+}
+
+func (self *TMemStore) Destroy() *TError {
+	log.WithField(LogTable, self.tableDesc.TableName).Debug("Destroying in-memory table")
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.items = map[tMemKey]map[string]*dynamodb.Attribute{}
+	return nil
+}
+
+func (self *TMemStore) Get(key *dynamodb.Key) (map[string]*dynamodb.Attribute, *TError) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if item, ok := self.items[self.memKey(key)]; ok && !self.opts.expired(item, time.Now()) {
+		return copyItem(item), nil
+	} else {
+		return nil, self.makeError(NotFoundErr, "Get", dynamodb.ErrNotFound)
+	}
+}
+
+func (self *TMemStore) Find(query IQuery) ([]map[string]*dynamodb.Attribute, *TError) {
+	q, err := parseMemQuery(query)
+	if err != nil {
+		return nil, self.makeError(LookupErr, "Find", err)
+	}
+	items, _, err := self.query(q)
+	if err != nil {
+		return nil, self.makeError(LookupErr, "Find", err)
+	}
+	return self.opts.unexpired(items), nil
+}
+
+func (self *TMemStore) Save(attrs ...dynamodb.Attribute) *TError {
+	return self.SaveConditional(attrs, nil)
+}
+
+func (self *TMemStore) SaveConditional(attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TError {
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		var err error
+		if attrs, check, err = self.opts.versionSave(self.hashName(), attrs); err != nil {
+			return self.makeError(SaveErr, "SaveConditional", err)
+		}
+	}
+	return self.put(attrs, expected, check)
+}
+
+// put stores the item conditioned on expected attributes and the version
+// check, which is nil for unversioned writes
+func (self *TMemStore) put(attrs []dynamodb.Attribute, expected []dynamodb.Attribute, check *dynamodb.Attribute) *TError {
+	item := makeItem(attrs)
+	key, err := self.itemKey(item)
+	if err != nil {
+		return self.makeError(SaveErr, "SaveConditional", err)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if existing := self.items[key]; !matchExpected(existing, withVersion(expected, check)) {
+		return self.conditionalError("SaveConditional", existing, check, fmt.Errorf("item doesn't match expected attributes"))
+	}
+	self.items[key] = item
+	return nil
+}
+
+func (self *TMemStore) SaveConditionalWithConditionExpression(attrs []dynamodb.Attribute, condition *dynamodb.ConditionExpression) *TError {
+	if condition != nil {
+		return self.makeError(NotSupportedErr, "SaveConditionalWithConditionExpression", fmt.Errorf("condition expressions are not supported"))
+	}
+	return self.SaveConditional(attrs, nil)
+}
+
+func (self *TMemStore) UpdateWithUpdateExpression(key *dynamodb.Key, returnValues string,
+	attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
+	return nil, self.makeError(NotSupportedErr, "UpdateWithUpdateExpression", fmt.Errorf("update expressions are not supported"))
+}
+
+func (self *TMemStore) UpdateConditionalWithUpdateExpression(key *dynamodb.Key, condition *dynamodb.ConditionExpression,
+	returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
+	return nil, self.makeError(NotSupportedErr, "UpdateConditionalWithUpdateExpression", fmt.Errorf("update expressions are not supported"))
+}
+
+func (self *TMemStore) DeleteAttributesWithUpdateExpression(key *dynamodb.Key, returnValues string,
+	attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
+	return nil, self.makeError(NotSupportedErr, "DeleteAttributesWithUpdateExpression", fmt.Errorf("update expressions are not supported"))
+}
+
+func (self *TMemStore) ModifyAttributesWithUpdateExpression(key *dynamodb.Key, condition *dynamodb.ConditionExpression,
+	actions []string, returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
+	return nil, self.makeError(NotSupportedErr, "ModifyAttributesWithUpdateExpression", fmt.Errorf("update expressions are not supported"))
+}
+
+func (self *TMemStore) Update(key *dynamodb.Key, attrs ...dynamodb.Attribute) *TError {
+	return self.UpdateConditional(key, attrs, nil)
+}
+
+func (self *TMemStore) UpdateConditional(key *dynamodb.Key, attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TError {
+	for _, v := range attrs {
+		if v.Name == self.hashName() || v.Name == self.rangeName() {
+			return self.makeError(UpdateErr, "UpdateConditional", fmt.Errorf("cannot update attribute %s, this attribute is part of the key", v.Name))
+		}
+	}
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		var err error
+		if attrs, check, err = self.opts.versionUpdate(attrs); err != nil {
+			return self.makeError(UpdateErr, "UpdateConditional", err)
+		}
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	mk := self.memKey(key)
+	existing := self.items[mk]
+	if !matchExpected(existing, withVersion(expected, check)) {
+		return self.conditionalError("UpdateConditional", existing, check, fmt.Errorf("item doesn't match expected attributes"))
+	}
+	var item map[string]*dynamodb.Attribute
+	if existing != nil {
+		item = copyItem(existing)
+	} else {
+		item = self.keyItem(key)
+	}
+	for k, v := range makeItem(attrs) {
+		item[k] = v
+	}
+	self.items[mk] = item
+	return nil
+}
+
+func (self *TMemStore) Delete(key *dynamodb.Key) *TError {
+	return self.DeleteConditional(key, nil)
+}
+
+func (self *TMemStore) DeleteConditional(key *dynamodb.Key, expected []dynamodb.Attribute) *TError {
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		var err error
+		if check, expected, err = self.opts.versionDelete(expected); err != nil {
+			return self.makeError(DeleteErr, "DeleteConditional", err)
+		}
+	}
+	return self.remove(key, expected, check)
+}
+
+func (self *TMemStore) remove(key *dynamodb.Key, expected []dynamodb.Attribute, check *dynamodb.Attribute) *TError {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	mk := self.memKey(key)
+	if existing := self.items[mk]; !matchExpected(existing, withVersion(expected, check)) {
+		return self.conditionalError("DeleteConditional", existing, check, fmt.Errorf("item doesn't match expected attributes"))
+	}
+	delete(self.items, mk)
+	return nil
+}
+
+// Items are spread across segments by the hash of their hash key, pages are
+// limited by the number of evaluated items, same as DynamoDB does.
+func (self *TMemStore) ParallelScanPartialLimit(attributeComparisons []dynamodb.AttributeComparison, exclusiveStartKey *dynamodb.Key,
+	segment, totalSegments int, limit int64) ([]map[string]*dynamodb.Attribute, *dynamodb.Key, *TError) {
+
+	if totalSegments < 1 || segment < 0 || segment >= totalSegments {
+		err := fmt.Errorf("invalid segment %d of %d", segment, totalSegments)
+		return nil, nil, self.makeError(LookupErr, "ParallelScanPartialLimit", err)
+	}
+	self.lock.RLock()
+	candidates := []map[string]*dynamodb.Attribute{}
+	for k, v := range self.items {
+		if memSegment(k.hash, totalSegments) == segment {
+			candidates = append(candidates, v)
+		}
+	}
+	self.lock.RUnlock()
+
+	order := []string{self.hashName(), self.rangeName()}
+	sortItems(candidates, order, true)
+	if exclusiveStartKey != nil {
+		candidates = skipItems(candidates, self.keyItem(exclusiveStartKey), order, true)
+	}
+	// no last key when the page ends on the last item, there is nothing to resume
+	var last *dynamodb.Key
+	if limit > 0 && int64(len(candidates)) > limit {
+		candidates = candidates[:limit]
+		key := self.tableKey(candidates[limit-1])
+		last = &key
+	}
+	items := []map[string]*dynamodb.Attribute{}
+	for _, v := range candidates {
+		if matchComparisons(v, attributeComparisons) {
+			items = append(items, copyItem(v))
+		}
+	}
+	return items, last, nil
+}
+
+func (self *TMemStore) makeError(tErr *TError, op string, details error) *TError {
+	return makeOpError(tErr, self.tableDesc.TableName, op, details)
+}
+
+/**
+Query evaluation
+*/
+
+type tMemCondition struct {
+	AttributeValueList []map[string]string
+	ComparisonOperator string
+}
+
+type tMemQuery struct {
+	TableName         string
+	IndexName         string
+	KeyConditions     map[string]tMemCondition
+	QueryFilter       map[string]tMemCondition
+	AttributesToGet   []string
+	Limit             interface{}
+	ScanIndexForward  interface{}
+	ExclusiveStartKey tWireItem
+}
+
+// queries are decoded from their wire representation, so whatever was put
+// into *dynamodb.Query by tIndex.Where or into TQuery is evaluated the way
+// DynamoDB would
+func parseMemQuery(query IQuery) (*tMemQuery, error) {
+	q := &tMemQuery{}
+	if err := json.Unmarshal([]byte(query.String()), q); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (self *tMemQuery) limit() int64 {
+	switch v := self.Limit.(type) {
+	case float64:
+		return int64(v)
+	case string:
+		var n int64
+		fmt.Sscan(v, &n)
+		return n
+	}
+	return 0
+}
+
+func (self *tMemQuery) forward() bool {
+	switch v := self.ScanIndexForward.(type) {
+	case bool:
+		return v
+	case string:
+		return v != "false"
+	}
+	return true
+}
+
+func (self *tMemQuery) comparisons() []dynamodb.AttributeComparison {
+	return memComparisons(self.KeyConditions)
+}
+
+func (self *tMemQuery) filters() []dynamodb.AttributeComparison {
+	return memComparisons(self.QueryFilter)
+}
+
+func memComparisons(conds map[string]tMemCondition) []dynamodb.AttributeComparison {
+	comparisons := []dynamodb.AttributeComparison{}
+	for name, cond := range conds {
+		comparisons = append(comparisons, dynamodb.AttributeComparison{name,
+			cond.ComparisonOperator,
+			wireAttrs(name, cond.AttributeValueList),
+		})
+	}
+	return comparisons
+}
+
+func (self *tMemQuery) startItem() map[string]*dynamodb.Attribute {
+	if self.ExclusiveStartKey == nil {
+		return nil
+	}
+	return fromWireItem(self.ExclusiveStartKey)
+}
+
+func wireAttrs(name string, vals []map[string]string) []dynamodb.Attribute {
+	attrs := []dynamodb.Attribute{}
+	for _, v := range vals {
+		for typ, val := range v {
+			attrs = append(attrs, dynamodb.Attribute{Type: typ, Name: name, Value: val})
+		}
+	}
+	return attrs
+}
+
+func (self *TMemStore) query(q *tMemQuery) ([]map[string]*dynamodb.Attribute, map[string]*dynamodb.Attribute, error) {
+	return self.queryPage(q, q.startItem(), q.limit())
+}
+
+// queryPage returns items matching key conditions of the query, ordered by index range key,
+// and the key of the last returned item when the page was cut by the limit
+func (self *TMemStore) queryPage(q *tMemQuery, start map[string]*dynamodb.Attribute, limit int64) ([]map[string]*dynamodb.Attribute, map[string]*dynamodb.Attribute, error) {
+	if q.TableName != "" && q.TableName != self.tableDesc.TableName {
+		return nil, nil, fmt.Errorf("query is built for table %s", q.TableName)
+	}
+	idx, err := self.index(q.IndexName)
+	if err != nil {
+		return nil, nil, err
+	}
+	comparisons := q.comparisons()
+	hasHashCond := false
+	for _, c := range comparisons {
+		if c.AttributeName == idx.hash && c.ComparisonOperator == dynamodb.COMPARISON_EQUAL {
+			hasHashCond = true
+		} else if c.AttributeName != idx.rang {
+			return nil, nil, fmt.Errorf("query key condition on %s is not supported by index", c.AttributeName)
+		}
+	}
+	if !hasHashCond {
+		return nil, nil, fmt.Errorf("query must specify an equality condition on hash key %s", idx.hash)
+	}
+
+	self.lock.RLock()
+	candidates := []map[string]*dynamodb.Attribute{}
+	for _, v := range self.items {
+		if idx.contains(v) && matchComparisons(v, comparisons) {
+			candidates = append(candidates, v)
+		}
+	}
+	self.lock.RUnlock()
+
+	forward := q.forward()
+	order := []string{idx.rang, self.hashName(), self.rangeName()}
+	sortItems(candidates, order, forward)
+	if start != nil {
+		candidates = skipItems(candidates, start, order, forward)
+	}
+	var last map[string]*dynamodb.Attribute
+	if limit > 0 && int64(len(candidates)) > limit {
+		candidates = candidates[:limit]
+		last = self.indexKeyItem(idx, candidates[limit-1])
+	}
+	// filters are applied after the limit, like DynamoDB does
+	filters := q.filters()
+	items := make([]map[string]*dynamodb.Attribute, 0, len(candidates))
+	for _, v := range candidates {
+		if matchComparisons(v, filters) {
+			items = append(items, selectAttrs(self.project(idx, v), q.AttributesToGet))
+		}
+	}
+	return items, last, nil
+}
+
+func selectAttrs(item map[string]*dynamodb.Attribute, names []string) map[string]*dynamodb.Attribute {
+	if len(names) == 0 {
+		return item
+	}
+	selected := map[string]*dynamodb.Attribute{}
+	for _, name := range names {
+		if v, ok := item[name]; ok {
+			selected[name] = v
+		}
+	}
+	return selected
+}
+
+func (self *TMemStore) index(name string) (*tMemIndex, error) {
+	if name == "" {
+		return &tMemIndex{self.hashName(), self.rangeName(), nil}, nil
+	}
+	for n, v := range self.tableDesc.GlobalSecondaryIndexes {
+		if v.IndexName == name {
+			return &tMemIndex{keyAttrName(v.KeySchema, KeyHash), keyAttrName(v.KeySchema, KeyRange),
+				&self.tableDesc.GlobalSecondaryIndexes[n].Projection}, nil
+		}
+	}
+	for n, v := range self.tableDesc.LocalSecondaryIndexes {
+		if v.IndexName == name {
+			// local indexes always share hash key with the table
+			return &tMemIndex{self.hashName(), keyAttrName(v.KeySchema, KeyRange),
+				&self.tableDesc.LocalSecondaryIndexes[n].Projection}, nil
+		}
+	}
+	return nil, fmt.Errorf("index %s is not defined", name)
+}
+
+// secondary indexes are sparse, items without index key attributes are not indexed
+func (self *tMemIndex) contains(item map[string]*dynamodb.Attribute) bool {
+	if _, ok := item[self.hash]; !ok {
+		return false
+	}
+	if self.rang != "" {
+		if _, ok := item[self.rang]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *TMemStore) project(idx *tMemIndex, item map[string]*dynamodb.Attribute) map[string]*dynamodb.Attribute {
+	if idx.projection == nil || idx.projection.ProjectionType == ProjectionTypeAll || idx.projection.ProjectionType == "" {
+		return copyItem(item)
+	}
+	projected := self.indexKeyItem(idx, item)
+	if idx.projection.ProjectionType == ProjectionTypeInclude {
+		for _, name := range idx.projection.NonKeyAttributes {
+			if v, ok := item[name]; ok {
+				projected[name] = copyAttr(v)
+			}
+		}
+	}
+	return projected
+}
+
+func (self *TMemStore) indexKeyItem(idx *tMemIndex, item map[string]*dynamodb.Attribute) map[string]*dynamodb.Attribute {
+	key := map[string]*dynamodb.Attribute{}
+	for _, name := range []string{self.hashName(), self.rangeName(), idx.hash, idx.rang} {
+		if v, ok := item[name]; ok && name != "" {
+			key[name] = copyAttr(v)
+		}
+	}
+	return key
+}
+
+/**
+Key helpers
+*/
+
+func (self *TMemStore) hashName() string {
+	return keyAttrName(self.tableDesc.KeySchema, KeyHash)
+}
+
+func (self *TMemStore) rangeName() string {
+	return keyAttrName(self.tableDesc.KeySchema, KeyRange)
+}
+
+func (self *TMemStore) memKey(key *dynamodb.Key) tMemKey {
+	if self.rangeName() == "" {
+		return tMemKey{key.HashKey, ""}
+	}
+	return tMemKey{key.HashKey, key.RangeKey}
+}
+
+func (self *TMemStore) itemKey(item map[string]*dynamodb.Attribute) (tMemKey, error) {
+	if err := validateItemKey(self.tableDesc, item); err != nil {
+		return tMemKey{}, err
+	}
+	key := itemTableKey(self.tableDesc, item)
+	return tMemKey{key.HashKey, key.RangeKey}, nil
+}
+
+func (self *TMemStore) tableKey(item map[string]*dynamodb.Attribute) dynamodb.Key {
+	return itemTableKey(self.tableDesc, item)
+}
+
+func (self *TMemStore) keyItem(key *dynamodb.Key) map[string]*dynamodb.Attribute {
+	return tableKeyItem(self.tableDesc, key)
+}
+
+func memSegment(hash string, totalSegments int) int {
+	h := fnv.New32a()
+	h.Write([]byte(hash))
+	return int(h.Sum32() % uint32(totalSegments))
+}
+
+/**
+Item helpers
+*/
+
+func copyAttr(attr *dynamodb.Attribute) *dynamodb.Attribute {
+	c := *attr
+	if attr.SetValues != nil {
+		c.SetValues = append([]string{}, attr.SetValues...)
+	}
+	return &c
+}
+
+func copyItem(item map[string]*dynamodb.Attribute) map[string]*dynamodb.Attribute {
+	c := make(map[string]*dynamodb.Attribute, len(item))
+	for k, v := range item {
+		c[k] = copyAttr(v)
+	}
+	return c
+}
+
+func makeItem(attrs []dynamodb.Attribute) map[string]*dynamodb.Attribute {
+	item := make(map[string]*dynamodb.Attribute, len(attrs))
+	for n := range attrs {
+		item[attrs[n].Name] = copyAttr(&attrs[n])
+	}
+	return item
+}
+
+// sortItems orders items by given attributes, first attribute takes precedence
+func sortItems(items []map[string]*dynamodb.Attribute, order []string, forward bool) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if forward {
+			return compareItems(items[i], items[j], order) < 0
+		} else {
+			return compareItems(items[i], items[j], order) > 0
+		}
+	})
+}
+
+// skipItems drops sorted items up to and including the start item
+func skipItems(items []map[string]*dynamodb.Attribute, start map[string]*dynamodb.Attribute, order []string, forward bool) []map[string]*dynamodb.Attribute {
+	for n, v := range items {
+		c := compareItems(v, start, order)
+		if (forward && c > 0) || (!forward && c < 0) {
+			return items[n:]
+		}
+	}
+	return []map[string]*dynamodb.Attribute{}
+}
+
+func compareItems(a, b map[string]*dynamodb.Attribute, order []string) int {
+	for _, name := range order {
+		if name == "" {
+			continue
+		}
+		av, aok := a[name]
+		bv, bok := b[name]
+		switch {
+		case !aok && !bok:
+			continue
+		case !aok:
+			return -1
+		case !bok:
+			return 1
+		}
+		if c, err := compareValues(av.Type, av.Value, bv.Value); err == nil && c != 0 {
+			return c
+		}
+	}
+	return 0
+}
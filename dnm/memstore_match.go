@@ -0,0 +1,206 @@
+package dnm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Comparison semantics used by TMemStore, follows DynamoDB rules for
+scalar and set attribute values
+*/
+
+func compareValues(typ, a, b string) (int, error) {
+	switch typ {
+	case dynamodb.TYPE_NUMBER:
+		af, _, aerr := big.ParseFloat(a, 10, 128, big.ToNearestEven)
+		bf, _, berr := big.ParseFloat(b, 10, 128, big.ToNearestEven)
+		if aerr != nil || berr != nil {
+			return 0, fmt.Errorf("invalid numeric values %s, %s", a, b)
+		}
+		return af.Cmp(bf), nil
+	case dynamodb.TYPE_BINARY:
+		ab, aerr := base64.StdEncoding.DecodeString(a)
+		bb, berr := base64.StdEncoding.DecodeString(b)
+		if aerr != nil || berr != nil {
+			return 0, fmt.Errorf("invalid binary values %s, %s", a, b)
+		}
+		return bytes.Compare(ab, bb), nil
+	default:
+		return strings.Compare(a, b), nil
+	}
+}
+
+func isSetType(typ string) bool {
+	return typ == dynamodb.TYPE_STRING_SET || typ == dynamodb.TYPE_NUMBER_SET || typ == dynamodb.TYPE_BINARY_SET
+}
+
+// setElemType returns scalar type of set members, e.g. S for SS
+func setElemType(typ string) string {
+	return strings.TrimSuffix(typ, "S")
+}
+
+func equalValues(typ, a, b string) bool {
+	c, err := compareValues(typ, a, b)
+	return err == nil && c == 0
+}
+
+func setContains(typ string, vals []string, val string) bool {
+	for _, v := range vals {
+		if equalValues(setElemType(typ), v, val) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalAttrs(a, b *dynamodb.Attribute) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if isDocType(a.Type) {
+		return equalDocs(a, b)
+	}
+	if isSetType(a.Type) {
+		if len(a.SetValues) != len(b.SetValues) {
+			return false
+		}
+		for _, v := range a.SetValues {
+			if !setContains(a.Type, b.SetValues, v) {
+				return false
+			}
+		}
+		return true
+	}
+	return equalValues(a.Type, a.Value, b.Value)
+}
+
+func orderAttrs(a, b *dynamodb.Attribute) (int, bool) {
+	if a.Type != b.Type || isSetType(a.Type) || isDocType(a.Type) {
+		return 0, false
+	}
+	c, err := compareValues(a.Type, a.Value, b.Value)
+	return c, err == nil
+}
+
+func containsAttr(attr, val *dynamodb.Attribute) bool {
+	if isSetType(attr.Type) {
+		return setElemType(attr.Type) == val.Type && setContains(attr.Type, attr.SetValues, val.Value)
+	}
+	if attr.Type == List {
+		return listContains(attr, val)
+	}
+	if attr.Type != val.Type {
+		return false
+	}
+	switch attr.Type {
+	case dynamodb.TYPE_STRING:
+		return strings.Contains(attr.Value, val.Value)
+	case dynamodb.TYPE_BINARY:
+		ab, aerr := base64.StdEncoding.DecodeString(attr.Value)
+		vb, verr := base64.StdEncoding.DecodeString(val.Value)
+		return aerr == nil && verr == nil && bytes.Contains(ab, vb)
+	}
+	return false
+}
+
+func beginsWithAttr(attr, val *dynamodb.Attribute) bool {
+	if attr.Type != val.Type {
+		return false
+	}
+	switch attr.Type {
+	case dynamodb.TYPE_STRING:
+		return strings.HasPrefix(attr.Value, val.Value)
+	case dynamodb.TYPE_BINARY:
+		ab, aerr := base64.StdEncoding.DecodeString(attr.Value)
+		vb, verr := base64.StdEncoding.DecodeString(val.Value)
+		return aerr == nil && verr == nil && bytes.HasPrefix(ab, vb)
+	}
+	return false
+}
+
+func matchComparisons(item map[string]*dynamodb.Attribute, comparisons []dynamodb.AttributeComparison) bool {
+	for _, c := range comparisons {
+		if !matchComparison(item, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchComparison(item map[string]*dynamodb.Attribute, c dynamodb.AttributeComparison) bool {
+	attr, ok := lookupPath(item, c.AttributeName)
+	switch c.ComparisonOperator {
+	case dynamodb.COMPARISON_ATTRIBUTE_DOES_NOT_EXIST:
+		return !ok
+	case dynamodb.COMPARISON_ATTRIBUTE_EXISTS:
+		return ok
+	case dynamodb.COMPARISON_NOT_EQUAL:
+		return !ok || len(c.AttributeValueList) != 1 || !equalAttrs(attr, &c.AttributeValueList[0])
+	}
+	if !ok || len(c.AttributeValueList) == 0 {
+		return false
+	}
+	arg := &c.AttributeValueList[0]
+	switch c.ComparisonOperator {
+	case dynamodb.COMPARISON_EQUAL:
+		return equalAttrs(attr, arg)
+	case dynamodb.COMPARISON_LESS_THAN:
+		cmp, ok := orderAttrs(attr, arg)
+		return ok && cmp < 0
+	case dynamodb.COMPARISON_LESS_THAN_OR_EQUAL:
+		cmp, ok := orderAttrs(attr, arg)
+		return ok && cmp <= 0
+	case dynamodb.COMPARISON_GREATER_THAN:
+		cmp, ok := orderAttrs(attr, arg)
+		return ok && cmp > 0
+	case dynamodb.COMPARISON_GREATER_THAN_OR_EQUAL:
+		cmp, ok := orderAttrs(attr, arg)
+		return ok && cmp >= 0
+	case dynamodb.COMPARISON_BETWEEN:
+		if len(c.AttributeValueList) != 2 {
+			return false
+		}
+		lo, lok := orderAttrs(attr, arg)
+		hi, hok := orderAttrs(attr, &c.AttributeValueList[1])
+		return lok && hok && lo >= 0 && hi <= 0
+	case dynamodb.COMPARISON_BEGINS_WITH:
+		return beginsWithAttr(attr, arg)
+	case dynamodb.COMPARISON_CONTAINS:
+		return containsAttr(attr, arg)
+	case dynamodb.COMPARISON_DOES_NOT_CONTAIN:
+		return !containsAttr(attr, arg)
+	case dynamodb.COMPARISON_IN:
+		for n := range c.AttributeValueList {
+			if equalAttrs(attr, &c.AttributeValueList[n]) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// matchExpected evaluates legacy Expected conditions against an existing item,
+// item is nil when there is no item stored under the key
+func matchExpected(item map[string]*dynamodb.Attribute, expected []dynamodb.Attribute) bool {
+	for n := range expected {
+		exp := &expected[n]
+		attr, ok := item[exp.Name]
+		if exp.Exists == "false" {
+			if ok {
+				return false
+			}
+			continue
+		}
+		if !ok || !equalAttrs(attr, exp) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package dnm_test
+
+import (
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MemStore", func() {
+	var (
+		forumName, subject, userId dnm.IAttr
+		created                    dnm.IAttr
+		pkIndex                    dnm.IKeyFactory
+		pkQuery, userIndex         dnm.IIndex
+		subjectIndex               dnm.IIndex
+		store                      dnm.IStore
+	)
+	d := dnm.Describe("Threads", func(t dnm.ITable) {
+		forumName = t.KeyAttr("ForumName", dnm.String)
+		created = t.KeyAttr("Created", dnm.Number)
+		subject = t.KeyAttr("Subject", dnm.String)
+		userId = t.KeyAttr("UserId", dnm.String)
+		{
+			pk := t.PrimaryKey()
+			pk.Hash(forumName)
+			pk.Range(created)
+			pkIndex = pk.Factory()
+			pkQuery = pk
+		}
+		{
+			idx := t.LocalIndex("SubjectIndex")
+			idx.Range(subject)
+			idx.Projection().KeysOnly()
+			subjectIndex = idx
+		}
+		{
+			idx := t.GlobalIndex("UserIndex")
+			idx.Hash(userId)
+			idx.Range(created)
+			idx.Projection().Include(subject)
+			userIndex = idx
+		}
+	})
+
+	save := func(forum, ts, subj, user string) {
+		Expect(store.Save(forumName.Is(forum), created.Is(ts), subject.Is(subj), userId.Is(user))).To(BeNil())
+	}
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+		Expect(store.Init()).To(BeNil())
+		save("go", "1", "generics", "uid:1")
+		save("go", "2", "channels", "uid:2")
+		save("go", "3", "gc", "uid:1")
+		save("rust", "1", "borrowck", "uid:1")
+	})
+
+	Context("Primary key", func() {
+		It("should get saved item by hash and range", func() {
+			key := pkIndex.Key(forumName.Is("go"), created.Is("2"))
+			item, err := store.Get(&key)
+			Expect(err).To(BeNil())
+			Expect(subject.From(item)).To(Equal("channels"))
+		})
+		It("should report missing item", func() {
+			key := pkIndex.Key(forumName.Is("go"), created.Is("42"))
+			_, err := store.Get(&key)
+			Expect(err.IsNotFound()).To(BeTrue())
+		})
+		It("should overwrite item with the same key", func() {
+			save("go", "2", "select", "uid:2")
+			key := pkIndex.Key(forumName.Is("go"), created.Is("2"))
+			item, _ := store.Get(&key)
+			Expect(subject.From(item)).To(Equal("select"))
+		})
+		It("should refuse item without range key", func() {
+			Expect(store.Save(forumName.Is("go"), subject.Is("nope"))).ToNot(BeNil())
+		})
+		It("should delete item", func() {
+			key := pkIndex.Key(forumName.Is("go"), created.Is("2"))
+			Expect(store.Delete(&key)).To(BeNil())
+			_, err := store.Get(&key)
+			Expect(err.IsNotFound()).To(BeTrue())
+		})
+		It("should query range in order", func() {
+			items, err := store.Find(pkQuery.Where(forumName.Equals("go")))
+			Expect(err).To(BeNil())
+			Expect(items).To(HaveLen(3))
+			Expect(created.From(items[0])).To(Equal("1"))
+			Expect(created.From(items[2])).To(Equal("3"))
+		})
+		It("should compare number range keys numerically", func() {
+			save("go", "10", "modules", "uid:3")
+			q := pkQuery.Where(forumName.Equals("go"),
+				dynamodb.AttributeComparison{"Created", dynamodb.COMPARISON_GREATER_THAN, []dynamodb.Attribute{created.Is("2")}})
+			items, err := store.Find(q)
+			Expect(err).To(BeNil())
+			Expect(items).To(HaveLen(2))
+			Expect(created.From(items[1])).To(Equal("10"))
+		})
+	})
+
+	Context("Secondary indexes", func() {
+		It("should query global index with its projection", func() {
+			items, err := store.Find(userIndex.Where(userId.Equals("uid:1")))
+			Expect(err).To(BeNil())
+			Expect(items).To(HaveLen(3))
+			for _, v := range items {
+				Expect(v).To(HaveKey("ForumName"))
+				Expect(v).To(HaveKey("Subject"))
+			}
+		})
+		It("should query local index with keys only projection", func() {
+			items, err := store.Find(subjectIndex.Where(forumName.Equals("go")))
+			Expect(err).To(BeNil())
+			Expect(items).To(HaveLen(3))
+			Expect(subject.From(items[0])).To(Equal("channels"))
+			Expect(items[0]).ToNot(HaveKey("UserId"))
+		})
+		It("should require hash key condition", func() {
+			_, err := store.Find(userIndex.Where(created.Equals("1")))
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Context("Conditional writes", func() {
+		It("should fail save when item is expected to be absent", func() {
+			err := store.SaveConditional(
+				[]dynamodb.Attribute{forumName.Is("go"), created.Is("1"), subject.Is("dup")},
+				[]dynamodb.Attribute{{Name: "ForumName", Exists: "false"}})
+			Expect(err.IsConditional()).To(BeTrue())
+		})
+		It("should update when expected value matches", func() {
+			key := pkIndex.Key(forumName.Is("go"), created.Is("1"))
+			Expect(store.UpdateConditional(&key,
+				[]dynamodb.Attribute{subject.Is("generics v2")},
+				[]dynamodb.Attribute{subject.Is("generics")})).To(BeNil())
+			item, _ := store.Get(&key)
+			Expect(subject.From(item)).To(Equal("generics v2"))
+			Expect(userId.From(item)).To(Equal("uid:1"))
+		})
+		It("should fail delete when expected value differs", func() {
+			key := pkIndex.Key(forumName.Is("go"), created.Is("1"))
+			err := store.DeleteConditional(&key, []dynamodb.Attribute{subject.Is("other")})
+			Expect(err.IsConditional()).To(BeTrue())
+		})
+		It("should refuse to update key attributes", func() {
+			key := pkIndex.Key(forumName.Is("go"), created.Is("1"))
+			Expect(store.Update(&key, forumName.Is("rust"))).ToNot(BeNil())
+		})
+	})
+
+	Context("Parallel scan", func() {
+		It("should visit every item exactly once across segments", func() {
+			seen := map[string]int{}
+			for segment := 0; segment < 3; segment++ {
+				var start *dynamodb.Key
+				for {
+					items, last, err := store.ParallelScanPartialLimit(nil, start, segment, 3, 1)
+					Expect(err).To(BeNil())
+					for _, v := range items {
+						seen[forumName.From(v)+"/"+created.From(v)]++
+					}
+					if last == nil {
+						break
+					}
+					start = last
+				}
+			}
+			Expect(seen).To(HaveLen(4))
+			for _, n := range seen {
+				Expect(n).To(Equal(1))
+			}
+		})
+		It("should end on the page holding the last item", func() {
+			items, last, err := store.ParallelScanPartialLimit(nil, nil, 0, 1, 4)
+			Expect(err).To(BeNil())
+			Expect(items).To(HaveLen(4))
+			Expect(last).To(BeNil())
+			items, last, _ = store.ParallelScanPartialLimit(nil, nil, 0, 1, 3)
+			Expect(items).To(HaveLen(3))
+			Expect(last).ToNot(BeNil())
+			items, last, _ = store.ParallelScanPartialLimit(nil, last, 0, 1, 1)
+			Expect(items).To(HaveLen(1))
+			Expect(last).To(BeNil())
+		})
+		It("should apply attribute comparisons", func() {
+			items, _, err := store.ParallelScanPartialLimit(
+				[]dynamodb.AttributeComparison{userId.Equals("uid:1")}, nil, 0, 1, 0)
+			Expect(err).To(BeNil())
+			Expect(items).To(HaveLen(3))
+		})
+	})
+})
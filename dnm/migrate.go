@@ -0,0 +1,235 @@
+package dnm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Schema migrations, applies the difference between the table definition and the
+live table with UpdateTable. DynamoDB accepts a single index creation or
+deletion per request, so changes are applied step by step, waiting for the
+table and its indexes to become active after each one.
+
+	diff, err := store.Migrate(dnm.TMigrateConfig{})
+
+Incompatible differences are refused before any change is made, indexes which
+are no longer declared are kept unless AllowIndexDeletion is set.
+*/
+
+type TMigrateConfig struct {
+	// delete global indexes the table definition no longer declares
+	AllowIndexDeletion bool
+	// max time to wait for a single step, TableCreateCheckTimeout if zero,
+	// creation of an index on a big table backfills for a long time
+	Timeout time.Duration
+}
+
+func (self *TStore) Migrate(cfg TMigrateConfig) (*TSchemaDiff, *TError) {
+	tableName := self.tableDesc.TableName
+	diff, tErr := self.Diff()
+	if tErr != nil {
+		return nil, tErr
+	}
+	if diff.IsEmpty() {
+		log.WithField(LogTable, tableName).Debug("Table is up to date")
+		return diff, self.migrateSettings()
+	}
+	if !diff.IsCompatible() {
+		err := fmt.Errorf("incompatible changes %s", diff)
+		log.WithFields(log.Fields{
+			LogTable:      tableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in Migrate()")
+		return diff, self.makeError(IncompatibleErr, "Migrate", err)
+	}
+	log.WithFields(log.Fields{
+		LogTable:   tableName,
+		LogChanges: diff.String(),
+	}).Info("Migrating table")
+	if len(diff.Throughput) > 0 {
+		if tErr := self.updateThroughput(cfg, diff.Throughput); tErr != nil {
+			return diff, tErr
+		}
+	}
+	for _, v := range diff.AddedIndexes {
+		if tErr := self.createIndex(cfg, v); tErr != nil {
+			return diff, tErr
+		}
+	}
+	for _, v := range diff.RemovedIndexes {
+		if !cfg.AllowIndexDeletion {
+			log.WithFields(log.Fields{
+				LogTable: tableName,
+				LogIndex: v.IndexName,
+			}).Warn("Index is no longer declared, keeping it as deletion is not allowed")
+			continue
+		}
+		if tErr := self.deleteIndex(cfg, v.IndexName); tErr != nil {
+			return diff, tErr
+		}
+	}
+	log.WithField(LogTable, tableName).Info("Table is migrated")
+	return diff, self.migrateSettings()
+}
+
+// migrateSettings enables table settings which are not part of the schema
+func (self *TStore) migrateSettings() *TError {
+	if tErr := self.enableTTL("Migrate"); tErr != nil {
+		return tErr
+	}
+	return self.enableStream("Migrate")
+}
+
+func (self *TStore) updateThroughput(cfg TMigrateConfig, changes []TThroughputChange) *TError {
+	req := map[string]interface{}{"TableName": self.tableDesc.TableName}
+	updates := []map[string]interface{}{}
+	for _, v := range changes {
+		if v.IndexName == "" {
+			req["ProvisionedThroughput"] = wireThroughput(v.To)
+			continue
+		}
+		updates = append(updates, map[string]interface{}{
+			"Update": map[string]interface{}{
+				"IndexName":             v.IndexName,
+				"ProvisionedThroughput": wireThroughput(v.To),
+			},
+		})
+	}
+	if len(updates) > 0 {
+		req["GlobalSecondaryIndexUpdates"] = updates
+	}
+	log.WithField(LogTable, self.tableDesc.TableName).Info("Updating provisioned throughput")
+	return self.updateTable(cfg, req, func(desc *dynamodb.TableDescriptionT) bool {
+		return indexesActive(desc)
+	})
+}
+
+func (self *TStore) createIndex(cfg TMigrateConfig, idx dynamodb.GlobalSecondaryIndexT) *TError {
+	attrs := []dynamodb.AttributeDefinitionT{}
+	for _, v := range idx.KeySchema {
+		attrs = append(attrs, dynamodb.AttributeDefinitionT{Name: v.AttributeName, Type: tableAttrType(self.tableDesc, v.AttributeName)})
+	}
+	req := map[string]interface{}{
+		"TableName":            self.tableDesc.TableName,
+		"AttributeDefinitions": attrs,
+		"GlobalSecondaryIndexUpdates": []map[string]interface{}{{
+			"Create": map[string]interface{}{
+				"IndexName":             idx.IndexName,
+				"KeySchema":             idx.KeySchema,
+				"Projection":            idx.Projection,
+				"ProvisionedThroughput": wireThroughput(idx.ProvisionedThroughput),
+			},
+		}},
+	}
+	log.WithFields(log.Fields{
+		LogTable: self.tableDesc.TableName,
+		LogIndex: idx.IndexName,
+	}).Info("Creating index")
+	return self.updateTable(cfg, req, func(desc *dynamodb.TableDescriptionT) bool {
+		return findGlobalIndex(desc, idx.IndexName) != nil && indexesActive(desc)
+	})
+}
+
+func (self *TStore) deleteIndex(cfg TMigrateConfig, name string) *TError {
+	req := map[string]interface{}{
+		"TableName": self.tableDesc.TableName,
+		"GlobalSecondaryIndexUpdates": []map[string]interface{}{{
+			"Delete": map[string]interface{}{"IndexName": name},
+		}},
+	}
+	log.WithFields(log.Fields{
+		LogTable: self.tableDesc.TableName,
+		LogIndex: name,
+	}).Info("Deleting index")
+	return self.updateTable(cfg, req, func(desc *dynamodb.TableDescriptionT) bool {
+		return findGlobalIndex(desc, name) == nil && indexesActive(desc)
+	})
+}
+
+// updateTable sends UpdateTable request and polls the table until done reports
+// the change is complete
+func (self *TStore) updateTable(cfg TMigrateConfig, req map[string]interface{}, done func(*dynamodb.TableDescriptionT) bool) *TError {
+	tableName := self.tableDesc.TableName
+	if err := self.rpc("UpdateTable", req, nil); err != nil {
+		log.WithFields(log.Fields{
+			LogTable:      tableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in Migrate()")
+		return self.makeError(MigrateErr, "Migrate", err)
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout, _ = time.ParseDuration(self.cfg.TableCreateCheckTimeout)
+	}
+	checkInterval, _ := time.ParseDuration(self.cfg.TableCreateCheckPollInterval)
+	deadline := time.Now().Add(timeout)
+	for {
+		desc, err := self.describeTable()
+		if errorCode(err) == ErrCodeCancelled {
+			return self.makeError(MigrateErr, "Migrate", err)
+		}
+		if err == nil && done(desc) {
+			log.WithField(LogTable, tableName).Debug("Table is active")
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			if err == nil {
+				err = fmt.Errorf("table %s is not active after %v", tableName, timeout)
+			}
+			log.WithFields(log.Fields{
+				LogTable:      tableName,
+				fhlog.FHError: err.Error(),
+			}).Error("Failed waiting on table")
+			return self.makeError(MigrateTimeoutErr, "Migrate", err)
+		}
+		if err := self.sleep(checkInterval); err != nil {
+			return self.makeError(MigrateErr, "Migrate", err)
+		}
+	}
+}
+
+func wireThroughput(v dynamodb.ProvisionedThroughputT) map[string]int64 {
+	return map[string]int64{
+		"ReadCapacityUnits":  v.ReadCapacityUnits,
+		"WriteCapacityUnits": v.WriteCapacityUnits,
+	}
+}
+
+// indexesActive reports whether the table and all of its global indexes are active
+func indexesActive(desc *dynamodb.TableDescriptionT) bool {
+	if desc.TableStatus != TableStatusActive {
+		return false
+	}
+	for _, v := range desc.GlobalSecondaryIndexes {
+		if v.IndexStatus != TableStatusActive {
+			return false
+		}
+	}
+	return true
+}
+
+func findGlobalIndex(desc *dynamodb.TableDescriptionT, name string) *dynamodb.GlobalSecondaryIndexT {
+	for n := range desc.GlobalSecondaryIndexes {
+		if desc.GlobalSecondaryIndexes[n].IndexName == name {
+			return &desc.GlobalSecondaryIndexes[n]
+		}
+	}
+	return nil
+}
+
+// in-memory table is always created from its definition, there is nothing to migrate
+func (self *TMemStore) Migrate(cfg TMigrateConfig) (*TSchemaDiff, *TError) {
+	return self.Diff()
+}
+
+func (self *tContextStore) Migrate(cfg TMigrateConfig) (*TSchemaDiff, *TError) {
+	if err := self.cancelled("Migrate"); err != nil {
+		return nil, err
+	}
+	return self.store.Migrate(cfg)
+}
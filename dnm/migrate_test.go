@@ -0,0 +1,124 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// tFakeSchemaServer applies UpdateTable requests to the live table, created
+// index stays in CREATING status for one DescribeTable request
+type tFakeSchemaServer struct {
+	lock    sync.Mutex
+	live    dynamodb.TableDescriptionT
+	updates []string
+}
+
+func (self *tFakeSchemaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	target := r.Header.Get("X-Amz-Target")
+	switch {
+	case strings.HasSuffix(target, "DescribeTable"):
+		json.NewEncoder(w).Encode(map[string]interface{}{"Table": self.live})
+		for n := range self.live.GlobalSecondaryIndexes {
+			self.live.GlobalSecondaryIndexes[n].IndexStatus = dnm.TableStatusActive
+		}
+	case strings.HasSuffix(target, "UpdateTable"):
+		var req struct {
+			ProvisionedThroughput       *dynamodb.ProvisionedThroughputT
+			GlobalSecondaryIndexUpdates []map[string]dynamodb.GlobalSecondaryIndexT
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.ProvisionedThroughput != nil {
+			self.live.ProvisionedThroughput = *req.ProvisionedThroughput
+			self.updates = append(self.updates, "throughput")
+		}
+		for _, v := range req.GlobalSecondaryIndexUpdates {
+			if idx, ok := v["Create"]; ok {
+				idx.IndexStatus = dnm.TableStatusCreating
+				self.live.GlobalSecondaryIndexes = append(self.live.GlobalSecondaryIndexes, idx)
+				self.updates = append(self.updates, "create "+idx.IndexName)
+			}
+			if idx, ok := v["Delete"]; ok {
+				indexes := []dynamodb.GlobalSecondaryIndexT{}
+				for _, l := range self.live.GlobalSecondaryIndexes {
+					if l.IndexName != idx.IndexName {
+						indexes = append(indexes, l)
+					}
+				}
+				self.live.GlobalSecondaryIndexes = indexes
+				self.updates = append(self.updates, "delete "+idx.IndexName)
+			}
+		}
+		w.Write([]byte("{}"))
+	}
+}
+
+var _ = Describe("Migrate", func() {
+	define := func(read int64, indexes ...string) dnm.TTableDescription {
+		return dnm.Describe("Threads", func(t dnm.ITable) {
+			forumName := t.KeyAttr("ForumName", dnm.String)
+			pk := t.PrimaryKey()
+			pk.Hash(forumName)
+			t.ProvisionedThroughput().ReadCapacity(read)
+			for _, name := range indexes {
+				idx := t.GlobalIndex(name)
+				idx.Hash(t.KeyAttr(name+"Key", dnm.String))
+				idx.Projection().KeysOnly()
+			}
+		})
+	}
+
+	var (
+		server *httptest.Server
+		fake   *tFakeSchemaServer
+		cfg    *dnm.TStoreConfig
+	)
+	BeforeEach(func() {
+		fake = &tFakeSchemaServer{live: define(1, "OldIndex").TableDescriptionT}
+		fake.live.TableStatus = dnm.TableStatusActive
+		server, cfg = fakeEndpoint(fake)
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should apply throughput and create indexes, keeping undeclared ones", func() {
+		desired := define(5, "NewIndex")
+		store, _ := dnm.MakeStore(&desired, cfg)
+		diff, err := store.Migrate(dnm.TMigrateConfig{})
+		Expect(err).To(BeNil())
+		Expect(diff.RemovedIndexes).To(HaveLen(1))
+		Expect(fake.updates).To(Equal([]string{"throughput", "create NewIndex"}))
+		Expect(fake.live.GlobalSecondaryIndexes).To(HaveLen(2))
+		Expect(fake.live.ProvisionedThroughput.ReadCapacityUnits).To(Equal(int64(5)))
+	})
+
+	It("should delete indexes when allowed", func() {
+		desired := define(1)
+		store, _ := dnm.MakeStore(&desired, cfg)
+		_, err := store.Migrate(dnm.TMigrateConfig{AllowIndexDeletion: true})
+		Expect(err).To(BeNil())
+		Expect(fake.updates).To(Equal([]string{"delete OldIndex"}))
+		diff, _ := store.Diff()
+		Expect(diff.IsEmpty()).To(BeTrue())
+	})
+
+	It("should refuse incompatible changes", func() {
+		desired := dnm.Describe("Threads", func(t dnm.ITable) {
+			t.PrimaryKey().Hash(t.KeyAttr("Id", dnm.String))
+		})
+		store, _ := dnm.MakeStore(&desired, cfg)
+		_, err := store.Migrate(dnm.TMigrateConfig{AllowIndexDeletion: true})
+		Expect(err.Is(dnm.IncompatibleErr)).To(BeTrue())
+		Expect(fake.updates).To(BeEmpty())
+	})
+})
@@ -0,0 +1,190 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type tNativeMember struct {
+	Id       string `dnm:"Id"`
+	Active   bool   `dnm:"Active"`
+	Nickname string `dnm:"Nickname"`
+}
+
+var _ = Describe("Native types", func() {
+	type tBool interface {
+		Is(bool) dynamodb.Attribute
+		From(map[string]*dynamodb.Attribute) (bool, error)
+	}
+	type tString interface {
+		Is(string) dynamodb.Attribute
+		From(map[string]*dynamodb.Attribute) (string, error)
+	}
+	var (
+		id           dnm.IAttr
+		active       tBool
+		nickname     tString
+		legacyActive tBool
+		legacyNick   tString
+		pk           dnm.IKeyFactory
+		pkIndex      dnm.IIndex
+		native       dnm.TTableDescription
+	)
+	native = dnm.Describe("NativeMembers", func(t dnm.ITable) {
+		t.NativeTypes()
+		id = t.KeyAttr("Id", dnm.String)
+		a := t.NonKeyAttr("Active").AsBool()
+		active = &a
+		n := t.NonKeyAttr("Nickname").AsString()
+		nickname = &n
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+		pkIndex = p
+	})
+	dnm.Describe("LegacyMembers", func(t dnm.ITable) {
+		t.PrimaryKey().Hash(t.KeyAttr("Id", dnm.String))
+		a := t.NonKeyAttr("Active").AsBool()
+		legacyActive = &a
+		n := t.NonKeyAttr("Nickname").AsString()
+		legacyNick = &n
+	})
+
+	It("should write native values", func() {
+		Expect(active.Is(true)).To(Equal(dynamodb.Attribute{Type: dnm.Bool, Name: "Active", Value: "true"}))
+		Expect(nickname.Is("").Type).To(Equal(dnm.Null))
+		Expect(nickname.Is("neo").Value).To(Equal("neo"))
+		Expect(legacyActive.Is(true).Value).To(Equal(dnm.DynamoBoolTrue))
+
+		store := dnm.MakeMemStore(&native)
+		Expect(store.Save(id.Is("uid:1"), active.Is(false), nickname.Is(""))).To(BeNil())
+		key := pk.Key(id.Is("uid:1"))
+		item, err := store.Get(&key)
+		Expect(err).To(BeNil())
+		Expect(active.From(item)).To(BeFalse())
+		Expect(nickname.From(item)).To(Equal(""))
+	})
+
+	It("should read both encodings", func() {
+		legacy := map[string]*dynamodb.Attribute{
+			"Active":   {Type: dnm.Number, Name: "Active", Value: dnm.DynamoBoolTrue},
+			"Nickname": {Type: dnm.String, Name: "Nickname", Value: dnm.NullString},
+		}
+		modern := map[string]*dynamodb.Attribute{
+			"Active":   {Type: dnm.Bool, Name: "Active", Value: "true"},
+			"Nickname": {Type: dnm.Null, Name: "Nickname", Value: "true"},
+		}
+		for _, item := range []map[string]*dynamodb.Attribute{legacy, modern} {
+			Expect(active.From(item)).To(BeTrue())
+			Expect(legacyActive.From(item)).To(BeTrue())
+			Expect(dnm.GetBoolAttr("Active", item)).To(BeTrue())
+			Expect(nickname.From(item)).To(Equal(""))
+			Expect(dnm.GetStringAttr("Nickname", item)).To(Equal(""))
+		}
+		Expect(legacyNick.From(modern)).To(Equal(""))
+
+		null := dnm.MakeNullAttr("Active")
+		_, err := active.From(map[string]*dynamodb.Attribute{"Active": &null})
+		Expect(err).To(Equal(dnm.AttrNotFoundErr))
+	})
+
+	It("should put native values as JSON booleans", func() {
+		var req struct {
+			Item map[string]map[string]interface{}
+		}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&req)
+			w.Write([]byte("{}"))
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&native, cfg)
+		Expect(store.Save(id.Is("uid:1"), active.Is(true), nickname.Is(""))).To(BeNil())
+		Expect(req.Item["Active"]).To(Equal(map[string]interface{}{"BOOL": true}))
+		Expect(req.Item["Nickname"]).To(Equal(map[string]interface{}{"NULL": true}))
+		Expect(req.Item["Id"]).To(Equal(map[string]interface{}{"S": "uid:1"}))
+	})
+
+	It("should read and update native values of a table", func() {
+		var req struct {
+			UpdateExpression          string
+			ExpressionAttributeValues map[string]interface{}
+		}
+		item := `{"Id":{"S":"uid:1"},"Active":{"BOOL":true},"Nickname":{"NULL":true}}`
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&req)
+			switch target := r.Header.Get("X-Amz-Target"); {
+			case strings.HasSuffix(target, "GetItem"):
+				fmt.Fprintf(w, `{"Item":%s}`, item)
+			case strings.HasSuffix(target, "Query"):
+				fmt.Fprintf(w, `{"Items":[%s]}`, item)
+			default:
+				fmt.Fprint(w, "{}")
+			}
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&native, cfg)
+		key := pk.Key(id.Is("uid:1"))
+		stored, err := store.Get(&key)
+		Expect(err).To(BeNil())
+		Expect(active.From(stored)).To(BeTrue())
+		Expect(nickname.From(stored)).To(Equal(""))
+		items, err := store.Find(pkIndex.Query().Where(id.Equals("uid:1")))
+		Expect(err).To(BeNil())
+		Expect(items).To(HaveLen(1))
+		Expect(active.From(items[0])).To(BeTrue())
+
+		Expect(store.Update(&key, active.Is(false), nickname.Is(""))).To(BeNil())
+		Expect(req.UpdateExpression).To(Equal("SET #n0 = :v0, #n1 = :v1"))
+		Expect(req.ExpressionAttributeValues).To(Equal(map[string]interface{}{
+			":v0": map[string]interface{}{"BOOL": false},
+			":v1": map[string]interface{}{"NULL": true},
+		}))
+	})
+
+	It("should scan and filter native values of a table", func() {
+		var req struct {
+			ScanFilter map[string]struct {
+				AttributeValueList []map[string]interface{}
+			}
+		}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&req)
+			fmt.Fprint(w, `{"Items":[{"Id":{"S":"uid:1"},"Active":{"BOOL":true},"Nickname":{"NULL":true}}]}`)
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&native, cfg)
+		filter := dynamodb.AttributeComparison{AttributeName: "Active", ComparisonOperator: dynamodb.COMPARISON_EQUAL,
+			AttributeValueList: []dynamodb.Attribute{active.Is(true)}}
+		items, last, err := store.ParallelScanPartialLimit([]dynamodb.AttributeComparison{filter}, nil, 0, 1, 0)
+		Expect(err).To(BeNil())
+		Expect(last).To(BeNil())
+		Expect(req.ScanFilter["Active"].AttributeValueList).To(Equal([]map[string]interface{}{{"BOOL": true}}))
+		Expect(active.From(items[0])).To(BeTrue())
+		Expect(nickname.From(items[0])).To(Equal(""))
+
+		_, tErr := store.(*dnm.TStore).UpdateWithUpdateExpression(&dynamodb.Key{HashKey: "uid:1"}, "")
+		Expect(tErr.Is(dnm.NotSupportedErr)).To(BeTrue())
+	})
+
+	It("should map structs to native values", func() {
+		store := dnm.MakeMemStore(&native)
+		Expect(dnm.SaveItem(store, &tNativeMember{Id: "uid:1"})).To(BeNil())
+		key := pk.Key(id.Is("uid:1"))
+		item, _ := store.Get(&key)
+		Expect(item["Active"].Type).To(Equal(dnm.Bool))
+		Expect(item["Nickname"].Type).To(Equal(dnm.Null))
+		var member tNativeMember
+		Expect(dnm.GetItem(store, &key, &member)).To(BeNil())
+		Expect(member).To(Equal(tNativeMember{Id: "uid:1"}))
+
+		attrs, _ := dnm.Marshal(&tNativeMember{Id: "uid:1"})
+		Expect(attrs[1]).To(Equal(dnm.MakeBoolAttr("Active", false)))
+	})
+})
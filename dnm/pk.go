@@ -28,5 +28,6 @@ func makeTableKeySchema(table *tTable) iKeySchema {
 }
 
 func makePrimaryKey(table *tTable) iPrimaryKey {
-	return &tPrimaryKey{&tIndex{"", table.name, makeTableKeySchema(table)}}
+	schema := makeTableKeySchema(table)
+	return &tPrimaryKey{&tIndex{"", table.name, schema, schema, false}}
 }
@@ -0,0 +1,210 @@
+package dnm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Fluent query builder, started from an index of the table definition
+
+	query := UserIndex.Query().
+		Where(UserId.Equals(userId)).
+		Filter(Status.Equals("active")).
+		Descending().
+		Limit(10).
+		Select(Title, Created)
+	items, err := store.Find(query)
+
+Key conditions and filters are validated against the key schema of the index,
+illegal ones panic the same way IKeyFactory.Key does. Key attributes of the
+table and the index are always returned, so iterator cursors keep working.
+*/
+
+// IQuery is a query built either by IIndex.Where or by IIndex.Query
+type IQuery interface {
+	String() string
+}
+
+const (
+	SelectSpecificAttributes = "SPECIFIC_ATTRIBUTES"
+)
+
+var keyConditionOperators = map[string]bool{
+	dynamodb.COMPARISON_EQUAL:                 true,
+	dynamodb.COMPARISON_LESS_THAN:             true,
+	dynamodb.COMPARISON_LESS_THAN_OR_EQUAL:    true,
+	dynamodb.COMPARISON_GREATER_THAN:          true,
+	dynamodb.COMPARISON_GREATER_THAN_OR_EQUAL: true,
+	dynamodb.COMPARISON_BETWEEN:               true,
+	dynamodb.COMPARISON_BEGINS_WITH:           true,
+}
+
+type TQuery struct {
+	index      *tIndex
+	keyConds   []dynamodb.AttributeComparison
+	filters    []dynamodb.AttributeComparison
+	descending bool
+	limit      int64
+	attrs      []string
+	consistent bool
+}
+
+func makeQuery(index *tIndex) *TQuery {
+	return &TQuery{index: index}
+}
+
+// Where adds key conditions, hash key only accepts equality, range key
+// accepts equality, ordering, BETWEEN and BEGINS_WITH comparisons
+func (self *TQuery) Where(conds ...dynamodb.AttributeComparison) *TQuery {
+	hash, rang := self.index.queryKeyNames()
+	for _, c := range conds {
+		switch c.AttributeName {
+		case hash:
+			if c.ComparisonOperator != dynamodb.COMPARISON_EQUAL {
+				panic(fmt.Sprintf("Illegal query, hash key %s only supports %s condition, got %s", hash, dynamodb.COMPARISON_EQUAL, c.ComparisonOperator))
+			}
+		case rang:
+			if !keyConditionOperators[c.ComparisonOperator] {
+				panic(fmt.Sprintf("Illegal query, range key %s doesn't support %s condition", rang, c.ComparisonOperator))
+			}
+		default:
+			panic(fmt.Sprintf("Illegal query, %s is not a key attribute of the index", c.AttributeName))
+		}
+		for _, v := range self.keyConds {
+			if v.AttributeName == c.AttributeName {
+				panic(fmt.Sprintf("Illegal query, duplicate key condition on %s", c.AttributeName))
+			}
+		}
+		self.keyConds = append(self.keyConds, c)
+	}
+	return self
+}
+
+// Filter adds conditions evaluated after items are read, they can't refer to
+// key attributes of the index and don't reduce consumed capacity
+func (self *TQuery) Filter(conds ...dynamodb.AttributeComparison) *TQuery {
+	hash, rang := self.index.queryKeyNames()
+	for _, c := range conds {
+		if c.AttributeName == hash || c.AttributeName == rang {
+			panic(fmt.Sprintf("Illegal query, filter can't use key attribute %s, use Where instead", c.AttributeName))
+		}
+		self.filters = append(self.filters, c)
+	}
+	return self
+}
+
+// Descending returns items in descending order of the range key
+func (self *TQuery) Descending() *TQuery {
+	self.descending = true
+	return self
+}
+
+// Limit caps number of items evaluated, filtered out items count as well
+func (self *TQuery) Limit(n int64) *TQuery {
+	if n <= 0 {
+		panic(fmt.Sprintf("Illegal query, limit must be positive, got %d", n))
+	}
+	self.limit = n
+	return self
+}
+
+// Select returns only given attributes along with key attributes
+func (self *TQuery) Select(attrs ...AttributeDefinitionProvider) *TQuery {
+	for _, v := range attrs {
+		self.attrs = appendUnique(self.attrs, v.Def().Name)
+	}
+	return self
+}
+
+// ConsistentRead isn't supported by global indexes
+func (self *TQuery) ConsistentRead() *TQuery {
+	if self.index.global {
+		panic(fmt.Sprintf("Illegal query, global index %s doesn't support consistent reads", self.index.name))
+	}
+	self.consistent = true
+	return self
+}
+
+// String returns the request in DynamoDB wire format
+func (self *TQuery) String() string {
+	req := map[string]interface{}{
+		"TableName":     self.index.tableName,
+		"KeyConditions": wireComparisons(self.keyConds),
+	}
+	if self.index.name != "" {
+		req["IndexName"] = self.index.name
+	}
+	if len(self.filters) > 0 {
+		req["QueryFilter"] = wireComparisons(self.filters)
+	}
+	if self.descending {
+		req["ScanIndexForward"] = false
+	}
+	if self.limit > 0 {
+		req["Limit"] = self.limit
+	}
+	if len(self.attrs) > 0 {
+		attrs := append([]string{}, self.attrs...)
+		for _, v := range self.index.keyNames() {
+			attrs = appendUnique(attrs, v)
+		}
+		req["Select"] = SelectSpecificAttributes
+		req["AttributesToGet"] = attrs
+	}
+	if self.consistent {
+		req["ConsistentRead"] = true
+	}
+	data, _ := json.Marshal(req)
+	return string(data)
+}
+
+func wireComparisons(conds []dynamodb.AttributeComparison) map[string]interface{} {
+	res := map[string]interface{}{}
+	for _, c := range conds {
+		vals := []tWireValue{}
+		for n := range c.AttributeValueList {
+			vals = append(vals, toWireValue(&c.AttributeValueList[n]))
+		}
+		res[c.AttributeName] = map[string]interface{}{
+			"AttributeValueList": vals,
+			"ComparisonOperator": c.ComparisonOperator,
+		}
+	}
+	return res
+}
+
+func appendUnique(vals []string, val string) []string {
+	for _, v := range vals {
+		if v == val {
+			return vals
+		}
+	}
+	return append(vals, val)
+}
+
+/**
+Store implementations
+*/
+
+// runQuery sends the query with the wire client, goamz can't run queries
+// with filters and projections
+func (self *TStore) runQuery(query IQuery) ([]map[string]*dynamodb.Attribute, error) {
+	req := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(query.String()), &req); err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Items []tWireItem
+	}
+	if err := self.rpc("Query", req, &resp); err != nil {
+		return nil, err
+	}
+	items := make([]map[string]*dynamodb.Attribute, 0, len(resp.Items))
+	for _, v := range resp.Items {
+		items = append(items, fromWireItem(v))
+	}
+	return items, nil
+}
@@ -0,0 +1,121 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Query builder", func() {
+	var (
+		forumName, created, userId, title dnm.IAttr
+		pkQuery, userIndex                dnm.IIndex
+		store                             dnm.IStore
+	)
+	d := dnm.Describe("Posts", func(t dnm.ITable) {
+		forumName = t.KeyAttr("ForumName", dnm.String)
+		created = t.KeyAttr("Created", dnm.Number)
+		userId = t.KeyAttr("UserId", dnm.String)
+		title = t.NonKeyAttr("Title", dnm.String)
+		{
+			pk := t.PrimaryKey()
+			pk.Hash(forumName)
+			pk.Range(created)
+			pkQuery = pk
+		}
+		{
+			idx := t.GlobalIndex("UserIndex")
+			idx.Hash(userId)
+			idx.Projection().All()
+			userIndex = idx
+		}
+	})
+
+	after := func(n int) dynamodb.AttributeComparison {
+		return dynamodb.AttributeComparison{AttributeName: created.Def().Name,
+			ComparisonOperator: dynamodb.COMPARISON_GREATER_THAN, AttributeValueList: []dynamodb.Attribute{created.Is(fmt.Sprint(n))}}
+	}
+	createdOf := func(items []map[string]*dynamodb.Attribute) []string {
+		res := []string{}
+		for _, v := range items {
+			res = append(res, created.From(v))
+		}
+		return res
+	}
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+		for n := 1; n <= 6; n++ {
+			t := "odd"
+			if n%2 == 0 {
+				t = "even"
+			}
+			Expect(store.Save(forumName.Is("go"), created.Is(fmt.Sprint(n)), userId.Is("uid:1"), title.Is(t))).To(BeNil())
+		}
+	})
+
+	It("should validate key conditions against the index", func() {
+		Expect(func() { pkQuery.Query().Where(userId.Equals("uid:1")) }).To(Panic())
+		Expect(func() { pkQuery.Query().Where(forumName.Equals("go"), forumName.Equals("go")) }).To(Panic())
+		Expect(func() {
+			pkQuery.Query().Where(dynamodb.AttributeComparison{AttributeName: forumName.Def().Name,
+				ComparisonOperator: dynamodb.COMPARISON_BEGINS_WITH, AttributeValueList: []dynamodb.Attribute{forumName.Is("g")}})
+		}).To(Panic())
+		Expect(func() { pkQuery.Query().Filter(created.Equals("1")) }).To(Panic())
+		Expect(func() { userIndex.Query().ConsistentRead() }).To(Panic())
+		Expect(func() { pkQuery.Query().Where(forumName.Equals("go"), after(1)).ConsistentRead() }).ToNot(Panic())
+	})
+
+	It("should filter, order and limit items", func() {
+		q := pkQuery.Query().Where(forumName.Equals("go"), after(1)).Filter(title.Equals("even")).Descending()
+		items, err := store.Find(q)
+		Expect(err).To(BeNil())
+		Expect(createdOf(items)).To(Equal([]string{"6", "4", "2"}))
+
+		// limit counts items evaluated before the filter
+		items, err = store.Find(q.Limit(2))
+		Expect(err).To(BeNil())
+		Expect(createdOf(items)).To(Equal([]string{"6"}))
+	})
+
+	It("should select attributes along with keys", func() {
+		items, err := store.Find(userIndex.Query().Where(userId.Equals("uid:1")).Select(title).Limit(1))
+		Expect(err).To(BeNil())
+		Expect(items).To(HaveLen(1))
+		Expect(items[0]).To(HaveLen(4))
+		Expect(items[0]).To(HaveKey("Title"))
+	})
+
+	It("should iterate up to the query limit", func() {
+		it := store.FindIter(pkQuery.Query().Where(forumName.Equals("go")).Limit(4), 3, 0, "")
+		items := []map[string]*dynamodb.Attribute{}
+		for it.Next() {
+			items = append(items, it.Item())
+		}
+		Expect(it.Err()).To(BeNil())
+		Expect(createdOf(items)).To(Equal([]string{"1", "2", "3", "4"}))
+	})
+
+	It("should send the query to DynamoDB", func() {
+		var req map[string]interface{}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(data, &req)
+			fmt.Fprint(w, `{"Items":[{"ForumName":{"S":"go"},"Created":{"N":"2"}}]}`)
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&d, cfg)
+		items, err := store.Find(pkQuery.Query().Where(forumName.Equals("go")).Filter(title.Equals("even")).ConsistentRead())
+		Expect(err).To(BeNil())
+		Expect(createdOf(items)).To(Equal([]string{"2"}))
+		Expect(req).To(HaveKey("QueryFilter"))
+		Expect(req["ConsistentRead"]).To(BeTrue())
+		Expect(req["TableName"]).To(Equal("Posts"))
+	})
+})
@@ -0,0 +1,128 @@
+package dnm
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/flowhealth/commons/fhlog"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Retry policy of TStore requests. Every DynamoDB request a TStore makes is
+repeated while it fails with a retryable error class, waiting with
+exponential backoff between attempts. Writes which aren't idempotent, i.e.
+conditional writes and updates adding to numbers or appending to lists, are
+repeated only when throttled: a transport or internal server error may come
+after the write was applied, and repeating it would apply it twice or fail
+its condition.
+*/
+
+const (
+	DefaultRetryMaxAttempts = 5
+	DefaultRetryBaseDelay   = 50 * time.Millisecond
+	DefaultRetryMaxDelay    = 5 * time.Second
+	DefaultRetryJitter      = 0.5
+)
+
+type TRetryPolicy struct {
+	// total number of attempts including the first one, 1 disables retries
+	MaxAttempts int
+	// delay before the first retry, doubled on every following one
+	BaseDelay time.Duration
+	// upper bound of a single delay
+	MaxDelay time.Duration
+	// fraction of the delay randomized to spread retries of concurrent clients, 0..1
+	Jitter float64
+	// error classes to retry, throttling, internal server and transport errors if empty
+	Retryable []TErrorCode
+}
+
+func MakeDefaultRetryPolicy() *TRetryPolicy {
+	return &TRetryPolicy{
+		MaxAttempts: DefaultRetryMaxAttempts,
+		BaseDelay:   DefaultRetryBaseDelay,
+		MaxDelay:    DefaultRetryMaxDelay,
+		Jitter:      DefaultRetryJitter,
+	}
+}
+
+func MakeNoRetryPolicy() *TRetryPolicy {
+	return &TRetryPolicy{MaxAttempts: 1}
+}
+
+func (self *TRetryPolicy) attempts() int {
+	if self == nil || self.MaxAttempts < 1 {
+		return 1
+	}
+	return self.MaxAttempts
+}
+
+func (self *TRetryPolicy) isRetryable(err error, idempotent bool) bool {
+	code := errorCode(err)
+	if !idempotent && code != ErrCodeThrottled {
+		return false
+	}
+	if len(self.Retryable) == 0 {
+		return (&TError{Code: code}).IsRetryable()
+	}
+	for _, v := range self.Retryable {
+		if v == code {
+			return true
+		}
+	}
+	return false
+}
+
+// delay before the given retry, attempts are counted from 1
+func (self *TRetryPolicy) delay(attempt int) time.Duration {
+	d := self.BaseDelay
+	for n := 1; n < attempt && (self.MaxDelay <= 0 || d < self.MaxDelay); n++ {
+		d *= 2
+	}
+	if self.MaxDelay > 0 && d > self.MaxDelay {
+		d = self.MaxDelay
+	}
+	if jitter := self.Jitter; jitter > 0 && d > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		spread := time.Duration(float64(d) * jitter)
+		d = d - spread + time.Duration(rand.Int63n(int64(spread)+1))
+	}
+	return d
+}
+
+// retry calls fn until it succeeds, fails with an error the policy doesn't
+// retry or runs out of attempts, the last error is returned
+func (self *TStore) retry(op string, fn func() error) error {
+	return self.retryWrite(op, true, fn)
+}
+
+// retryWrite retries fn as retry does, failures of writes which aren't
+// idempotent are retried only when throttled
+func (self *TStore) retryWrite(op string, idempotent bool, fn func() error) error {
+	policy := self.cfg.Retry
+	for attempt := 1; ; attempt++ {
+		err := callWithContext(self.context(), idempotent, fn)
+		if err == nil || attempt >= policy.attempts() || !policy.isRetryable(err, idempotent) {
+			if err != nil && attempt > 1 {
+				log.WithFields(log.Fields{
+					LogTable:      self.tableDesc.TableName,
+					LogAttempt:    attempt,
+					fhlog.FHError: err.Error(),
+				}).Warn("Giving up retrying " + op + "()")
+			}
+			return err
+		}
+		delay := policy.delay(attempt)
+		log.WithFields(log.Fields{
+			LogTable:      self.tableDesc.TableName,
+			LogAttempt:    attempt,
+			fhlog.FHError: err.Error(),
+		}).Debug("Retrying " + op + "()")
+		if err := self.sleep(delay); err != nil {
+			return err
+		}
+	}
+}
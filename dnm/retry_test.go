@@ -0,0 +1,103 @@
+package dnm_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retry", func() {
+	var (
+		id, visits dnm.IAttr
+		pk         dnm.IKeyFactory
+		cfg        *dnm.TStoreConfig
+	)
+	d := dnm.Describe("Sessions", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		visits = t.NonKeyAttr("Visits", dnm.Number)
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+	})
+
+	// failingServer fails first requests with the given exception
+	failingServer := func(failures int32, typ string) (*httptest.Server, *int32) {
+		var (
+			calls  int32
+			server *httptest.Server
+		)
+		server, cfg = fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) <= failures {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"__type":"com.amazonaws.dynamodb.v20120810#%s","message":"failed"}`, typ)
+				return
+			}
+			fmt.Fprint(w, `{"Responses":{"Sessions":[{"Id":{"S":"sid:1"}}]}}`)
+		}))
+		cfg.Retry = &dnm.TRetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond, Jitter: 0.5}
+		return server, &calls
+	}
+	batchGet := func() []*dnm.TError {
+		store, _ := dnm.MakeStore(&d, cfg)
+		_, errs := store.BatchGet([]dynamodb.Key{pk.Key(id.Is("sid:1"))})
+		return errs
+	}
+
+	It("should retry throttled requests", func() {
+		server, calls := failingServer(2, "ProvisionedThroughputExceededException")
+		defer server.Close()
+		Expect(batchGet()).To(BeNil())
+		Expect(*calls).To(Equal(int32(3)))
+	})
+
+	It("should give up after max attempts", func() {
+		server, calls := failingServer(5, "InternalServerError")
+		defer server.Close()
+		errs := batchGet()
+		Expect(errs[0].IsRetryable()).To(BeTrue())
+		Expect(*calls).To(Equal(int32(3)))
+	})
+
+	It("should not retry validation errors", func() {
+		server, calls := failingServer(5, "ValidationException")
+		defer server.Close()
+		errs := batchGet()
+		Expect(errs[0].Code).To(Equal(dnm.ErrCodeValidation))
+		Expect(*calls).To(Equal(int32(1)))
+	})
+
+	It("should retry non-idempotent writes only when throttled", func() {
+		server, calls := failingServer(5, "InternalServerError")
+		defer server.Close()
+		store, _ := dnm.MakeStore(&d, cfg)
+		err := store.SaveWithCondition([]dynamodb.Attribute{id.Is("sid:1")}, dnm.AttributeNotExists(id))
+		Expect(err.Code).To(Equal(dnm.ErrCodeInternal))
+		Expect(*calls).To(Equal(int32(1)))
+		key := pk.Key(id.Is("sid:1"))
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Add(visits.Is("1")), nil, "")
+		Expect(err.Code).To(Equal(dnm.ErrCodeInternal))
+		Expect(*calls).To(Equal(int32(2)))
+
+		throttled, calls := failingServer(2, "ProvisionedThroughputExceededException")
+		defer throttled.Close()
+		store, _ = dnm.MakeStore(&d, cfg)
+		Expect(store.SaveWithCondition([]dynamodb.Attribute{id.Is("sid:1")}, dnm.AttributeNotExists(id))).To(BeNil())
+		Expect(*calls).To(Equal(int32(3)))
+	})
+
+	It("should retry configured error classes only", func() {
+		server, calls := failingServer(1, "ProvisionedThroughputExceededException")
+		defer server.Close()
+		cfg.Retry.Retryable = []dnm.TErrorCode{dnm.ErrCodeInternal}
+		errs := batchGet()
+		Expect(errs[0].IsThrottled()).To(BeTrue())
+		Expect(*calls).To(Equal(int32(1)))
+	})
+})
@@ -0,0 +1,178 @@
+package dnm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Parallel scan driver, fans out segments of ParallelScanPartialLimit on
+goroutines and follows continuation key of every segment until it's exhausted.
+
+	errs := dnm.ParallelScan(store, dnm.TScanConfig{TotalSegments: 8}, nil,
+		func(item map[string]*dynamodb.Attribute) bool {
+			process(item)
+			return true
+		})
+
+Handler calls are serialized, returning false from the handler or closing the
+stop channel cancels the scan. Errors are reported per segment, a failed
+segment doesn't stop the others. To bind the scan to a context pass
+store.WithContext(ctx) and ctx.Done() as the stop channel.
+*/
+
+const (
+	DefaultScanSegments = 4
+)
+
+type TScanConfig struct {
+	// attribute comparisons applied to every item
+	Comparisons []dynamodb.AttributeComparison
+	// number of segments the table is split into, DefaultScanSegments if zero
+	TotalSegments int
+	// max number of segments scanned at the same time, all of them if zero
+	Concurrency int
+	// max number of items evaluated per request, zero means DynamoDB page size
+	PageSize int64
+}
+
+func (self TScanConfig) segments() int {
+	if self.TotalSegments <= 0 {
+		return DefaultScanSegments
+	}
+	return self.TotalSegments
+}
+
+func (self TScanConfig) workers() int {
+	if self.Concurrency <= 0 || self.Concurrency > self.segments() {
+		return self.segments()
+	}
+	return self.Concurrency
+}
+
+type tScan struct {
+	store   IStore
+	config  TScanConfig
+	stop    <-chan struct{}
+	handler func(map[string]*dynamodb.Attribute) bool
+
+	lock      sync.Mutex
+	cancelled bool
+	done      chan struct{}
+}
+
+// ParallelScan calls handler for every item of the table, errors are indexed
+// by segment and nil when every segment was scanned successfully
+func ParallelScan(store IStore, config TScanConfig, stop <-chan struct{}, handler func(map[string]*dynamodb.Attribute) bool) []*TError {
+	scan := &tScan{store: store, config: config, stop: stop, handler: handler, done: make(chan struct{})}
+	return scan.run()
+}
+
+// ParallelScanChan streams items of the table to a channel, which is closed
+// when the scan is over, then per segment errors are sent to the error channel
+func ParallelScanChan(store IStore, config TScanConfig, stop <-chan struct{}) (<-chan map[string]*dynamodb.Attribute, <-chan []*TError) {
+	items := make(chan map[string]*dynamodb.Attribute)
+	errs := make(chan []*TError, 1)
+	go func() {
+		scan := &tScan{store: store, config: config, stop: stop, done: make(chan struct{})}
+		scan.handler = func(item map[string]*dynamodb.Attribute) bool {
+			select {
+			case items <- item:
+				return true
+			case <-scan.stop:
+				return false
+			}
+		}
+		res := scan.run()
+		close(items)
+		errs <- res
+		close(errs)
+	}()
+	return items, errs
+}
+
+func (self *tScan) run() []*TError {
+	total := self.config.segments()
+	errs := make([]*TError, total)
+	segments := make(chan int, total)
+	for n := 0; n < total; n++ {
+		segments <- n
+	}
+	close(segments)
+
+	var wg sync.WaitGroup
+	for w := 0; w < self.config.workers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for segment := range segments {
+				if self.isCancelled() {
+					return
+				}
+				errs[segment] = self.scanSegment(segment, total)
+			}
+		}()
+	}
+	wg.Wait()
+	return batchErrors(errs)
+}
+
+func (self *tScan) scanSegment(segment, total int) *TError {
+	var start *dynamodb.Key
+	for !self.isCancelled() {
+		items, last, err := self.store.ParallelScanPartialLimit(self.config.Comparisons, start, segment, total, self.config.PageSize)
+		if err.IsNotFound() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, v := range items {
+			if !self.emit(v) {
+				return nil
+			}
+		}
+		if last == nil {
+			return nil
+		}
+		if start != nil && keyId(*start) == keyId(*last) {
+			return wrapError(LookupErr, fmt.Errorf("scan of segment %d is not advancing", segment))
+		}
+		start = last
+	}
+	return nil
+}
+
+func (self *tScan) emit(item map[string]*dynamodb.Attribute) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.cancelled {
+		return false
+	}
+	if !self.handler(item) {
+		self.cancel()
+		return false
+	}
+	return true
+}
+
+func (self *tScan) isCancelled() bool {
+	select {
+	case <-self.stop:
+		return true
+	case <-self.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// cancel is called with lock held
+func (self *tScan) cancel() {
+	if !self.cancelled {
+		self.cancelled = true
+		close(self.done)
+	}
+}
@@ -0,0 +1,100 @@
+package dnm_test
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParallelScan", func() {
+	var (
+		id, kind dnm.IAttr
+		store    dnm.IStore
+	)
+	d := dnm.Describe("Events", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		kind = t.NonKeyAttr("Kind", dnm.String)
+		pk := t.PrimaryKey()
+		pk.Hash(id)
+	})
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+		for n := 0; n < 50; n++ {
+			Expect(store.Save(id.Is(fmt.Sprintf("eid:%d", n)), kind.Is(fmt.Sprint(n%2)))).To(BeNil())
+		}
+	})
+
+	It("should visit every item exactly once", func() {
+		seen := map[string]int{}
+		cfg := dnm.TScanConfig{TotalSegments: 5, Concurrency: 2, PageSize: 3}
+		errs := dnm.ParallelScan(store, cfg, nil, func(item map[string]*dynamodb.Attribute) bool {
+			seen[id.From(item)]++
+			return true
+		})
+		Expect(errs).To(BeNil())
+		Expect(seen).To(HaveLen(50))
+		for _, n := range seen {
+			Expect(n).To(Equal(1))
+		}
+	})
+
+	It("should apply attribute comparisons", func() {
+		cfg := dnm.TScanConfig{Comparisons: []dynamodb.AttributeComparison{kind.Equals("1")}}
+		items, errs := dnm.ParallelScanChan(store, cfg, nil)
+		count := 0
+		for item := range items {
+			Expect(kind.From(item)).To(Equal("1"))
+			count++
+		}
+		Expect(<-errs).To(BeNil())
+		Expect(count).To(Equal(25))
+	})
+
+	It("should stop when handler refuses item", func() {
+		var count int32
+		errs := dnm.ParallelScan(store, dnm.TScanConfig{PageSize: 1}, nil, func(map[string]*dynamodb.Attribute) bool {
+			return atomic.AddInt32(&count, 1) < 10
+		})
+		Expect(errs).To(BeNil())
+		Expect(count).To(Equal(int32(10)))
+	})
+
+	It("should stop when cancelled", func() {
+		stop := make(chan struct{})
+		items, errs := dnm.ParallelScanChan(store, dnm.TScanConfig{PageSize: 1}, stop)
+		<-items
+		close(stop)
+		for range items {
+		}
+		Expect(<-errs).To(BeNil())
+	})
+
+	It("should report errors per segment", func() {
+		failing := &tFailingScanStore{IStore: store, segment: 1}
+		errs := dnm.ParallelScan(failing, dnm.TScanConfig{TotalSegments: 3}, nil, func(map[string]*dynamodb.Attribute) bool {
+			return true
+		})
+		Expect(errs).To(HaveLen(3))
+		Expect(errs[0]).To(BeNil())
+		Expect(errs[1]).To(Equal(dnm.LookupErr))
+		Expect(errs[2]).To(BeNil())
+	})
+})
+
+type tFailingScanStore struct {
+	dnm.IStore
+	segment int
+}
+
+func (self *tFailingScanStore) ParallelScanPartialLimit(comparisons []dynamodb.AttributeComparison, start *dynamodb.Key,
+	segment, totalSegments int, limit int64) ([]map[string]*dynamodb.Attribute, *dynamodb.Key, *dnm.TError) {
+	if segment == self.segment {
+		return nil, nil, dnm.LookupErr
+	}
+	return self.IStore.ParallelScanPartialLimit(comparisons, start, segment, totalSegments, limit)
+}
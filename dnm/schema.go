@@ -0,0 +1,198 @@
+package dnm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Schema diff between a table definition built with Describe and the live
+table. Throughput and global secondary indexes can be changed in place,
+differences of key schema and local secondary indexes can't, they are
+reported as incompatible and require the table to be recreated.
+*/
+
+type TThroughputChange struct {
+	// empty for table throughput
+	IndexName string
+	From      dynamodb.ProvisionedThroughputT
+	To        dynamodb.ProvisionedThroughputT
+}
+
+type TSchemaDiff struct {
+	TableName         string
+	Throughput        []TThroughputChange
+	AddedIndexes      []dynamodb.GlobalSecondaryIndexT
+	RemovedIndexes    []dynamodb.GlobalSecondaryIndexT
+	IncompatibleDiffs []string
+}
+
+func (self *TSchemaDiff) IsEmpty() bool {
+	return len(self.Throughput) == 0 && len(self.AddedIndexes) == 0 && len(self.RemovedIndexes) == 0 &&
+		len(self.IncompatibleDiffs) == 0
+}
+
+// IsCompatible reports whether all differences can be applied to the live table
+func (self *TSchemaDiff) IsCompatible() bool {
+	return len(self.IncompatibleDiffs) == 0
+}
+
+func (self *TSchemaDiff) String() string {
+	changes := []string{}
+	for _, v := range self.Throughput {
+		target := "table"
+		if v.IndexName != "" {
+			target = "index " + v.IndexName
+		}
+		changes = append(changes, fmt.Sprintf("%s throughput %d/%d -> %d/%d", target,
+			v.From.ReadCapacityUnits, v.From.WriteCapacityUnits, v.To.ReadCapacityUnits, v.To.WriteCapacityUnits))
+	}
+	for _, v := range self.AddedIndexes {
+		changes = append(changes, "add index "+v.IndexName)
+	}
+	for _, v := range self.RemovedIndexes {
+		changes = append(changes, "remove index "+v.IndexName)
+	}
+	changes = append(changes, self.IncompatibleDiffs...)
+	return fmt.Sprintf("%s: [%s]", self.TableName, strings.Join(changes, ", "))
+}
+
+// DiffSchema lists changes required to turn the live table into the desired one
+func DiffSchema(desired, live *dynamodb.TableDescriptionT) *TSchemaDiff {
+	diff := &TSchemaDiff{TableName: desired.TableName}
+	if !keySchemaEqual(desired, desired.KeySchema, live, live.KeySchema) {
+		diff.IncompatibleDiffs = append(diff.IncompatibleDiffs, fmt.Sprintf("key schema %s differs from %s",
+			formatKeySchema(desired, desired.KeySchema), formatKeySchema(live, live.KeySchema)))
+	}
+	if !throughputEqual(desired.ProvisionedThroughput, live.ProvisionedThroughput) {
+		diff.Throughput = append(diff.Throughput, TThroughputChange{From: live.ProvisionedThroughput, To: desired.ProvisionedThroughput})
+	}
+	diff.diffLocalIndexes(desired, live)
+	diff.diffGlobalIndexes(desired, live)
+	return diff
+}
+
+func (self *TSchemaDiff) diffLocalIndexes(desired, live *dynamodb.TableDescriptionT) {
+	liveIdx := map[string]dynamodb.LocalSecondaryIndexT{}
+	for _, v := range live.LocalSecondaryIndexes {
+		liveIdx[v.IndexName] = v
+	}
+	for _, v := range desired.LocalSecondaryIndexes {
+		l, ok := liveIdx[v.IndexName]
+		switch {
+		case !ok:
+			self.IncompatibleDiffs = append(self.IncompatibleDiffs, "add local index "+v.IndexName)
+		case !keySchemaEqual(desired, v.KeySchema, live, l.KeySchema) || !projectionEqual(v.Projection, l.Projection):
+			self.IncompatibleDiffs = append(self.IncompatibleDiffs, "change local index "+v.IndexName)
+		}
+		delete(liveIdx, v.IndexName)
+	}
+	for _, v := range live.LocalSecondaryIndexes {
+		if _, ok := liveIdx[v.IndexName]; ok {
+			self.IncompatibleDiffs = append(self.IncompatibleDiffs, "remove local index "+v.IndexName)
+		}
+	}
+}
+
+func (self *TSchemaDiff) diffGlobalIndexes(desired, live *dynamodb.TableDescriptionT) {
+	liveIdx := map[string]dynamodb.GlobalSecondaryIndexT{}
+	for _, v := range live.GlobalSecondaryIndexes {
+		liveIdx[v.IndexName] = v
+	}
+	for _, v := range desired.GlobalSecondaryIndexes {
+		l, ok := liveIdx[v.IndexName]
+		switch {
+		case !ok:
+			self.AddedIndexes = append(self.AddedIndexes, v)
+		case !keySchemaEqual(desired, v.KeySchema, live, l.KeySchema) || !projectionEqual(v.Projection, l.Projection):
+			// global index can be recreated, but not under the same name in a single step
+			self.IncompatibleDiffs = append(self.IncompatibleDiffs, "change global index "+v.IndexName)
+		case !throughputEqual(v.ProvisionedThroughput, l.ProvisionedThroughput):
+			self.Throughput = append(self.Throughput, TThroughputChange{IndexName: v.IndexName, From: l.ProvisionedThroughput, To: v.ProvisionedThroughput})
+		}
+		delete(liveIdx, v.IndexName)
+	}
+	for _, v := range live.GlobalSecondaryIndexes {
+		if _, ok := liveIdx[v.IndexName]; ok {
+			self.RemovedIndexes = append(self.RemovedIndexes, v)
+		}
+	}
+}
+
+func throughputEqual(a, b dynamodb.ProvisionedThroughputT) bool {
+	return a.ReadCapacityUnits == b.ReadCapacityUnits && a.WriteCapacityUnits == b.WriteCapacityUnits
+}
+
+func projectionEqual(a, b dynamodb.ProjectionT) bool {
+	if a.ProjectionType != b.ProjectionType || len(a.NonKeyAttributes) != len(b.NonKeyAttributes) {
+		return false
+	}
+	attrs := map[string]bool{}
+	for _, v := range a.NonKeyAttributes {
+		attrs[v] = true
+	}
+	for _, v := range b.NonKeyAttributes {
+		if !attrs[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// keySchemaEqual compares key attribute names, roles and types
+func keySchemaEqual(aDesc *dynamodb.TableDescriptionT, a []dynamodb.KeySchemaT, bDesc *dynamodb.TableDescriptionT, b []dynamodb.KeySchemaT) bool {
+	return formatKeySchema(aDesc, a) == formatKeySchema(bDesc, b)
+}
+
+func formatKeySchema(desc *dynamodb.TableDescriptionT, schema []dynamodb.KeySchemaT) string {
+	keys := []string{}
+	for _, typ := range []string{KeyHash, KeyRange} {
+		if name := keyAttrName(schema, typ); name != "" {
+			keys = append(keys, fmt.Sprintf("%s %s(%s)", typ, name, tableAttrType(desc, name)))
+		}
+	}
+	return "{" + strings.Join(keys, ", ") + "}"
+}
+
+/**
+Store implementations
+*/
+
+// Diff compares the table definition of the store against the live table
+func (self *TStore) Diff() (*TSchemaDiff, *TError) {
+	live, err := self.describeTable()
+	if err != nil {
+		log.WithFields(log.Fields{
+			LogTable:      self.tableDesc.TableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in Diff()")
+		return nil, self.makeError(DescribeErr, "Diff", err)
+	}
+	return DiffSchema(self.tableDesc, live), nil
+}
+
+func (self *TStore) describeTable() (*dynamodb.TableDescriptionT, error) {
+	var resp struct {
+		Table dynamodb.TableDescriptionT
+	}
+	if err := self.rpc("DescribeTable", map[string]interface{}{"TableName": self.tableDesc.TableName}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Table, nil
+}
+
+// in-memory table is always created from its definition
+func (self *TMemStore) Diff() (*TSchemaDiff, *TError) {
+	return DiffSchema(self.tableDesc, self.tableDesc), nil
+}
+
+func (self *tContextStore) Diff() (*TSchemaDiff, *TError) {
+	if err := self.cancelled("Diff"); err != nil {
+		return nil, err
+	}
+	return self.store.Diff()
+}
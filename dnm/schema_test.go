@@ -0,0 +1,91 @@
+package dnm_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schema diff", func() {
+	define := func(read int64, withUserIndex bool, withSubjectIndex bool) dynamodb.TableDescriptionT {
+		return dnm.Describe("Threads", func(t dnm.ITable) {
+			forumName := t.KeyAttr("ForumName", dnm.String)
+			created := t.KeyAttr("Created", dnm.Number)
+			pk := t.PrimaryKey()
+			pk.Hash(forumName)
+			pk.Range(created)
+			t.ProvisionedThroughput().ReadCapacity(read)
+			if withSubjectIndex {
+				idx := t.LocalIndex("SubjectIndex")
+				idx.Range(t.KeyAttr("Subject", dnm.String))
+				idx.Projection().KeysOnly()
+			}
+			if withUserIndex {
+				idx := t.GlobalIndex("UserIndex")
+				idx.Hash(t.KeyAttr("UserId", dnm.String))
+				idx.Range(created)
+				idx.Projection().All()
+				idx.ProvisionedThroughput().ReadCapacity(read)
+			}
+		}).TableDescriptionT
+	}
+
+	It("should report no changes for the same definition", func() {
+		desired, live := define(1, true, true), define(1, true, true)
+		diff := dnm.DiffSchema(&desired, &live)
+		Expect(diff.IsEmpty()).To(BeTrue())
+	})
+
+	It("should report throughput changes", func() {
+		desired, live := define(5, true, true), define(1, true, true)
+		diff := dnm.DiffSchema(&desired, &live)
+		Expect(diff.IsCompatible()).To(BeTrue())
+		Expect(diff.Throughput).To(HaveLen(2))
+		Expect(diff.Throughput[0].IndexName).To(Equal(""))
+		Expect(diff.Throughput[0].To.ReadCapacityUnits).To(Equal(int64(5)))
+		Expect(diff.Throughput[1].IndexName).To(Equal("UserIndex"))
+	})
+
+	It("should report added and removed global indexes", func() {
+		desired, live := define(1, true, true), define(1, false, true)
+		diff := dnm.DiffSchema(&desired, &live)
+		Expect(diff.IsCompatible()).To(BeTrue())
+		Expect(diff.AddedIndexes).To(HaveLen(1))
+		Expect(diff.AddedIndexes[0].IndexName).To(Equal("UserIndex"))
+
+		diff = dnm.DiffSchema(&live, &desired)
+		Expect(diff.RemovedIndexes).To(HaveLen(1))
+		Expect(diff.RemovedIndexes[0].IndexName).To(Equal("UserIndex"))
+	})
+
+	It("should report incompatible changes", func() {
+		desired, live := define(1, true, false), define(1, true, true)
+		diff := dnm.DiffSchema(&desired, &live)
+		Expect(diff.IsCompatible()).To(BeFalse())
+		Expect(diff.IncompatibleDiffs).To(Equal([]string{"remove local index SubjectIndex"}))
+
+		live.KeySchema = live.KeySchema[:1]
+		diff = dnm.DiffSchema(&desired, &live)
+		Expect(diff.IncompatibleDiffs[0]).To(ContainSubstring("key schema"))
+	})
+
+	It("should diff against the live table", func() {
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"Table":{"TableName":"Threads","TableStatus":"ACTIVE",
+				"AttributeDefinitions":[{"AttributeName":"ForumName","AttributeType":"S"},{"AttributeName":"Created","AttributeType":"N"}],
+				"KeySchema":[{"AttributeName":"ForumName","KeyType":"HASH"},{"AttributeName":"Created","KeyType":"RANGE"}],
+				"ProvisionedThroughput":{"ReadCapacityUnits":1,"WriteCapacityUnits":1}}}`)
+		}))
+		defer server.Close()
+		desired := define(1, true, false)
+		store, _ := dnm.MakeStore(&dnm.TTableDescription{TableDescriptionT: desired}, cfg)
+		diff, err := store.Diff()
+		Expect(err).To(BeNil())
+		Expect(diff.AddedIndexes).To(HaveLen(1))
+		Expect(diff.IsCompatible()).To(BeTrue())
+	})
+})
@@ -0,0 +1,87 @@
+package dnm_test
+
+import (
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Set attributes", func() {
+	var (
+		id     dnm.IAttr
+		labels interface {
+			Is([]string) dynamodb.Attribute
+			From(map[string]*dynamodb.Attribute) ([]string, error)
+			Contains(string) dynamodb.AttributeComparison
+		}
+		scores interface {
+			Is([]int) dynamodb.Attribute
+			From(map[string]*dynamodb.Attribute) ([]int, error)
+			Contains(int) dynamodb.AttributeComparison
+		}
+		digests interface {
+			Is([][]byte) dynamodb.Attribute
+			From(map[string]*dynamodb.Attribute) ([][]byte, error)
+			Contains([]byte) dynamodb.AttributeComparison
+		}
+		pk    dnm.IKeyFactory
+		store dnm.IStore
+	)
+	d := dnm.Describe("Labels", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		l := t.NonKeyAttr("Labels").AsStringSet()
+		labels = &l
+		s := t.NonKeyAttr("Scores").AsIntSet()
+		scores = &s
+		b := t.NonKeyAttr("Digests").AsBinarySet()
+		digests = &b
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+	})
+	key := pk.Key(id.Is("doc:1"))
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+		Expect(store.Save(id.Is("doc:1"), labels.Is([]string{"a", "b", "a"}), scores.Is([]int{1, 2}),
+			digests.Is([][]byte{[]byte("x")}))).To(BeNil())
+	})
+
+	It("should convert sets", func() {
+		item, err := store.Get(&key)
+		Expect(err).To(BeNil())
+		Expect(item["Labels"].Type).To(Equal(dynamodb.TYPE_STRING_SET))
+		Expect(labels.From(item)).To(Equal([]string{"a", "b"}))
+		Expect(scores.From(item)).To(Equal([]int{1, 2}))
+		Expect(digests.From(item)).To(Equal([][]byte{[]byte("x")}))
+
+		Expect(dnm.FromIntSet([]int{3, 3, 4})).To(Equal([]string{"3", "4"}))
+		_, convErr := dnm.GetIntSetAttr("Labels", item)
+		Expect(convErr).ToNot(BeNil())
+		_, convErr = scores.From(map[string]*dynamodb.Attribute{})
+		Expect(convErr).To(Equal(dnm.AttrNotFoundErr))
+	})
+
+	It("should add and remove elements", func() {
+		update := dnm.MakeUpdate().
+			AddToSet(scores.Is([]int{2, 3})).
+			RemoveFromSet(labels.Is([]string{"a", "b"}))
+		item, err := store.UpdateWithCondition(&key, update, dnm.Compare(labels.Contains("a"), scores.Contains(1)), dnm.ReturnValuesAllNew)
+		Expect(err).To(BeNil())
+		Expect(scores.From(item)).To(Equal([]int{1, 2, 3}))
+		Expect(item).ToNot(HaveKey("Labels"))
+
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().AddToSet(scores.Is([]int{4})),
+			dnm.Compare(digests.Contains([]byte("y"))), "")
+		Expect(err.IsConditional()).To(BeTrue())
+	})
+
+	It("should reject illegal sets", func() {
+		Expect(func() { labels.Is(nil) }).To(Panic())
+		Expect(func() { dnm.MakeUpdate().AddToSet(id.Is("a")) }).To(Panic())
+		Expect(func() {
+			dnm.MakeUpdate().RemoveFromSet(dynamodb.Attribute{Type: dynamodb.TYPE_STRING_SET, Name: "Labels"})
+		}).To(Panic())
+	})
+})
@@ -1,12 +1,11 @@
 package dnm
 
 import (
+	"context"
 	"fmt"
 	"github.com/flowhealth/commons/fhlog"
 	"github.com/flowhealth/goamz/aws"
 	"github.com/flowhealth/goamz/dynamodb"
-	"github.com/flowhealth/goannoying"
-	"github.com/flowhealth/gocontract/contract"
 	log "github.com/flowhealth/logrus"
 	"strings"
 	"time"
@@ -15,6 +14,8 @@ import (
 const (
 	TableStatusActive                   = "ACTIVE"
 	TableStatusCreating                 = "CREATING"
+	TableStatusUpdating                 = "UPDATING"
+	TableStatusDeleting                 = "DELETING"
 	DefaultTableCreateCheckTimeout      = "60s"
 	DefaultTableCreateCheckPollInterval = "5s"
 	DefaultReadCapacity                 = 1
@@ -29,28 +30,46 @@ var (
 
 type IStore interface {
 	Get(key *dynamodb.Key) (map[string]*dynamodb.Attribute, *TError)
-	Find(query *dynamodb.Query) ([]map[string]*dynamodb.Attribute, *TError)
+	Find(query IQuery) ([]map[string]*dynamodb.Attribute, *TError)
+	FindIter(query IQuery, pageSize, limit int64, cursor string) IQueryIterator
 	Save(...dynamodb.Attribute) *TError
 	SaveConditional(attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TError
 	SaveConditionalWithConditionExpression(attrs []dynamodb.Attribute, condition *dynamodb.ConditionExpression) *TError
+	SaveWithCondition(attrs []dynamodb.Attribute, cond ICondition) *TError
 	DeleteAttributesWithUpdateExpression(key *dynamodb.Key, returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError)
 	ModifyAttributesWithUpdateExpression(key *dynamodb.Key, condition *dynamodb.ConditionExpression, actions []string, returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError)
 	UpdateWithUpdateExpression(key *dynamodb.Key, returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError)
 	UpdateConditionalWithUpdateExpression(key *dynamodb.Key, condition *dynamodb.ConditionExpression, returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError)
+	UpdateWithCondition(key *dynamodb.Key, update *TUpdate, cond ICondition, returnValues string) (map[string]*dynamodb.Attribute, *TError)
+	Increment(key *dynamodb.Key, attr AttributeDefinitionProvider, delta int64, cfg *TCounterConfig) (int64, *TError)
+	Decrement(key *dynamodb.Key, attr AttributeDefinitionProvider, delta int64, cfg *TCounterConfig) (int64, *TError)
 	Update(key *dynamodb.Key, attrs ...dynamodb.Attribute) *TError
 	UpdateConditional(key *dynamodb.Key, attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TError
 	Delete(key *dynamodb.Key) *TError
 	DeleteConditional(key *dynamodb.Key, expected []dynamodb.Attribute) *TError
+	DeleteWithCondition(key *dynamodb.Key, cond ICondition) *TError
+	DeleteVersioned(key *dynamodb.Key, version string) *TError
 	ParallelScanPartialLimit([]dynamodb.AttributeComparison, *dynamodb.Key, int, int, int64) ([]map[string]*dynamodb.Attribute, *dynamodb.Key, *TError)
+	BatchGet(keys []dynamodb.Key) ([]map[string]*dynamodb.Attribute, []*TError)
+	BatchWrite(reqs ...TWriteRequest) []*TError
 	Init() *TError
 	Destroy() *TError
+	Diff() (*TSchemaDiff, *TError)
+	// Migrate applies compatible differences of the table definition to the
+	// live table, the diff found is returned
+	Migrate(cfg TMigrateConfig) (*TSchemaDiff, *TError)
+	// WithContext returns the store bound to ctx, every operation of the
+	// returned store stops once ctx is done and fails with CancelledErr
+	WithContext(ctx context.Context) IStore
 }
 
 type TStore struct {
 	dynamoServer *dynamodb.Server
 	table        *dynamodb.Table
 	tableDesc    *dynamodb.TableDescriptionT
+	opts         *tTableOptions
 	cfg          *TStoreConfig
+	ctx          context.Context
 }
 
 type TStoreConfig struct {
@@ -58,6 +77,8 @@ type TStoreConfig struct {
 	Region                       aws.Region
 	TableCreateCheckTimeout      string
 	TableCreateCheckPollInterval string
+	// retry policy of every request, no retries if nil
+	Retry *TRetryPolicy
 }
 
 func MakeDefaultStoreConfig() *TStoreConfig {
@@ -65,113 +86,179 @@ func MakeDefaultStoreConfig() *TStoreConfig {
 }
 
 func MakeStoreConfig(auth aws.Auth, region aws.Region, tableCreateTimeout, tableCreatePoll string) *TStoreConfig {
-	return &TStoreConfig{auth, region, tableCreateTimeout, tableCreatePoll}
+	return &TStoreConfig{
+		Auth:                         auth,
+		Region:                       region,
+		TableCreateCheckTimeout:      tableCreateTimeout,
+		TableCreateCheckPollInterval: tableCreatePoll,
+		Retry:                        MakeDefaultRetryPolicy(),
+	}
 }
 
-func MakeStore(tableDesc *dynamodb.TableDescriptionT, cfg *TStoreConfig) IStore {
-	var (
-		auth aws.Auth = cfg.Auth
-		pk   dynamodb.PrimaryKey
-	)
-	contract.RequireNoErrors(
-		func() (err error) {
-			auth, err = aws.GetAuth(auth.AccessKey, auth.SecretKey, auth.Token(), auth.Expiration())
-			return
-		},
-		func() (err error) {
-			pk, err = tableDesc.BuildPrimaryKey()
-			return
-		})
+func MakeStore(desc *TTableDescription, cfg *TStoreConfig) (IStore, *TError) {
+	tableDesc := &desc.TableDescriptionT
+	auth, err := aws.GetAuth(cfg.Auth.AccessKey, cfg.Auth.SecretKey, cfg.Auth.Token(), cfg.Auth.Expiration())
+	if err != nil {
+		log.WithFields(log.Fields{
+			LogTable:      tableDesc.TableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in MakeStore()")
+		return nil, makeOpError(CredentialsErr, tableDesc.TableName, "MakeStore", err)
+	}
+	pk, err := tableDesc.BuildPrimaryKey()
+	if err != nil {
+		log.WithFields(log.Fields{
+			LogTable:      tableDesc.TableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in MakeStore()")
+		return nil, makeOpError(TableDefinitionErr, tableDesc.TableName, "MakeStore", err)
+	}
 	dynamo := dynamodb.Server{auth, cfg.Region}
 	table := dynamo.NewTable(tableDesc.TableName, pk)
-	repo := &TStore{&dynamo, table, tableDesc, cfg}
-	return repo
+	repo := &TStore{dynamoServer: &dynamo, table: table, tableDesc: tableDesc, opts: desc.options(), cfg: cfg}
+	return repo, nil
 }
 
-func (self *TStore) findTableByName(name string) bool {
+func (self *TStore) findTableByName(op, name string) (bool, *TError) {
 	log.WithField(LogTable, name).Debug("Searching for table in table list")
-	tables, err := self.dynamoServer.ListTables()
-	contract.RequireNoError(err)
+	var tables []string
+	err := self.retry("ListTables", func() (err error) {
+		tables, err = self.dynamoServer.ListTables()
+		return
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			LogTable:      name,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in " + op + "()")
+		return false, self.makeError(ListTablesErr, op, err)
+	}
 	for _, t := range tables {
 		if t == name {
-			return true
+			return true, nil
 		}
 	}
 	log.WithField(LogTable, name).Debug("Table not found")
-	return false
+	return false, nil
 }
 
+// Init creates the table unless it exists and waits until it becomes active,
+// waiting is bounded by TableCreateCheckTimeout
 func (self *TStore) Init() *TError {
+	if tErr := self.createTable(); tErr != nil {
+		return tErr
+	}
+	if tErr := self.enableTTL("Init"); tErr != nil {
+		return tErr
+	}
+	return self.enableStream("Init")
+}
+
+func (self *TStore) createTable() *TError {
 	tableName := self.tableDesc.TableName
 	log.WithField(LogTable, tableName).Debug("Initializing dnm.StoreStore")
-	tableExists := self.findTableByName(tableName)
+	tableExists, tErr := self.findTableByName("Init", tableName)
+	if tErr != nil {
+		return tErr
+	}
 	if tableExists {
 		log.WithField(LogTable, tableName).Debug("Waiting until table becomes active")
-		self.waitUntilTableIsActive(tableName)
-		return nil
+		return self.waitUntilTableIsActive(tableName)
 	} else {
 		log.WithField(LogTable, tableName).Info("Creating table")
-		status, err := self.dynamoServer.CreateTable(*self.tableDesc)
+		var status string
+		err := self.retry("Init", func() (err error) {
+			status, err = self.dynamoServer.CreateTable(*self.tableDesc)
+			return
+		})
 		if err != nil {
-			log.WithField(LogTable, tableName).Fatal("Unexpected error during dnm.StoreStore table intialization, cannot proceed")
-			return self.makeError(InitGeneralErr, err)
+			log.WithFields(log.Fields{
+				LogTable:      tableName,
+				fhlog.FHError: err.Error(),
+			}).Error("Error in Init()")
+			return self.makeError(InitGeneralErr, "Init", err)
 		}
 		if status == TableStatusCreating {
 			log.WithField(LogTable, tableName).Debug("Waiting until table becomes active")
-			self.waitUntilTableIsActive(tableName)
-			return nil
+			return self.waitUntilTableIsActive(tableName)
 		}
 		if status == TableStatusActive {
 			log.WithField(LogTable, tableName).Debug("Table is active")
 			return nil
 		}
-		err = fmt.Errorf("Unexpected status: %s during dnm.StoreStore table intialization, cannot proceed", status)
+		err = fmt.Errorf("unexpected table status %s", status)
 		log.WithFields(log.Fields{
-			fhlog.FHError: err,
 			LogTable:      tableName,
-		}).Fatal("Unexpected error during dnm.StoreStore table intialization, cannot proceed")
-		return InitUnknownStatusErr
+			fhlog.FHError: err.Error(),
+		}).Error("Error in Init()")
+		return self.makeError(InitUnknownStatusErr, "Init", err)
 	}
 }
 
-func (self *TStore) waitUntilTableIsActive(table string) {
+func (self *TStore) waitUntilTableIsActive(table string) *TError {
 	checkTimeout, _ := time.ParseDuration(self.cfg.TableCreateCheckTimeout)
 	checkInterval, _ := time.ParseDuration(self.cfg.TableCreateCheckPollInterval)
-	ok, err := annoying.WaitUntil("table active", func() (status bool, err error) {
-		status = false
-		desc, err := self.dynamoServer.DescribeTable(table)
-		if err != nil {
+	deadline := time.Now().Add(checkTimeout)
+	for {
+		var desc *dynamodb.TableDescriptionT
+		err := self.retry("DescribeTable", func() (err error) {
+			desc, err = self.dynamoServer.DescribeTable(table)
 			return
+		})
+		if errorCode(err) == ErrCodeCancelled {
+			return self.makeError(InitGeneralErr, "Init", err)
 		}
-		if desc.TableStatus == TableStatusActive {
-			status = true
-			return
+		if err == nil && desc != nil {
+			switch desc.TableStatus {
+			case TableStatusActive:
+				return nil
+			case TableStatusCreating, TableStatusUpdating:
+			default:
+				err = fmt.Errorf("unexpected table status %s", desc.TableStatus)
+				log.WithFields(log.Fields{
+					LogTable:      table,
+					fhlog.FHError: err.Error(),
+				}).Error("Error in Init()")
+				return self.makeError(InitUnknownStatusErr, "Init", err)
+			}
+		}
+		if !time.Now().Before(deadline) {
+			if err == nil {
+				err = fmt.Errorf("table %s is not active after %v", table, checkTimeout)
+			}
+			log.WithFields(log.Fields{
+				LogTable:      table,
+				fhlog.FHError: err.Error(),
+			}).Error("Failed waiting on table")
+			return self.makeError(InitTimeoutErr, "Init", err)
+		}
+		if err := self.sleep(checkInterval); err != nil {
+			return self.makeError(InitGeneralErr, "Init", err)
 		}
-		return
-	}, checkInterval, checkTimeout)
-	if !ok {
-		log.WithFields(log.Fields{
-			fhlog.FHError: err,
-			LogTable:      table,
-		}).Fatal("Failed waiting on table")
 	}
 }
 
 func (self *TStore) Destroy() *TError {
 	log.WithField(LogTable, self.tableDesc.TableName).Debug("Destroying table")
-	tableExists := self.findTableByName(self.tableDesc.TableName)
+	tableExists, tErr := self.findTableByName("Destroy", self.tableDesc.TableName)
+	if tErr != nil {
+		return tErr
+	}
 	if !tableExists {
 		log.WithField(LogTable, self.tableDesc.TableName).Debug("Table doesn't exists, skipping deletion")
 		return nil
 	} else {
-		_, err := self.dynamoServer.DeleteTable(*self.tableDesc)
+		err := self.retry("Destroy", func() (err error) {
+			_, err = self.dynamoServer.DeleteTable(*self.tableDesc)
+			return
+		})
 		if err != nil {
 			log.WithFields(log.Fields{
 				fhlog.FHError: err,
 				LogTable:      self.tableDesc.TableName,
 			}).Error("Error in Destroy()")
 
-			return self.makeError(DestroyGeneralErr, err)
+			return self.makeError(DestroyGeneralErr, "Destroy", err)
 		}
 		log.WithField(LogTable, self.tableDesc.TableName).Debug("Table deleted successfully")
 	}
@@ -187,20 +274,34 @@ func (self *TStore) DeleteConditional(key *dynamodb.Key, expected []dynamodb.Att
 		LogKey:   key,
 		LogTable: self.tableDesc.TableName,
 	}).Debug("Deleting item with key")
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		var err error
+		if check, expected, err = self.opts.versionDelete(expected); err != nil {
+			return self.makeError(DeleteErr, "DeleteConditional", err)
+		}
+	}
 
-	ok, err := self.table.ConditionalDeleteItem(key, expected)
-	if ok {
+	conditions := withVersion(expected, check)
+	err := self.retryWrite("DeleteConditional", len(conditions) == 0, func() error {
+		ok, err := self.table.ConditionalDeleteItem(key, conditions)
+		if !ok && err == nil {
+			err = fmt.Errorf("item was not deleted")
+		}
+		return err
+	})
+	if err == nil {
 		return nil
 	} else {
-		if strings.HasPrefix(err.Error(), ConditionalDynamoError) {
-			return ConditionalErr
+		if errorCode(err) == ErrCodeConditional {
+			return self.conditionalError("DeleteConditional", key, check, len(expected) > 0, err)
 		}
 		log.WithFields(log.Fields{
 			LogKey:        key,
 			LogTable:      self.tableDesc.TableName,
 			fhlog.FHError: err.Error(),
 		}).Error("Error in DeleteConditional()")
-		return self.makeError(DeleteErr, err)
+		return self.makeError(DeleteErr, "DeleteConditional", err)
 	}
 }
 
@@ -209,43 +310,99 @@ func (self *TStore) Save(attrs ...dynamodb.Attribute) *TError {
 }
 
 func (self *TStore) SaveConditional(attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TError {
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		var err error
+		if attrs, check, err = self.opts.versionSave(keyAttrName(self.tableDesc.KeySchema, KeyHash), attrs); err != nil {
+			return self.makeError(SaveErr, "SaveConditional", err)
+		}
+	}
+	if needsWireCodec(attrs) {
+		return self.putItem("SaveConditional", attrs, expected, check)
+	}
+	conditions := withVersion(expected, check)
 	query := dynamodb.NewQuery(self.table)
 	query.AddItem(attrs)
-	if expected != nil {
-		query.AddExpected(expected)
+	if conditions != nil {
+		query.AddExpected(conditions)
 	}
-	if _, err := self.table.RunPutItemQuery(query); err != nil {
-		if strings.HasPrefix(err.Error(), ConditionalDynamoError) {
-			return ConditionalErr
+	if err := self.retryWrite("SaveConditional", len(conditions) == 0, func() (err error) {
+		_, err = self.table.RunPutItemQuery(query)
+		return
+	}); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			key := itemTableKey(self.tableDesc, makeItem(attrs))
+			return self.conditionalError("SaveConditional", &key, check, len(expected) > 0, err)
 		} else {
 			log.WithFields(log.Fields{
 				LogTable:      self.tableDesc.TableName,
 				fhlog.FHError: err.Error(),
 			}).Error("Error in SaveConditional()")
 
-			return self.makeError(SaveErr, err)
+			return self.makeError(SaveErr, "SaveConditional", err)
 		}
 	} else {
 		return nil
 	}
 }
 
+// putItem saves items with document, BOOL or NULL values by the wire codec
+func (self *TStore) putItem(op string, attrs, expected []dynamodb.Attribute, check *dynamodb.Attribute) *TError {
+	ctx := makeExprContext()
+	conditions := withVersion(expected, check)
+	req := map[string]interface{}{"TableName": self.tableDesc.TableName, "Item": toWireAttrs(attrs)}
+	if len(conditions) > 0 {
+		req["ConditionExpression"] = ctx.expected(conditions)
+	}
+	ctx.apply(req)
+	if err := self.rpcWrite("PutItem", len(conditions) == 0, req, nil); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			key := itemTableKey(self.tableDesc, makeItem(attrs))
+			return self.conditionalError(op, &key, check, len(expected) > 0, err)
+		}
+		log.WithFields(log.Fields{
+			LogTable:      self.tableDesc.TableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in " + op + "()")
+		return self.makeError(SaveErr, op, err)
+	}
+	return nil
+}
+
+// rawExpressions fails for writes by goamz expressions on tables they can't
+// serve, they bypass the version and can't encode BOOL and NULL values
+func (self *tTableOptions) rawExpressions() error {
+	if err := self.unversioned(); err != nil {
+		return err
+	}
+	if self.nativeTypes {
+		return fmt.Errorf("goamz expressions can't encode native types, use SaveWithCondition or UpdateWithCondition")
+	}
+	return nil
+}
+
 func (self *TStore) SaveConditionalWithConditionExpression(attrs []dynamodb.Attribute, condition *dynamodb.ConditionExpression) *TError {
+	if err := self.opts.rawExpressions(); err != nil {
+		return self.makeError(NotSupportedErr, "SaveConditionalWithConditionExpression", err)
+	}
 	query := dynamodb.NewQuery(self.table)
 	query.AddItem(attrs)
 	if condition != nil {
 		query.AddConditionExpression(condition)
 	}
-	if _, err := self.table.RunPutItemQuery(query); err != nil {
-		if strings.HasPrefix(err.Error(), ConditionalDynamoError) {
-			return ConditionalErr
+	if err := self.retryWrite("SaveConditionalWithConditionExpression", condition == nil, func() (err error) {
+		_, err = self.table.RunPutItemQuery(query)
+		return
+	}); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			return self.makeError(ConditionalErr, "SaveConditionalWithConditionExpression", err)
 		} else {
 			log.WithFields(log.Fields{
 				LogTable:      self.tableDesc.TableName,
 				fhlog.FHError: err.Error(),
 			}).Error("Error in SaveConditionalWithConditionExpression()")
 
-			return self.makeError(SaveErr, err)
+			return self.makeError(SaveErr, "SaveConditionalWithConditionExpression", err)
 		}
 	} else {
 		return nil
@@ -254,7 +411,14 @@ func (self *TStore) SaveConditionalWithConditionExpression(attrs []dynamodb.Attr
 
 func (self *TStore) UpdateWithUpdateExpression(key *dynamodb.Key, returnValues string,
 	attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
-	if _, attrs, err := self.table.UpdateAttributesWithUpdateExpression(key, attrs, returnValues); err != nil {
+	if err := self.opts.rawExpressions(); err != nil {
+		return nil, self.makeError(NotSupportedErr, "UpdateWithUpdateExpression", err)
+	}
+	var result map[string]*dynamodb.Attribute
+	if err := self.retry("UpdateWithUpdateExpression", func() (err error) {
+		_, result, err = self.table.UpdateAttributesWithUpdateExpression(key, attrs, returnValues)
+		return
+	}); err != nil {
 		log.WithFields(log.Fields{
 			LogKey:        key,
 			LogAttributes: attrs,
@@ -262,18 +426,25 @@ func (self *TStore) UpdateWithUpdateExpression(key *dynamodb.Key, returnValues s
 			fhlog.FHError: err.Error(),
 		}).Error("Error in UpdateWithUpdateExpression()")
 
-		return nil, self.makeError(UpdateErr, err)
+		return nil, self.makeError(UpdateErr, "UpdateWithUpdateExpression", err)
 	} else {
-		return attrs, nil
+		return result, nil
 	}
 }
 
 func (self *TStore) UpdateConditionalWithUpdateExpression(key *dynamodb.Key, condition *dynamodb.ConditionExpression,
 	returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
 
-	if _, attrs, err := self.table.ConditionalUpdateAttributesWithUpdateExpression(key, attrs, condition, returnValues); err != nil {
-		if strings.HasPrefix(err.Error(), ConditionalDynamoError) {
-			return nil, ConditionalErr
+	if err := self.opts.rawExpressions(); err != nil {
+		return nil, self.makeError(NotSupportedErr, "UpdateConditionalWithUpdateExpression", err)
+	}
+	var result map[string]*dynamodb.Attribute
+	if err := self.retryWrite("UpdateConditionalWithUpdateExpression", condition == nil, func() (err error) {
+		_, result, err = self.table.ConditionalUpdateAttributesWithUpdateExpression(key, attrs, condition, returnValues)
+		return
+	}); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			return nil, self.makeError(ConditionalErr, "UpdateConditionalWithUpdateExpression", err)
 		} else {
 			log.WithFields(log.Fields{
 				LogKey:          key,
@@ -283,18 +454,25 @@ func (self *TStore) UpdateConditionalWithUpdateExpression(key *dynamodb.Key, con
 				LogTable:        self.tableDesc.TableName,
 				fhlog.FHError:   err.Error(),
 			}).Error("Error in UpdateConditionalWithUpdateExpression()")
-			return nil, self.makeError(UpdateErr, err)
+			return nil, self.makeError(UpdateErr, "UpdateConditionalWithUpdateExpression", err)
 		}
 	} else {
-		return attrs, nil
+		return result, nil
 	}
 }
 
 func (self *TStore) DeleteAttributesWithUpdateExpression(key *dynamodb.Key, returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
 
-	if _, attrs, err := self.table.DeleteAttributesWithUpdateExpression(key, attrs, returnValues); err != nil {
-		if strings.HasPrefix(err.Error(), ConditionalDynamoError) {
-			return nil, ConditionalErr
+	if err := self.opts.rawExpressions(); err != nil {
+		return nil, self.makeError(NotSupportedErr, "DeleteAttributesWithUpdateExpression", err)
+	}
+	var result map[string]*dynamodb.Attribute
+	if err := self.retry("DeleteAttributesWithUpdateExpression", func() (err error) {
+		_, result, err = self.table.DeleteAttributesWithUpdateExpression(key, attrs, returnValues)
+		return
+	}); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			return nil, self.makeError(ConditionalErr, "DeleteAttributesWithUpdateExpression", err)
 		} else {
 			log.WithFields(log.Fields{
 				LogKey:          key,
@@ -303,19 +481,27 @@ func (self *TStore) DeleteAttributesWithUpdateExpression(key *dynamodb.Key, retu
 				LogTable:        self.tableDesc.TableName,
 				fhlog.FHError:   err.Error(),
 			}).Error("Error in DeleteAttributesWithUpdateExpression()")
-			return nil, self.makeError(UpdateErr, err)
+			return nil, self.makeError(UpdateErr, "DeleteAttributesWithUpdateExpression", err)
 		}
 	} else {
-		return attrs, nil
+		return result, nil
 	}
 }
 
 func (self *TStore) ModifyAttributesWithUpdateExpression(key *dynamodb.Key, condition *dynamodb.ConditionExpression,
 	actions []string, returnValues string, attrs ...dynamodb.UpdateExpressionAttribute) (map[string]*dynamodb.Attribute, *TError) {
 
-	if _, attrs, err := self.table.ModifyAttributesWithUpdateExpression(key, condition, attrs, actions, returnValues); err != nil {
-		if strings.HasPrefix(err.Error(), ConditionalDynamoError) {
-			return nil, ConditionalErr
+	if err := self.opts.rawExpressions(); err != nil {
+		return nil, self.makeError(NotSupportedErr, "ModifyAttributesWithUpdateExpression", err)
+	}
+	var result map[string]*dynamodb.Attribute
+	idempotent := condition == nil && idempotentActions(actions)
+	if err := self.retryWrite("ModifyAttributesWithUpdateExpression", idempotent, func() (err error) {
+		_, result, err = self.table.ModifyAttributesWithUpdateExpression(key, condition, attrs, actions, returnValues)
+		return
+	}); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			return nil, self.makeError(ConditionalErr, "ModifyAttributesWithUpdateExpression", err)
 		} else {
 			log.WithFields(log.Fields{
 				LogKey:          key,
@@ -324,21 +510,46 @@ func (self *TStore) ModifyAttributesWithUpdateExpression(key *dynamodb.Key, cond
 				LogTable:        self.tableDesc.TableName,
 				fhlog.FHError:   err.Error(),
 			}).Error("Error in ModifyAttributesWithUpdateExpression()")
-			return nil, self.makeError(UpdateErr, err)
+			return nil, self.makeError(UpdateErr, "ModifyAttributesWithUpdateExpression", err)
 		}
 	} else {
-		return attrs, nil
+		return result, nil
 	}
 }
 
+// idempotentActions reports whether update expression actions can be
+// repeated, ADD increments numbers
+func idempotentActions(actions []string) bool {
+	for _, v := range actions {
+		if strings.EqualFold(v, updateAdd) {
+			return false
+		}
+	}
+	return true
+}
+
 func (self *TStore) Update(key *dynamodb.Key, attrs ...dynamodb.Attribute) *TError {
 	return self.UpdateConditional(key, attrs, nil)
 }
 
 func (self *TStore) UpdateConditional(key *dynamodb.Key, attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TError {
-	if _, err := self.table.ConditionalUpdateAttributes(key, attrs, expected); err != nil {
-		if strings.HasPrefix(err.Error(), ConditionalDynamoError) {
-			return ConditionalErr
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		var err error
+		if attrs, check, err = self.opts.versionUpdate(attrs); err != nil {
+			return self.makeError(UpdateErr, "UpdateConditional", err)
+		}
+	}
+	if needsWireCodec(attrs) {
+		return self.updateItem("UpdateConditional", key, attrs, expected, check)
+	}
+	conditions := withVersion(expected, check)
+	if err := self.retryWrite("UpdateConditional", len(conditions) == 0, func() (err error) {
+		_, err = self.table.ConditionalUpdateAttributes(key, attrs, conditions)
+		return
+	}); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			return self.conditionalError("UpdateConditional", key, check, len(expected) > 0, err)
 		} else {
 			log.WithFields(log.Fields{
 				LogKey:        key,
@@ -346,71 +557,143 @@ func (self *TStore) UpdateConditional(key *dynamodb.Key, attrs []dynamodb.Attrib
 				fhlog.FHError: err.Error(),
 			}).Error("Error in UpdateConditional()")
 
-			return self.makeError(UpdateErr, err)
+			return self.makeError(UpdateErr, "UpdateConditional", err)
 		}
 	} else {
 		return nil
 	}
 }
 
-func (self *TStore) Find(query *dynamodb.Query) ([]map[string]*dynamodb.Attribute, *TError) {
-	if items, err := self.table.RunQuery(query); err != nil {
+// updateItem sets attributes with document, BOOL or NULL values by the wire codec
+func (self *TStore) updateItem(op string, key *dynamodb.Key, attrs, expected []dynamodb.Attribute, check *dynamodb.Attribute) *TError {
+	ctx := makeExprContext()
+	conditions := withVersion(expected, check)
+	req := map[string]interface{}{
+		"TableName":        self.tableDesc.TableName,
+		"Key":              toWireItem(tableKeyItem(self.tableDesc, key)),
+		"UpdateExpression": ctx.update(attrs, nil),
+	}
+	if len(conditions) > 0 {
+		req["ConditionExpression"] = ctx.expected(conditions)
+	}
+	ctx.apply(req)
+	if err := self.rpcWrite("UpdateItem", len(conditions) == 0, req, nil); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			return self.conditionalError(op, key, check, len(expected) > 0, err)
+		}
+		log.WithFields(log.Fields{
+			LogKey:        key,
+			LogAttributes: req["UpdateExpression"],
+			LogTable:      self.tableDesc.TableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in " + op + "()")
+		return self.makeError(UpdateErr, op, err)
+	}
+	return nil
+}
+
+// Find returns a single page of results, use FindIter to follow pagination.
+// Queries built by goamz are sent by the wire codec too, so native and
+// document values are decoded.
+func (self *TStore) Find(query IQuery) ([]map[string]*dynamodb.Attribute, *TError) {
+	if items, err := self.runQuery(query); err != nil {
 		log.WithFields(log.Fields{
 			LogTable:      self.tableDesc.TableName,
 			fhlog.FHError: err.Error(),
 		}).Error("Error in Find()")
 
-		return nil, self.makeError(LookupErr, err)
+		return nil, self.makeError(LookupErr, "Find", err)
 	} else {
-		return items, nil
+		return self.opts.unexpired(items), nil
 	}
 }
 
+// Get reads the item by the wire codec on every table, whether the item holds
+// documents goamz can't decode isn't known before it is read
 func (self *TStore) Get(key *dynamodb.Key) (map[string]*dynamodb.Attribute, *TError) {
-	if attrMap, err := self.table.GetItem(key); err != nil {
-		if err == dynamodb.ErrNotFound {
-			return nil, NotFoundErr
-		} else {
-			log.WithFields(log.Fields{
-				LogKey:        key,
-				LogTable:      self.tableDesc.TableName,
-				fhlog.FHError: err.Error(),
-			}).Error("Error in Get()")
+	if attrMap, err := self.readItem(key, false); err != nil {
+		log.WithFields(log.Fields{
+			LogKey:        key,
+			LogTable:      self.tableDesc.TableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in Get()")
 
-			return nil, self.makeError(LookupErr, err)
-		}
+		return nil, self.makeError(LookupErr, "Get", err)
+	} else if attrMap == nil || self.opts.expired(attrMap, time.Now()) {
+		return nil, self.makeError(NotFoundErr, "Get", dynamodb.ErrNotFound)
 	} else {
 		return attrMap, nil
 	}
 }
 
+// readItem reads the item by the wire codec, missing item is nil
+func (self *TStore) readItem(key *dynamodb.Key, consistent bool) (map[string]*dynamodb.Attribute, error) {
+	req := map[string]interface{}{
+		"TableName": self.tableDesc.TableName,
+		"Key":       toWireItem(tableKeyItem(self.tableDesc, key)),
+	}
+	if consistent {
+		req["ConsistentRead"] = true
+	}
+	var resp struct {
+		Item tWireItem
+	}
+	if err := self.rpc("GetItem", req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, nil
+	}
+	return fromWireItem(resp.Item), nil
+}
+
+// ParallelScanPartialLimit scans a page of the segment by the wire codec,
+// goamz can't decode documents
 func (self *TStore) ParallelScanPartialLimit(attributeComparisons []dynamodb.AttributeComparison, exclusiveStartKey *dynamodb.Key,
 	segment, totalSegments int, limit int64) ([]map[string]*dynamodb.Attribute, *dynamodb.Key, *TError) {
 
-	if attrMap, key, err := self.table.ParallelScanPartialLimit(attributeComparisons, exclusiveStartKey,
-		segment, totalSegments, limit); err != nil {
-
-		if err == dynamodb.ErrNotFound {
-			return nil, nil, NotFoundErr
-		} else {
-			log.WithFields(log.Fields{
-				LogKey:                  key,
-				LogTable:                self.tableDesc.TableName,
-				LogAttributeComparisons: attributeComparisons,
-				LogExclusiveStartKey:    exclusiveStartKey,
-				LogSegment:              segment,
-				LogTotalSegments:        totalSegments,
-				LogLimit:                limit,
-				fhlog.FHError:           err.Error(),
-			}).Error("Error in ParallelScanPartialLimit()")
-
-			return nil, nil, self.makeError(LookupErr, err)
-		}
-	} else {
-		return attrMap, key, nil
+	req := map[string]interface{}{
+		"TableName":     self.tableDesc.TableName,
+		"Segment":       segment,
+		"TotalSegments": totalSegments,
+	}
+	if len(attributeComparisons) > 0 {
+		req["ScanFilter"] = wireComparisons(attributeComparisons)
+	}
+	if exclusiveStartKey != nil {
+		req["ExclusiveStartKey"] = toWireItem(tableKeyItem(self.tableDesc, exclusiveStartKey))
+	}
+	if limit > 0 {
+		req["Limit"] = limit
+	}
+	var resp struct {
+		Items            []tWireItem
+		LastEvaluatedKey tWireItem
+	}
+	if err := self.rpc("Scan", req, &resp); err != nil {
+		log.WithFields(log.Fields{
+			LogTable:                self.tableDesc.TableName,
+			LogAttributeComparisons: attributeComparisons,
+			LogExclusiveStartKey:    exclusiveStartKey,
+			LogSegment:              segment,
+			LogTotalSegments:        totalSegments,
+			LogLimit:                limit,
+			fhlog.FHError:           err.Error(),
+		}).Error("Error in ParallelScanPartialLimit()")
+
+		return nil, nil, self.makeError(LookupErr, "ParallelScanPartialLimit", err)
+	}
+	items := make([]map[string]*dynamodb.Attribute, 0, len(resp.Items))
+	for _, v := range resp.Items {
+		items = append(items, fromWireItem(v))
+	}
+	if len(resp.LastEvaluatedKey) == 0 {
+		return items, nil, nil
 	}
+	last := itemTableKey(self.tableDesc, fromWireItem(resp.LastEvaluatedKey))
+	return items, &last, nil
 }
 
-func (self *TStore) makeError(tErr *TError, details error) *TError {
-	return MakeError(tErr.Summary, fmt.Sprintf("table: %s, err: %v, desc: %s", self.table.Name, details, tErr.Description))
+func (self *TStore) makeError(tErr *TError, op string, details error) *TError {
+	return makeOpError(tErr, self.table.Name, op, details)
 }
@@ -0,0 +1,167 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/flowhealth/goamz/aws"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Store initialization", func() {
+	d := dnm.Describe("Sessions", func(t dnm.ITable) {
+		t.PrimaryKey().Hash(t.KeyAttr("Id", dnm.String))
+	})
+
+	// tableServer answers by responses keyed by action, unknown actions fail
+	tableServer := func(responses map[string]string) (*dnm.TStoreConfig, func()) {
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			target := r.Header.Get("X-Amz-Target")
+			action := target[strings.LastIndex(target, ".")+1:]
+			if resp, ok := responses[action]; ok {
+				fmt.Fprint(w, resp)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ValidationException","message":"failed"}`)
+		}))
+		cfg.Retry = dnm.MakeNoRetryPolicy()
+		cfg.TableCreateCheckTimeout = "20ms"
+		return cfg, server.Close
+	}
+	initStore := func(responses map[string]string) *dnm.TError {
+		cfg, done := tableServer(responses)
+		defer done()
+		store, err := dnm.MakeStore(&d, cfg)
+		Expect(err).To(BeNil())
+		return store.Init()
+	}
+
+	It("should report failure to list tables", func() {
+		err := initStore(map[string]string{})
+		Expect(err.Is(dnm.ListTablesErr)).To(BeTrue())
+		Expect(err.Code).To(Equal(dnm.ErrCodeValidation))
+		Expect(err.Op).To(Equal("Init"))
+	})
+
+	It("should time out waiting on a table stuck in creation", func() {
+		err := initStore(map[string]string{
+			"ListTables":    `{"TableNames":["Sessions"]}`,
+			"DescribeTable": `{"Table":{"TableName":"Sessions","TableStatus":"CREATING"}}`,
+		})
+		Expect(err.Is(dnm.InitTimeoutErr)).To(BeTrue())
+
+		err = initStore(map[string]string{
+			"ListTables":    `{"TableNames":[]}`,
+			"CreateTable":   `{"TableDescription":{"TableName":"Sessions","TableStatus":"CREATING"}}`,
+			"DescribeTable": `{"Table":{"TableName":"Sessions","TableStatus":"CREATING"}}`,
+		})
+		Expect(err.Is(dnm.InitTimeoutErr)).To(BeTrue())
+	})
+
+	It("should report unknown table status", func() {
+		err := initStore(map[string]string{
+			"ListTables":  `{"TableNames":[]}`,
+			"CreateTable": `{"TableDescription":{"TableName":"Sessions","TableStatus":"ARCHIVED"}}`,
+		})
+		Expect(err.Is(dnm.InitUnknownStatusErr)).To(BeTrue())
+
+		err = initStore(map[string]string{
+			"ListTables":    `{"TableNames":["Sessions"]}`,
+			"DescribeTable": `{"Table":{"TableName":"Sessions","TableStatus":"DELETING"}}`,
+		})
+		Expect(err.Is(dnm.InitUnknownStatusErr)).To(BeTrue())
+	})
+
+	It("should become active", func() {
+		Expect(initStore(map[string]string{
+			"ListTables":    `{"TableNames":["Sessions"]}`,
+			"DescribeTable": `{"Table":{"TableName":"Sessions","TableStatus":"ACTIVE"}}`,
+		})).To(BeNil())
+	})
+
+	Context("without credentials", func() {
+		env := map[string]string{}
+		BeforeEach(func() {
+			home, _ := ioutil.TempDir("", "dnm")
+			for _, v := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_ACCESS_KEY", "AWS_SECRET_KEY", "HOME"} {
+				env[v] = os.Getenv(v)
+				os.Unsetenv(v)
+			}
+			os.Setenv("HOME", home)
+		})
+		AfterEach(func() {
+			os.RemoveAll(os.Getenv("HOME"))
+			for k, v := range env {
+				os.Setenv(k, v)
+			}
+		})
+
+		It("should report missing credentials instead of exiting", func() {
+			cfg, done := tableServer(map[string]string{})
+			defer done()
+			cfg.Auth = aws.Auth{}
+			store, err := dnm.MakeStore(&d, cfg)
+			Expect(store).To(BeNil())
+			Expect(err.Is(dnm.CredentialsErr)).To(BeTrue())
+			Expect(err.Op).To(Equal("MakeStore"))
+		})
+	})
+})
+
+var _ = Describe("Store reads", func() {
+	var (
+		id, name dnm.IAttr
+		pk       dnm.IKeyFactory
+	)
+	d := dnm.Describe("Accounts", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		name = t.NonKeyAttr("Name", dnm.String)
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+	})
+	key := pk.Key(id.Is("acc:1"))
+
+	It("should get scalar and set items by the wire codec", func() {
+		var (
+			req struct {
+				TableName string
+				Key       map[string]interface{}
+			}
+			status = http.StatusOK
+			body   = `{"Item":{"Id":{"S":"acc:1"},"Name":{"S":"neo"},"Roles":{"SS":["admin","dev"]}}}`
+			calls  int
+		)
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			json.NewDecoder(r.Body).Decode(&req)
+			w.WriteHeader(status)
+			fmt.Fprint(w, body)
+		}))
+		defer server.Close()
+		cfg.Retry = dnm.MakeNoRetryPolicy()
+		store, _ := dnm.MakeStore(&d, cfg)
+		item, err := store.Get(&key)
+		Expect(err).To(BeNil())
+		Expect(req.TableName).To(Equal("Accounts"))
+		Expect(req.Key).To(Equal(map[string]interface{}{"Id": map[string]interface{}{"S": "acc:1"}}))
+		Expect(name.From(item)).To(Equal("neo"))
+		Expect(item["Roles"].SetValues).To(Equal([]string{"admin", "dev"}))
+
+		body = "{}"
+		_, err = store.Get(&key)
+		Expect(err.IsNotFound()).To(BeTrue())
+
+		status, body = http.StatusInternalServerError, `{"__type":"com.amazonaws.dynamodb.v20120810#InternalServerError","message":"failed"}`
+		_, err = store.Get(&key)
+		Expect(err.Is(dnm.LookupErr)).To(BeTrue())
+		Expect(calls).To(Equal(3))
+	})
+})
@@ -0,0 +1,472 @@
+package dnm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Change capture. A table declared with Stream gets its DynamoDB stream enabled
+by Init and Migrate, TStreamConsumer reads the stream shard by shard and hands
+decoded records to a handler:
+
+	t.Stream(dnm.StreamViewNewAndOldImages)
+	...
+	consumer, err := dnm.MakeStreamConsumer(store.WithContext(ctx), dnm.TStreamConfig{Checkpoints: checkpoints})
+	err = consumer.Consume(func(r *dnm.TStreamRecord) error {
+		cache.Invalidate(r.Keys)
+		return nil
+	})
+
+Consume runs until the store context is done or the handler fails. Records of
+a shard are handled in order, and a shard created by a split is read only once
+its parent is finished. Sequence number of the last handled record is saved to
+the checkpoint store after every batch, so a restarted consumer resumes where
+the previous one stopped and records are handled at least once.
+*/
+
+const (
+	StreamViewKeysOnly        = "KEYS_ONLY"
+	StreamViewNewImage        = "NEW_IMAGE"
+	StreamViewOldImage        = "OLD_IMAGE"
+	StreamViewNewAndOldImages = "NEW_AND_OLD_IMAGES"
+
+	StreamEventInsert = "INSERT"
+	StreamEventModify = "MODIFY"
+	StreamEventRemove = "REMOVE"
+
+	StreamStartTrimHorizon = "TRIM_HORIZON"
+	StreamStartLatest      = "LATEST"
+
+	// checkpoint of a shard which was read to its end
+	CheckpointShardEnd = "SHARD_END"
+
+	StreamsTargetPrefix       = "DynamoDBStreams_20120810."
+	DefaultStreamPollInterval = time.Second
+)
+
+var streamViewTypes = map[string]bool{
+	StreamViewKeysOnly:        true,
+	StreamViewNewImage:        true,
+	StreamViewOldImage:        true,
+	StreamViewNewAndOldImages: true,
+}
+
+/**
+Checkpoints
+*/
+
+type ICheckpointStore interface {
+	// Checkpoint returns sequence number of the last handled record of the
+	// shard, empty string if the shard wasn't read yet
+	Checkpoint(streamArn, shardId string) (string, error)
+	SetCheckpoint(streamArn, shardId, sequenceNumber string) error
+}
+
+type tMemCheckpointStore struct {
+	checkpoints map[string]string
+	lock        sync.Mutex
+}
+
+// MakeMemCheckpointStore keeps checkpoints for the lifetime of the process
+func MakeMemCheckpointStore() ICheckpointStore {
+	return &tMemCheckpointStore{checkpoints: map[string]string{}}
+}
+
+func (self *tMemCheckpointStore) Checkpoint(streamArn, shardId string) (string, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.checkpoints[streamArn+"/"+shardId], nil
+}
+
+func (self *tMemCheckpointStore) SetCheckpoint(streamArn, shardId, sequenceNumber string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.checkpoints[streamArn+"/"+shardId] = sequenceNumber
+	return nil
+}
+
+/**
+Consumer
+*/
+
+type TStreamConfig struct {
+	// DynamoDB Streams endpoint, derived from the store region if empty
+	Endpoint string
+	// where a shard without checkpoint is read from, TRIM_HORIZON if empty,
+	// shards split from a finished parent are always read from TRIM_HORIZON
+	StartPosition string
+	// max number of records per request, DynamoDB default if zero
+	Limit int64
+	// delay between polls once all shards are drained, DefaultStreamPollInterval if zero
+	PollInterval time.Duration
+	// in-memory checkpoints if nil
+	Checkpoints ICheckpointStore
+}
+
+type TStreamRecord struct {
+	EventID        string
+	EventName      string
+	ShardId        string
+	SequenceNumber string
+	Created        time.Time
+	// images are nil unless the stream view type includes them
+	Keys     map[string]*dynamodb.Attribute
+	OldImage map[string]*dynamodb.Attribute
+	NewImage map[string]*dynamodb.Attribute
+}
+
+type TStreamConsumer struct {
+	store     *TStore
+	cfg       TStreamConfig
+	endpoint  string
+	streamArn string
+	shards    map[string]*tShardState
+}
+
+type tShardState struct {
+	checkpoint string
+	iterator   string
+}
+
+func (self *tShardState) finished() bool {
+	return self.checkpoint == CheckpointShardEnd
+}
+
+type tWireShard struct {
+	ShardId       string
+	ParentShardId string
+}
+
+type tWireStreamRecord struct {
+	EventID   string `json:"eventID"`
+	EventName string `json:"eventName"`
+	Dynamodb  struct {
+		ApproximateCreationDateTime float64
+		Keys                        tWireItem
+		NewImage                    tWireItem
+		OldImage                    tWireItem
+		SequenceNumber              string
+	} `json:"dynamodb"`
+}
+
+// MakeStreamConsumer reads the stream of the store table, store context
+// bounds the lifetime of Consume
+func MakeStreamConsumer(store IStore, cfg TStreamConfig) (*TStreamConsumer, *TError) {
+	s, ok := store.(*TStore)
+	if !ok {
+		return nil, wrapError(NotSupportedErr, fmt.Errorf("streams are supported by TStore only"))
+	}
+	if cfg.StartPosition == "" {
+		cfg.StartPosition = StreamStartTrimHorizon
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultStreamPollInterval
+	}
+	if cfg.Checkpoints == nil {
+		cfg.Checkpoints = MakeMemCheckpointStore()
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = streamsEndpoint(s.dynamoServer.Region.DynamoDBEndpoint)
+	}
+	return &TStreamConsumer{store: s, cfg: cfg, endpoint: endpoint, shards: map[string]*tShardState{}}, nil
+}
+
+// streamsEndpoint derives DynamoDB Streams endpoint of the region, local
+// DynamoDB serves streams from its own endpoint
+func streamsEndpoint(endpoint string) string {
+	return strings.Replace(endpoint, "://dynamodb.", "://streams.dynamodb.", 1)
+}
+
+func (self *TStreamConsumer) Consume(handler func(*TStreamRecord) error) *TError {
+	if self.streamArn == "" {
+		arn, tErr := self.latestStreamArn()
+		if tErr != nil {
+			return tErr
+		}
+		self.streamArn = arn
+	}
+	for {
+		shards, err := self.describeShards()
+		if err != nil {
+			return self.makeError(StreamErr, err)
+		}
+		consumed := false
+		for _, shard := range shards {
+			ready, err := self.ready(shard, shards)
+			if err != nil {
+				return self.makeError(StreamErr, err)
+			}
+			if !ready {
+				continue
+			}
+			n, tErr := self.consumeShard(shard, handler)
+			if tErr != nil {
+				return tErr
+			}
+			consumed = consumed || n > 0
+		}
+		if !consumed {
+			if err := self.store.sleep(self.cfg.PollInterval); err != nil {
+				return self.makeError(StreamErr, err)
+			}
+		}
+	}
+}
+
+func (self *TStreamConsumer) latestStreamArn() (string, *TError) {
+	var resp struct {
+		Table struct {
+			LatestStreamArn string
+		}
+	}
+	if err := self.store.rpc("DescribeTable", map[string]interface{}{"TableName": self.store.tableDesc.TableName}, &resp); err != nil {
+		return "", self.makeError(StreamErr, err)
+	}
+	if resp.Table.LatestStreamArn == "" {
+		return "", self.makeError(StreamErr, fmt.Errorf("stream is not enabled"))
+	}
+	return resp.Table.LatestStreamArn, nil
+}
+
+func (self *TStreamConsumer) describeShards() ([]tWireShard, error) {
+	shards := []tWireShard{}
+	start := ""
+	for {
+		req := map[string]interface{}{"StreamArn": self.streamArn}
+		if start != "" {
+			req["ExclusiveStartShardId"] = start
+		}
+		var resp struct {
+			StreamDescription struct {
+				Shards               []tWireShard
+				LastEvaluatedShardId string
+			}
+		}
+		if err := self.rpc("DescribeStream", req, &resp); err != nil {
+			return nil, err
+		}
+		shards = append(shards, resp.StreamDescription.Shards...)
+		if start = resp.StreamDescription.LastEvaluatedShardId; start == "" {
+			return shards, nil
+		}
+	}
+}
+
+// state loads checkpoint of the shard on first access
+func (self *TStreamConsumer) state(shardId string) (*tShardState, error) {
+	if state, ok := self.shards[shardId]; ok {
+		return state, nil
+	}
+	checkpoint, err := self.cfg.Checkpoints.Checkpoint(self.streamArn, shardId)
+	if err != nil {
+		return nil, err
+	}
+	state := &tShardState{checkpoint: checkpoint}
+	self.shards[shardId] = state
+	return state, nil
+}
+
+// ready reports whether the shard has records left and its parent, unless
+// it was already trimmed from the stream, is finished
+func (self *TStreamConsumer) ready(shard tWireShard, shards []tWireShard) (bool, error) {
+	state, err := self.state(shard.ShardId)
+	if err != nil || state.finished() {
+		return false, err
+	}
+	if shard.ParentShardId == "" {
+		return true, nil
+	}
+	for _, v := range shards {
+		if v.ShardId == shard.ParentShardId {
+			parent, err := self.state(v.ShardId)
+			if err != nil {
+				return false, err
+			}
+			return parent.finished(), nil
+		}
+	}
+	return true, nil
+}
+
+// consumeShard handles a single batch of records and returns its size
+func (self *TStreamConsumer) consumeShard(shard tWireShard, handler func(*TStreamRecord) error) (int, *TError) {
+	state, _ := self.state(shard.ShardId)
+	if state.iterator == "" {
+		iterator, err := self.shardIterator(shard, state)
+		if err != nil {
+			return 0, self.makeError(StreamErr, err)
+		}
+		state.iterator = iterator
+	}
+	req := map[string]interface{}{"ShardIterator": state.iterator}
+	if self.cfg.Limit > 0 {
+		req["Limit"] = self.cfg.Limit
+	}
+	var resp struct {
+		Records           []tWireStreamRecord
+		NextShardIterator string
+	}
+	if err := self.rpc("GetRecords", req, &resp); err != nil {
+		if streamErrorIs(err, "ExpiredIteratorException") {
+			// next round starts over from the checkpoint
+			state.iterator = ""
+			return 0, nil
+		}
+		return 0, self.makeError(StreamErr, err)
+	}
+	for n := range resp.Records {
+		record := makeStreamRecord(shard.ShardId, &resp.Records[n])
+		if err := handler(record); err != nil {
+			if n > 0 {
+				self.checkpoint(shard.ShardId, state, resp.Records[n-1].Dynamodb.SequenceNumber)
+			}
+			return n, self.makeError(StreamErr, err)
+		}
+	}
+	state.iterator = resp.NextShardIterator
+	switch {
+	case resp.NextShardIterator == "":
+		log.WithFields(log.Fields{
+			LogTable: self.store.tableDesc.TableName,
+			LogShard: shard.ShardId,
+		}).Debug("Shard is finished")
+		if err := self.checkpoint(shard.ShardId, state, CheckpointShardEnd); err != nil {
+			return len(resp.Records), err
+		}
+	case len(resp.Records) > 0:
+		if err := self.checkpoint(shard.ShardId, state, resp.Records[len(resp.Records)-1].Dynamodb.SequenceNumber); err != nil {
+			return len(resp.Records), err
+		}
+	}
+	return len(resp.Records), nil
+}
+
+// shardIterator starts after the checkpoint, shard without checkpoint is
+// read from the start position unless it was split from a finished parent
+func (self *TStreamConsumer) shardIterator(shard tWireShard, state *tShardState) (string, error) {
+	req := map[string]interface{}{"StreamArn": self.streamArn, "ShardId": shard.ShardId}
+	switch {
+	case state.checkpoint != "":
+		req["ShardIteratorType"] = "AFTER_SEQUENCE_NUMBER"
+		req["SequenceNumber"] = state.checkpoint
+	case shard.ParentShardId != "" && self.shards[shard.ParentShardId] != nil:
+		req["ShardIteratorType"] = StreamStartTrimHorizon
+	default:
+		req["ShardIteratorType"] = self.cfg.StartPosition
+	}
+	var resp struct {
+		ShardIterator string
+	}
+	err := self.rpc("GetShardIterator", req, &resp)
+	if streamErrorIs(err, "TrimmedDataAccessException") {
+		log.WithFields(log.Fields{
+			LogTable: self.store.tableDesc.TableName,
+			LogShard: shard.ShardId,
+		}).Warn("Records after checkpoint were trimmed, reading shard from the oldest record")
+		delete(req, "SequenceNumber")
+		req["ShardIteratorType"] = StreamStartTrimHorizon
+		err = self.rpc("GetShardIterator", req, &resp)
+	}
+	return resp.ShardIterator, err
+}
+
+func (self *TStreamConsumer) checkpoint(shardId string, state *tShardState, sequenceNumber string) *TError {
+	if err := self.cfg.Checkpoints.SetCheckpoint(self.streamArn, shardId, sequenceNumber); err != nil {
+		log.WithFields(log.Fields{
+			LogTable:      self.store.tableDesc.TableName,
+			LogShard:      shardId,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in Consume()")
+		return self.makeError(StreamErr, err)
+	}
+	state.checkpoint = sequenceNumber
+	return nil
+}
+
+func (self *TStreamConsumer) rpc(action string, req interface{}, resp interface{}) error {
+	store := self.store
+	return store.retry(action, func() error {
+		return wireCall(store.context(), store.dynamoServer.Auth, store.dynamoServer.Region, self.endpoint,
+			StreamsTargetPrefix+action, req, resp)
+	})
+}
+
+func (self *TStreamConsumer) makeError(tErr *TError, details error) *TError {
+	return self.store.makeError(tErr, "Consume", details)
+}
+
+func streamErrorIs(err error, code string) bool {
+	var dErr *dynamodb.Error
+	return errors.As(err, &dErr) && dErr.Code == code
+}
+
+func makeStreamRecord(shardId string, wr *tWireStreamRecord) *TStreamRecord {
+	record := &TStreamRecord{
+		EventID:        wr.EventID,
+		EventName:      wr.EventName,
+		ShardId:        shardId,
+		SequenceNumber: wr.Dynamodb.SequenceNumber,
+		Created:        time.Unix(int64(wr.Dynamodb.ApproximateCreationDateTime), 0),
+		Keys:           fromWireItem(wr.Dynamodb.Keys),
+	}
+	if wr.Dynamodb.OldImage != nil {
+		record.OldImage = fromWireItem(wr.Dynamodb.OldImage)
+	}
+	if wr.Dynamodb.NewImage != nil {
+		record.NewImage = fromWireItem(wr.Dynamodb.NewImage)
+	}
+	return record
+}
+
+/**
+Stream specification of the table
+*/
+
+// enableStream turns the stream of the table on unless it's already enabled
+func (self *TStore) enableStream(op string) *TError {
+	view := self.opts.streamView
+	if view == "" {
+		return nil
+	}
+	tableName := self.tableDesc.TableName
+	var resp struct {
+		Table struct {
+			StreamSpecification struct {
+				StreamEnabled  bool
+				StreamViewType string
+			}
+		}
+	}
+	if err := self.rpc("DescribeTable", map[string]interface{}{"TableName": tableName}, &resp); err != nil {
+		log.WithFields(log.Fields{
+			LogTable:      tableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in enableStream()")
+		return self.makeError(StreamErr, op, err)
+	}
+	if spec := resp.Table.StreamSpecification; spec.StreamEnabled {
+		if spec.StreamViewType == view {
+			log.WithField(LogTable, tableName).Debug("Stream is enabled")
+			return nil
+		}
+		return self.makeError(StreamErr, op, fmt.Errorf("stream is enabled with view type %s", spec.StreamViewType))
+	}
+	req := map[string]interface{}{
+		"TableName": tableName,
+		"StreamSpecification": map[string]interface{}{
+			"StreamEnabled":  true,
+			"StreamViewType": view,
+		},
+	}
+	log.WithField(LogTable, tableName).Info("Enabling stream")
+	return self.updateTable(TMigrateConfig{}, req, indexesActive)
+}
@@ -0,0 +1,213 @@
+package dnm_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type tFakeShard struct {
+	id      string
+	parent  string
+	closed  bool
+	records []map[string]interface{}
+}
+
+// tFakeStreamServer serves a stream whose iterators are "shard:position"
+type tFakeStreamServer struct {
+	lock      sync.Mutex
+	shards    []*tFakeShard
+	iterators []string
+}
+
+func (self *tFakeStreamServer) shard(id string) *tFakeShard {
+	for _, v := range self.shards {
+		if v.id == id {
+			return v
+		}
+	}
+	return nil
+}
+
+func (self *tFakeStreamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	var req map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&req)
+	target := r.Header.Get("X-Amz-Target")
+	switch {
+	case strings.HasSuffix(target, "DescribeTable"):
+		json.NewEncoder(w).Encode(map[string]interface{}{"Table": map[string]interface{}{"LatestStreamArn": "arn:stream"}})
+	case strings.HasSuffix(target, "DescribeStream"):
+		shards := []map[string]interface{}{}
+		for _, v := range self.shards {
+			shards = append(shards, map[string]interface{}{"ShardId": v.id, "ParentShardId": v.parent})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"StreamDescription": map[string]interface{}{"Shards": shards}})
+	case strings.HasSuffix(target, "GetShardIterator"):
+		shard := self.shard(req["ShardId"].(string))
+		pos := 0
+		switch req["ShardIteratorType"] {
+		case "AFTER_SEQUENCE_NUMBER":
+			for n, v := range shard.records {
+				if v["dynamodb"].(map[string]interface{})["SequenceNumber"] == req["SequenceNumber"] {
+					pos = n + 1
+				}
+			}
+		case "LATEST":
+			pos = len(shard.records)
+		}
+		self.iterators = append(self.iterators, fmt.Sprintf("%s %s", shard.id, req["ShardIteratorType"]))
+		json.NewEncoder(w).Encode(map[string]interface{}{"ShardIterator": fmt.Sprintf("%s:%d", shard.id, pos)})
+	case strings.HasSuffix(target, "GetRecords"):
+		it := strings.Split(req["ShardIterator"].(string), ":")
+		shard := self.shard(it[0])
+		pos, _ := strconv.Atoi(it[1])
+		resp := map[string]interface{}{"Records": shard.records[pos:]}
+		if !shard.closed {
+			resp["NextShardIterator"] = fmt.Sprintf("%s:%d", shard.id, len(shard.records))
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func fakeStreamRecord(seq, event string, keys, newImage map[string]interface{}) map[string]interface{} {
+	record := map[string]interface{}{"SequenceNumber": seq, "Keys": keys}
+	if newImage != nil {
+		record["NewImage"] = newImage
+	}
+	return map[string]interface{}{"eventID": "ev" + seq, "eventName": event, "dynamodb": record}
+}
+
+var _ = Describe("Streams", func() {
+	var (
+		fake        *tFakeStreamServer
+		server      *httptest.Server
+		cfg         *dnm.TStoreConfig
+		checkpoints dnm.ICheckpointStore
+	)
+	d := dnm.Describe("StreamedSessions", func(t dnm.ITable) {
+		t.PrimaryKey().Hash(t.KeyAttr("Sid", dnm.String))
+		t.Stream(dnm.StreamViewNewAndOldImages)
+	})
+	key := map[string]interface{}{"Sid": map[string]string{"S": "sid:1"}}
+
+	BeforeEach(func() {
+		fake = &tFakeStreamServer{shards: []*tFakeShard{
+			{id: "child", parent: "parent", records: []map[string]interface{}{
+				fakeStreamRecord("3", dnm.StreamEventRemove, key, nil),
+			}},
+			{id: "parent", closed: true, records: []map[string]interface{}{
+				fakeStreamRecord("1", dnm.StreamEventInsert, key, map[string]interface{}{"Sid": map[string]string{"S": "sid:1"}, "Count": map[string]string{"N": "1"}}),
+				fakeStreamRecord("2", dnm.StreamEventModify, key, map[string]interface{}{"Sid": map[string]string{"S": "sid:1"}, "Count": map[string]string{"N": "2"}}),
+			}},
+		}}
+		server, cfg = fakeEndpoint(fake)
+		checkpoints = dnm.MakeMemCheckpointStore()
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	// consume handles records until count of them is reached
+	consume := func(count int, handler func(*dnm.TStreamRecord) error) ([]*dnm.TStreamRecord, *dnm.TError) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store, _ := dnm.MakeStore(&d, cfg)
+		consumer, err := dnm.MakeStreamConsumer(store.WithContext(ctx), dnm.TStreamConfig{Checkpoints: checkpoints, PollInterval: 1})
+		Expect(err).To(BeNil())
+		records := []*dnm.TStreamRecord{}
+		err = consumer.Consume(func(r *dnm.TStreamRecord) error {
+			if err := handler(r); err != nil {
+				return err
+			}
+			if records = append(records, r); len(records) == count {
+				cancel()
+			}
+			return nil
+		})
+		return records, err
+	}
+	accept := func(*dnm.TStreamRecord) error { return nil }
+
+	It("should read parent shard before its children", func() {
+		records, err := consume(3, accept)
+		Expect(err.IsCancelled()).To(BeTrue())
+		Expect(records).To(HaveLen(3))
+		Expect(records[0].EventName).To(Equal(dnm.StreamEventInsert))
+		Expect(records[0].ShardId).To(Equal("parent"))
+		Expect(records[0].Keys["Sid"].Value).To(Equal("sid:1"))
+		Expect(records[1].NewImage["Count"].Value).To(Equal("2"))
+		Expect(records[1].OldImage).To(BeNil())
+		Expect(records[2].SequenceNumber).To(Equal("3"))
+		Expect(records[2].NewImage).To(BeNil())
+		Expect(fake.iterators).To(Equal([]string{"parent TRIM_HORIZON", "child TRIM_HORIZON"}))
+
+		Expect(checkpoints.Checkpoint("arn:stream", "parent")).To(Equal(dnm.CheckpointShardEnd))
+		Expect(checkpoints.Checkpoint("arn:stream", "child")).To(Equal("3"))
+	})
+
+	It("should resume after the last handled record", func() {
+		failed := fmt.Errorf("handler failed")
+		_, err := consume(3, func(r *dnm.TStreamRecord) error {
+			if r.SequenceNumber == "2" {
+				return failed
+			}
+			return nil
+		})
+		Expect(err.Is(dnm.StreamErr)).To(BeTrue())
+		Expect(err.Unwrap()).To(Equal(failed))
+		Expect(checkpoints.Checkpoint("arn:stream", "parent")).To(Equal("1"))
+
+		records, err := consume(2, accept)
+		Expect(err.IsCancelled()).To(BeTrue())
+		Expect(records[0].SequenceNumber).To(Equal("2"))
+		Expect(records[1].SequenceNumber).To(Equal("3"))
+		Expect(fake.iterators[1:]).To(Equal([]string{"parent AFTER_SEQUENCE_NUMBER", "child TRIM_HORIZON"}))
+	})
+
+	It("should not consume in-memory stores", func() {
+		_, err := dnm.MakeStreamConsumer(dnm.MakeMemStore(&d), dnm.TStreamConfig{})
+		Expect(err.Is(dnm.NotSupportedErr)).To(BeTrue())
+	})
+
+	It("should enable stream on migration", func() {
+		schema := &tFakeSchemaServer{live: d.TableDescriptionT}
+		schema.live.TableStatus = dnm.TableStatusActive
+		var spec map[string]interface{}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.Header.Get("X-Amz-Target"), "UpdateTable") {
+				body, _ := ioutil.ReadAll(r.Body)
+				var req map[string]interface{}
+				json.Unmarshal(body, &req)
+				spec, _ = req["StreamSpecification"].(map[string]interface{})
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+			schema.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&d, cfg)
+		_, err := store.Migrate(dnm.TMigrateConfig{})
+		Expect(err).To(BeNil())
+		Expect(spec).To(Equal(map[string]interface{}{"StreamEnabled": true, "StreamViewType": dnm.StreamViewNewAndOldImages}))
+	})
+
+	It("should reject unknown view types", func() {
+		Expect(func() {
+			dnm.Describe("Invalid", func(t dnm.ITable) {
+				t.Stream("ALL")
+			})
+		}).To(Panic())
+	})
+})
@@ -6,20 +6,31 @@ import (
 	"github.com/flowhealth/goamz/dynamodb"
 )
 
-func Describe(name string, definitions func(ITable)) dynamodb.TableDescriptionT {
+// TTableDescription is the description of a table along with its options,
+// stores made for it pick the options up
+type TTableDescription struct {
+	dynamodb.TableDescriptionT
+	opts tTableOptions
+}
+
+func Describe(name string, definitions func(ITable)) TTableDescription {
 	table := makeTable(name)
 	definitions(table)
 
-	return table.TableDescriptionT
+	return TTableDescription{table.TableDescriptionT, *table.opts}
 }
 
 type ITable interface {
 	KeyAttr(name string, maybeTyp ...string) *tAttr
 	NonKeyAttr(name string, maybeTyp ...string) *tAttr
+	VersionAttr(name string) *tAttr
 	PrimaryKey() iPrimaryKey
 	GlobalIndex(name string) iGlobalIndex
 	LocalIndex(name string) iLocalIndex
 	ProvisionedThroughput() iProvisionedThroughput
+	TimeToLive(attr AttributeDefinitionProvider) iTimeToLive
+	Stream(viewType string)
+	NativeTypes()
 }
 
 type iGlobalIndex interface {
@@ -35,6 +46,7 @@ type IndexProvider interface {
 	Hash(AttributeDefinitionProvider)
 	Range(AttributeDefinitionProvider)
 	Where(conds ...dynamodb.AttributeComparison) *dynamodb.Query
+	Query() *TQuery
 }
 
 type SecondaryIndexProvider interface {
@@ -46,6 +58,7 @@ type tTable struct {
 	dynamodb.TableDescriptionT
 	attrNames []string
 	name      string
+	opts      *tTableOptions
 }
 
 func makeTable(name string) *tTable {
@@ -54,7 +67,7 @@ func makeTable(name string) *tTable {
 		KeySchema:              []dynamodb.KeySchemaT{},
 		ProvisionedThroughput:  dynamodb.ProvisionedThroughputT{},
 		GlobalSecondaryIndexes: []dynamodb.GlobalSecondaryIndexT{},
-	}, []string{}, name}
+	}, []string{}, name, &tTableOptions{}}
 }
 
 func (self *tTable) KeyAttr(name string, maybeTyp ...string) *tAttr {
@@ -85,7 +98,20 @@ func (self *tTable) NonKeyAttr(name string, maybeTyp ...string) *tAttr {
 		panic(fmt.Sprintf("Incorrect table definition: duplicate attr name %s", name))
 	}
 	attr := dynamodb.AttributeDefinitionT{Name: name, Type: typ}
-	return makeAttr(&attr, func(v string) {})
+	res := makeAttr(&attr, func(v string) {})
+	res.opts = self.opts
+	return res
+}
+
+// VersionAttr declares the number attribute optimistic locking of the table
+// is based on, see version.go
+func (self *tTable) VersionAttr(name string) *tAttr {
+	if self.opts.version != "" {
+		panic(fmt.Sprintf("Incorrect table definition: version attr is already declared as %s", self.opts.version))
+	}
+	attr := self.NonKeyAttr(name, Number)
+	self.opts.version = name
+	return attr
 }
 
 func (self *tTable) PrimaryKey() iPrimaryKey {
@@ -94,9 +120,9 @@ func (self *tTable) PrimaryKey() iPrimaryKey {
 
 func (self *tTable) attrTypeSetter(name string) func(string) {
 	return func(newTyp string) {
-		for _, v := range self.AttributeDefinitions {
+		for n, v := range self.AttributeDefinitions {
 			if v.Name == name {
-				v.Type = newTyp
+				self.AttributeDefinitions[n].Type = newTyp
 			}
 		}
 	}
@@ -139,7 +165,7 @@ func (self *tTable) addGlobalIndex(name string) *dynamodb.GlobalSecondaryIndexT
 
 func (self *tTable) GlobalIndex(name string) iGlobalIndex {
 	idx := self.addGlobalIndex(name)
-	return makeGlobalIndex(self.name, idx)
+	return makeGlobalIndex(self.name, makeTableKeySchema(self), idx)
 }
 
 func (self *tTable) isLocalIndexUniqueName(name string) bool {
@@ -170,13 +196,48 @@ func (self *tTable) addLocalIndex(name string) *dynamodb.LocalSecondaryIndexT {
 
 func (self *tTable) LocalIndex(name string) iLocalIndex {
 	idx := self.addLocalIndex(name)
-	return makeLocalIndex(self.name, idx)
+	return makeLocalIndex(self.name, makeTableKeySchema(self), idx)
 }
 
 func (self *tTable) ProvisionedThroughput() iProvisionedThroughput {
 	return makeProvisionedThroughput(&self.TableDescriptionT.ProvisionedThroughput)
 }
 
+// TimeToLive declares the attribute DynamoDB purges expired items by, it has
+// to be a number of epoch seconds, e.g. AsTimeTime attribute
+func (self *tTable) TimeToLive(attr AttributeDefinitionProvider) iTimeToLive {
+	def := attr.Def()
+	if def.Type != Number {
+		panic(fmt.Sprintf("Incorrect table definition: time to live attr %s has to be a number", def.Name))
+	}
+	if self.opts.ttl != "" {
+		panic(fmt.Sprintf("Incorrect table definition: time to live attr is already declared as %s", self.opts.ttl))
+	}
+	self.opts.ttl = def.Name
+	return &tTimeToLive{self.opts}
+}
+
+// Stream enables the table stream, view type tells which images of changed
+// items are written to the stream, see stream.go
+func (self *tTable) Stream(viewType string) {
+	if !streamViewTypes[viewType] {
+		panic(fmt.Sprintf("Incorrect table definition: unknown stream view type %s", viewType))
+	}
+	if self.opts.streamView != "" {
+		panic(fmt.Sprintf("Incorrect table definition: stream is already enabled with view type %s", self.opts.streamView))
+	}
+	self.opts.streamView = viewType
+}
+
+// NativeTypes makes bool attributes use the BOOL type instead of numbers
+// 1 and 0 and empty string attributes the NULL type instead of the "NULL"
+// string, readers accept both encodings. Key attributes can't have these
+// types and keep the legacy encoding. SaveItem marshals structs natively,
+// writes by goamz expressions fail with NotSupportedErr.
+func (self *tTable) NativeTypes() {
+	self.opts.nativeTypes = true
+}
+
 func assertCorrectIndexName(name string) {
 	namelen := len(name)
 	conforms := namelen > 3 && namelen <= 255
@@ -184,3 +245,28 @@ func assertCorrectIndexName(name string) {
 		panic(fmt.Sprintf("Incorrect table definition: index name %s is illegal. Minimum length of 3. Maximum length of 255", name))
 	}
 }
+
+/**
+Table options, settings that have no place in the DynamoDB table
+description. Describe returns them along with it in TTableDescription,
+descriptions made by hand have none.
+*/
+
+type tTableOptions struct {
+	// name of the optimistic locking version attribute
+	version string
+	// name of the time to live attribute and whether reads skip expired items
+	ttl         string
+	hideExpired bool
+	// view type of the table stream, stream is disabled if empty
+	streamView string
+	// whether bool and empty string attributes use BOOL and NULL types
+	nativeTypes bool
+}
+
+// options of the store made for the description, stores don't share them
+// with the description
+func (self *TTableDescription) options() *tTableOptions {
+	opts := self.opts
+	return &opts
+}
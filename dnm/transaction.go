@@ -0,0 +1,524 @@
+package dnm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Transactional writes, all items of a transaction are written atomically or
+none of them is. Items may belong to different tables, all stores of a
+transaction have to be of the same kind (TStore or TMemStore).
+
+	key := counterKeys.Key(UserId.Is(uid))
+	err := dnm.MakeWriteTransaction().
+		PutConditional(sessions, []dynamodb.Attribute{Id.Is(sid), UserId.Is(uid)}, []dynamodb.Attribute{dnm.ExpectMissing(Id)}).
+		Add(counters, &key, SessionCount.Is("1")).
+		Commit()
+
+When a transaction is cancelled the error wraps *TTransactionCanceled, which
+holds errors of every item in order they were added to the transaction.
+*/
+
+const (
+	TransactionItemLimit = 100
+
+	TxPut            = "Put"
+	TxUpdate         = "Update"
+	TxDelete         = "Delete"
+	TxConditionCheck = "ConditionCheck"
+	TxGet            = "Get"
+)
+
+var txReasonCodes = map[string]TErrorCode{
+	"ConditionalCheckFailed":        ErrCodeConditional,
+	"ProvisionedThroughputExceeded": ErrCodeThrottled,
+	"ThrottlingError":               ErrCodeThrottled,
+	"TransactionConflict":           ErrCodeThrottled,
+	"ValidationError":               ErrCodeValidation,
+}
+
+type TWriteTransaction struct {
+	items []*tTxItem
+}
+
+type tTxItem struct {
+	op       string
+	store    IStore
+	item     map[string]*dynamodb.Attribute
+	key      *dynamodb.Key
+	set      []dynamodb.Attribute
+	add      []dynamodb.Attribute
+	expected []dynamodb.Attribute
+}
+
+// TTransactionCanceled lists errors of transaction items, nil for items
+// which didn't cause the cancellation
+type TTransactionCanceled struct {
+	Reasons []*TError
+}
+
+func (self *TTransactionCanceled) Error() string {
+	failed := []string{}
+	for n, v := range self.Reasons {
+		if v != nil {
+			failed = append(failed, fmt.Sprintf("item %d: %s %s", n, v.Op, v.Code))
+		}
+	}
+	return "transaction cancelled, " + strings.Join(failed, ", ")
+}
+
+// FailedItems returns positions of items which caused the cancellation
+func (self *TTransactionCanceled) FailedItems() []int {
+	failed := []int{}
+	for n, v := range self.Reasons {
+		if v != nil {
+			failed = append(failed, n)
+		}
+	}
+	return failed
+}
+
+func (self *TTransactionCanceled) code() TErrorCode {
+	code := ErrCodeUnknown
+	for _, v := range self.Reasons {
+		if v.IsConditional() {
+			return ErrCodeConditional
+		}
+		if v != nil && code == ErrCodeUnknown {
+			code = v.Code
+		}
+	}
+	return code
+}
+
+func MakeWriteTransaction() *TWriteTransaction {
+	return &TWriteTransaction{}
+}
+
+func (self *TWriteTransaction) Put(store IStore, attrs ...dynamodb.Attribute) *TWriteTransaction {
+	return self.PutConditional(store, attrs, nil)
+}
+
+func (self *TWriteTransaction) PutConditional(store IStore, attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TWriteTransaction {
+	self.items = append(self.items, &tTxItem{op: TxPut, store: store, item: makeItem(attrs), expected: expected})
+	return self
+}
+
+// Update sets attributes of the item, the item is created if it doesn't exist
+func (self *TWriteTransaction) Update(store IStore, key *dynamodb.Key, attrs ...dynamodb.Attribute) *TWriteTransaction {
+	return self.UpdateConditional(store, key, attrs, nil)
+}
+
+func (self *TWriteTransaction) UpdateConditional(store IStore, key *dynamodb.Key, attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TWriteTransaction {
+	self.items = append(self.items, &tTxItem{op: TxUpdate, store: store, key: key, set: attrs, expected: expected})
+	return self
+}
+
+// Add increments numeric attributes and adds members to set attributes
+func (self *TWriteTransaction) Add(store IStore, key *dynamodb.Key, attrs ...dynamodb.Attribute) *TWriteTransaction {
+	return self.AddConditional(store, key, attrs, nil)
+}
+
+func (self *TWriteTransaction) AddConditional(store IStore, key *dynamodb.Key, attrs []dynamodb.Attribute, expected []dynamodb.Attribute) *TWriteTransaction {
+	self.items = append(self.items, &tTxItem{op: TxUpdate, store: store, key: key, add: attrs, expected: expected})
+	return self
+}
+
+func (self *TWriteTransaction) Delete(store IStore, key *dynamodb.Key) *TWriteTransaction {
+	return self.DeleteConditional(store, key, nil)
+}
+
+func (self *TWriteTransaction) DeleteConditional(store IStore, key *dynamodb.Key, expected []dynamodb.Attribute) *TWriteTransaction {
+	self.items = append(self.items, &tTxItem{op: TxDelete, store: store, key: key, expected: expected})
+	return self
+}
+
+// ConditionCheck makes the transaction depend on an item it doesn't modify
+func (self *TWriteTransaction) ConditionCheck(store IStore, key *dynamodb.Key, expected []dynamodb.Attribute) *TWriteTransaction {
+	self.items = append(self.items, &tTxItem{op: TxConditionCheck, store: store, key: key, expected: expected})
+	return self
+}
+
+func (self *TWriteTransaction) Commit() *TError {
+	if len(self.items) == 0 {
+		return nil
+	}
+	stores, ctx, err := resolveTxStores(self.items)
+	if err != nil {
+		return err
+	}
+	if tErr := validateTxItems("Commit", self.items, stores); tErr != nil {
+		return tErr
+	}
+	switch first := stores[0].(type) {
+	case *TStore:
+		return first.WithContext(ctx).(*TStore).commit(self.items, stores)
+	default:
+		if err := ctx.Err(); err != nil {
+			return makeOpError(CancelledErr, txTables(self.items, stores), "Commit", err)
+		}
+		return commitMem(self.items, stores)
+	}
+}
+
+/**
+Transaction helpers shared by writes and reads
+*/
+
+// resolveTxStores unwraps context bound stores, the context of the first
+// store is used for the whole transaction
+func resolveTxStores(items []*tTxItem) ([]IStore, context.Context, *TError) {
+	stores := make([]IStore, len(items))
+	var ctx context.Context
+	for n, v := range items {
+		store := v.store
+		storeCtx := context.Background()
+		if cs, ok := store.(*tContextStore); ok {
+			store, storeCtx = cs.store, cs.ctx
+		}
+		if ts, ok := store.(*TStore); ok {
+			storeCtx = ts.context()
+		}
+		if ctx == nil {
+			ctx = storeCtx
+		}
+		switch store.(type) {
+		case *TStore, *TMemStore:
+		default:
+			return nil, nil, wrapError(NotSupportedErr, fmt.Errorf("transactions are not supported by %T", store))
+		}
+		if _, first := stores[0].(*TStore); n > 0 && first != isTStore(store) {
+			return nil, nil, wrapError(NotSupportedErr, fmt.Errorf("transaction can't mix %T and %T", stores[0], store))
+		}
+		stores[n] = store
+	}
+	return stores, ctx, nil
+}
+
+func isTStore(store IStore) bool {
+	_, ok := store.(*TStore)
+	return ok
+}
+
+func storeTableDesc(store IStore) *dynamodb.TableDescriptionT {
+	switch store := store.(type) {
+	case *TStore:
+		return store.tableDesc
+	case *TMemStore:
+		return store.tableDesc
+	case *tContextStore:
+		return storeTableDesc(store.store)
+	}
+	return nil
+}
+
+func txTables(items []*tTxItem, stores []IStore) string {
+	tables := []string{}
+	seen := map[string]bool{}
+	for n := range items {
+		name := storeTableDesc(stores[n]).TableName
+		if !seen[name] {
+			seen[name] = true
+			tables = append(tables, name)
+		}
+	}
+	return strings.Join(tables, ",")
+}
+
+func (self *tTxItem) tableKey(desc *dynamodb.TableDescriptionT) dynamodb.Key {
+	if self.item != nil {
+		return itemTableKey(desc, self.item)
+	}
+	return *self.key
+}
+
+func validateTxItems(op string, items []*tTxItem, stores []IStore) *TError {
+	tables := txTables(items, stores)
+	if len(items) > TransactionItemLimit {
+		err := fmt.Errorf("transaction has %d items, at most %d are allowed", len(items), TransactionItemLimit)
+		return makeOpError(TransactionErr, tables, op, err)
+	}
+	seen := map[string]bool{}
+	for n, v := range items {
+		desc := storeTableDesc(stores[n])
+		if v.op != TxGet && v.op != TxConditionCheck {
+			if err := storeOptions(stores[n]).unversioned(); err != nil {
+				return makeOpError(NotSupportedErr, desc.TableName, v.op, err)
+			}
+		}
+		if v.item != nil {
+			if err := validateItemKey(desc, v.item); err != nil {
+				return makeOpError(TransactionErr, desc.TableName, v.op, err)
+			}
+		} else if v.key == nil {
+			return makeOpError(TransactionErr, desc.TableName, v.op, fmt.Errorf("key of item %d is not defined", n))
+		}
+		if v.op == TxConditionCheck && len(v.expected) == 0 {
+			return makeOpError(TransactionErr, desc.TableName, v.op, fmt.Errorf("condition check of item %d has no expected attributes", n))
+		}
+		for _, attr := range append(append([]dynamodb.Attribute{}, v.set...), v.add...) {
+			if attr.Name == keyAttrName(desc.KeySchema, KeyHash) || attr.Name == keyAttrName(desc.KeySchema, KeyRange) {
+				err := fmt.Errorf("cannot update attribute %s, this attribute is part of the key", attr.Name)
+				return makeOpError(TransactionErr, desc.TableName, v.op, err)
+			}
+		}
+		// an item can be read several times, but written only once
+		id := desc.TableName + "\x00" + keyId(v.tableKey(desc))
+		if seen[id] && v.op != TxGet {
+			return makeOpError(TransactionErr, desc.TableName, v.op, fmt.Errorf("item %d is already part of the transaction", n))
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+func makeTxCanceled(items []*tTxItem, stores []IStore, codes []string, messages []string) *TTransactionCanceled {
+	canceled := &TTransactionCanceled{Reasons: make([]*TError, len(items))}
+	for n := range items {
+		if n >= len(codes) || codes[n] == "" || codes[n] == "None" {
+			continue
+		}
+		table := storeTableDesc(stores[n]).TableName
+		details := fmt.Errorf("%s: %s", codes[n], messages[n])
+		code, ok := txReasonCodes[codes[n]]
+		switch {
+		case code == ErrCodeConditional:
+			canceled.Reasons[n] = makeOpError(ConditionalErr, table, items[n].op, details)
+		case ok:
+			reason := makeOpError(TransactionCancelErr, table, items[n].op, details)
+			reason.Code = code
+			canceled.Reasons[n] = reason
+		default:
+			canceled.Reasons[n] = makeOpError(TransactionCancelErr, table, items[n].op, details)
+		}
+	}
+	return canceled
+}
+
+/**
+DynamoDB transactions
+*/
+
+func (self *TStore) commit(items []*tTxItem, stores []IStore) *TError {
+	tables := txTables(items, stores)
+	transactItems := make([]map[string]interface{}, len(items))
+	for n, v := range items {
+		desc := storeTableDesc(stores[n])
+		ctx := makeExprContext()
+		req := map[string]interface{}{"TableName": desc.TableName}
+		if v.item != nil {
+			req["Item"] = toWireItem(v.item)
+		} else {
+			req["Key"] = toWireItem(tableKeyItem(desc, v.key))
+		}
+		if v.op == TxUpdate {
+			req["UpdateExpression"] = ctx.update(v.set, v.add)
+		}
+		if cond := ctx.expected(v.expected); cond != "" {
+			req["ConditionExpression"] = cond
+		}
+		ctx.apply(req)
+		transactItems[n] = map[string]interface{}{v.op: req}
+	}
+	req := map[string]interface{}{"TransactItems": transactItems, "ClientRequestToken": makeRequestToken()}
+	err := self.rpc("TransactWriteItems", req, nil)
+	if err == nil {
+		return nil
+	}
+	if wErr, ok := err.(*tWireCanceledError); ok {
+		codes := make([]string, len(wErr.reasons))
+		messages := make([]string, len(wErr.reasons))
+		for n, v := range wErr.reasons {
+			codes[n], messages[n] = v.Code, v.Message
+		}
+		canceled := makeTxCanceled(items, stores, codes, messages)
+		if canceled.code() != ErrCodeConditional {
+			log.WithFields(log.Fields{
+				LogTable:      tables,
+				fhlog.FHError: canceled.Error(),
+			}).Error("Error in Commit()")
+		}
+		return makeOpError(TransactionCancelErr, tables, "Commit", canceled)
+	}
+	log.WithFields(log.Fields{
+		LogTable:      tables,
+		fhlog.FHError: err.Error(),
+	}).Error("Error in Commit()")
+	return makeOpError(TransactionErr, tables, "Commit", err)
+}
+
+// makeRequestToken identifies attempts of one transaction, DynamoDB doesn't
+// apply a transaction twice when its retry carries the same token
+func makeRequestToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// update translates set and add actions into an update expression
+func (self *tExprContext) update(set, add []dynamodb.Attribute) string {
+	clauses := []string{}
+	if len(set) > 0 {
+		actions := []string{}
+		for n := range set {
+			actions = append(actions, fmt.Sprintf("%s = %s", self.name(set[n].Name), self.value(&set[n])))
+		}
+		clauses = append(clauses, "SET "+strings.Join(actions, ", "))
+	}
+	if len(add) > 0 {
+		actions := []string{}
+		for n := range add {
+			actions = append(actions, fmt.Sprintf("%s %s", self.name(add[n].Name), self.value(&add[n])))
+		}
+		clauses = append(clauses, "ADD "+strings.Join(actions, ", "))
+	}
+	return strings.Join(clauses, " ")
+}
+
+/**
+In-memory transactions, every involved store is locked until the
+transaction is over
+*/
+
+func lockMemStores(stores []IStore) func() {
+	mems := []*TMemStore{}
+	seen := map[*TMemStore]bool{}
+	for _, v := range stores {
+		if ms := v.(*TMemStore); !seen[ms] {
+			seen[ms] = true
+			mems = append(mems, ms)
+		}
+	}
+	// fixed order prevents deadlocks of concurrent transactions
+	sort.Slice(mems, func(i, j int) bool {
+		return fmt.Sprintf("%p", mems[i]) < fmt.Sprintf("%p", mems[j])
+	})
+	for _, v := range mems {
+		v.lock.Lock()
+	}
+	return func() {
+		for _, v := range mems {
+			v.lock.Unlock()
+		}
+	}
+}
+
+func commitMem(items []*tTxItem, stores []IStore) *TError {
+	unlock := lockMemStores(stores)
+	defer unlock()
+
+	codes := make([]string, len(items))
+	messages := make([]string, len(items))
+	failed := false
+	updated := make([]map[string]*dynamodb.Attribute, len(items))
+	for n, v := range items {
+		ms := stores[n].(*TMemStore)
+		key := v.tableKey(ms.tableDesc)
+		existing := ms.items[ms.memKey(&key)]
+		if !matchExpected(existing, v.expected) {
+			codes[n], messages[n] = "ConditionalCheckFailed", "item doesn't match expected attributes"
+			failed = true
+			continue
+		}
+		if v.op == TxUpdate {
+			item, err := ms.updateItem(existing, &key, v.set, v.add)
+			if err != nil {
+				codes[n], messages[n] = "ValidationError", err.Error()
+				failed = true
+				continue
+			}
+			updated[n] = item
+		}
+	}
+	if failed {
+		canceled := makeTxCanceled(items, stores, codes, messages)
+		return makeOpError(TransactionCancelErr, txTables(items, stores), "Commit", canceled)
+	}
+	for n, v := range items {
+		ms := stores[n].(*TMemStore)
+		key := v.tableKey(ms.tableDesc)
+		switch v.op {
+		case TxPut:
+			ms.items[ms.memKey(&key)] = copyItem(v.item)
+		case TxUpdate:
+			ms.items[ms.memKey(&key)] = updated[n]
+		case TxDelete:
+			delete(ms.items, ms.memKey(&key))
+		}
+	}
+	return nil
+}
+
+// updateItem returns a copy of the item with set and add actions applied
+func (self *TMemStore) updateItem(existing map[string]*dynamodb.Attribute, key *dynamodb.Key,
+	set, add []dynamodb.Attribute) (map[string]*dynamodb.Attribute, error) {
+
+	var item map[string]*dynamodb.Attribute
+	if existing != nil {
+		item = copyItem(existing)
+	} else {
+		item = self.keyItem(key)
+	}
+	for k, v := range makeItem(set) {
+		item[k] = v
+	}
+	for n := range add {
+		attr, err := addAttrs(item[add[n].Name], &add[n])
+		if err != nil {
+			return nil, err
+		}
+		item[add[n].Name] = attr
+	}
+	return item, nil
+}
+
+// addAttrs implements ADD action, numbers are summed and sets are merged
+func addAttrs(attr, delta *dynamodb.Attribute) (*dynamodb.Attribute, error) {
+	if attr == nil {
+		return copyAttr(delta), nil
+	}
+	if attr.Type != delta.Type {
+		return nil, fmt.Errorf("cannot add %s value to %s attribute %s", delta.Type, attr.Type, attr.Name)
+	}
+	res := copyAttr(attr)
+	switch {
+	case attr.Type == dynamodb.TYPE_NUMBER:
+		sum, err := addNumbers(attr.Value, delta.Value)
+		if err != nil {
+			return nil, err
+		}
+		res.Value = sum
+	case isSetType(attr.Type):
+		for _, v := range delta.SetValues {
+			if !setContains(attr.Type, res.SetValues, v) {
+				res.SetValues = append(res.SetValues, v)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cannot add to %s attribute %s", attr.Type, attr.Name)
+	}
+	return res, nil
+}
+
+func addNumbers(a, b string) (string, error) {
+	ar, aok := new(big.Rat).SetString(a)
+	br, bok := new(big.Rat).SetString(b)
+	if !aok || !bok {
+		return "", fmt.Errorf("invalid numeric values %s, %s", a, b)
+	}
+	sum := ar.Add(ar, br)
+	if sum.IsInt() {
+		return sum.Num().String(), nil
+	}
+	return strings.TrimRight(sum.FloatString(38), "0"), nil
+}
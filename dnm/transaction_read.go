@@ -0,0 +1,135 @@
+package dnm
+
+import (
+	"time"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Transactional reads, items are read from a single consistent snapshot even
+when they belong to different tables.
+
+	items, errs := dnm.MakeReadTransaction().
+		Get(sessions, &sessionKey).
+		Get(counters, &counterKey).
+		Fetch()
+
+Items and errors are returned in order of Get calls, missing items are
+reported with NotFoundErr. Errors are nil when every item was found.
+*/
+
+type TReadTransaction struct {
+	items []*tTxItem
+}
+
+func MakeReadTransaction() *TReadTransaction {
+	return &TReadTransaction{}
+}
+
+func (self *TReadTransaction) Get(store IStore, key *dynamodb.Key) *TReadTransaction {
+	self.items = append(self.items, &tTxItem{op: TxGet, store: store, key: key})
+	return self
+}
+
+func (self *TReadTransaction) Fetch() ([]map[string]*dynamodb.Attribute, []*TError) {
+	if len(self.items) == 0 {
+		return nil, nil
+	}
+	stores, ctx, tErr := resolveTxStores(self.items)
+	if tErr == nil {
+		tErr = validateTxItems("Fetch", self.items, stores)
+	}
+	if tErr != nil {
+		return failTxRead(self.items, tErr)
+	}
+	switch first := stores[0].(type) {
+	case *TStore:
+		return first.WithContext(ctx).(*TStore).fetch(self.items, stores)
+	default:
+		if err := ctx.Err(); err != nil {
+			return failTxRead(self.items, makeOpError(CancelledErr, txTables(self.items, stores), "Fetch", err))
+		}
+		return fetchMem(self.items, stores)
+	}
+}
+
+func failTxRead(items []*tTxItem, tErr *TError) ([]map[string]*dynamodb.Attribute, []*TError) {
+	errs := make([]*TError, len(items))
+	for n := range errs {
+		errs[n] = tErr
+	}
+	return make([]map[string]*dynamodb.Attribute, len(items)), errs
+}
+
+// fetch reads every item once, DynamoDB rejects transactions reading an item
+// twice, responses are fanned back out in order of Get calls
+func (self *TStore) fetch(items []*tTxItem, stores []IStore) ([]map[string]*dynamodb.Attribute, []*TError) {
+	transactItems := []map[string]interface{}{}
+	slots := make([]int, len(items))
+	seen := map[string]int{}
+	for n, v := range items {
+		desc := storeTableDesc(stores[n])
+		id := desc.TableName + "\x00" + keyId(*v.key)
+		slot, ok := seen[id]
+		if !ok {
+			slot = len(transactItems)
+			seen[id] = slot
+			transactItems = append(transactItems, map[string]interface{}{
+				TxGet: map[string]interface{}{
+					"TableName": desc.TableName,
+					"Key":       toWireItem(tableKeyItem(desc, v.key)),
+				},
+			})
+		}
+		slots[n] = slot
+	}
+	var resp struct {
+		Responses []struct {
+			Item tWireItem
+		}
+	}
+	if err := self.rpc("TransactGetItems", map[string]interface{}{"TransactItems": transactItems}, &resp); err != nil {
+		tables := txTables(items, stores)
+		log.WithFields(log.Fields{
+			LogTable:      tables,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in Fetch()")
+		return failTxRead(items, makeOpError(TransactionErr, tables, "Fetch", err))
+	}
+	res := make([]map[string]*dynamodb.Attribute, len(items))
+	errs := make([]*TError, len(items))
+	now := time.Now()
+	for n, slot := range slots {
+		var item map[string]*dynamodb.Attribute
+		if slot < len(resp.Responses) && len(resp.Responses[slot].Item) > 0 {
+			item = fromWireItem(resp.Responses[slot].Item)
+		}
+		if item != nil && !storeOptions(stores[n]).expired(item, now) {
+			res[n] = item
+		} else {
+			errs[n] = makeOpError(NotFoundErr, storeTableDesc(stores[n]).TableName, "Fetch", dynamodb.ErrNotFound)
+		}
+	}
+	return res, batchErrors(errs)
+}
+
+func fetchMem(items []*tTxItem, stores []IStore) ([]map[string]*dynamodb.Attribute, []*TError) {
+	unlock := lockMemStores(stores)
+	defer unlock()
+
+	res := make([]map[string]*dynamodb.Attribute, len(items))
+	errs := make([]*TError, len(items))
+	now := time.Now()
+	for n, v := range items {
+		ms := stores[n].(*TMemStore)
+		if item, ok := ms.items[ms.memKey(v.key)]; ok && !ms.opts.expired(item, now) {
+			res[n] = copyItem(item)
+		} else {
+			errs[n] = ms.makeError(NotFoundErr, "Fetch", dynamodb.ErrNotFound)
+		}
+	}
+	return res, batchErrors(errs)
+}
@@ -0,0 +1,96 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReadTransaction", func() {
+	var (
+		sid, uid, count          dnm.IAttr
+		sessionKeys, counterKeys dnm.IKeyFactory
+	)
+	sessionsDesc := dnm.Describe("Sessions", func(t dnm.ITable) {
+		sid = t.KeyAttr("Id", dnm.String)
+		p := t.PrimaryKey()
+		p.Hash(sid)
+		sessionKeys = p.Factory()
+	})
+	countersDesc := dnm.Describe("SessionCounters", func(t dnm.ITable) {
+		uid = t.KeyAttr("UserId", dnm.String)
+		count = t.NonKeyAttr("Count", dnm.Number)
+		p := t.PrimaryKey()
+		p.Hash(uid)
+		counterKeys = p.Factory()
+	})
+
+	It("should read items of several memory stores in request order", func() {
+		sessions := dnm.MakeMemStore(&sessionsDesc)
+		counters := dnm.MakeMemStore(&countersDesc)
+		Expect(sessions.Save(sid.Is("sid:1"))).To(BeNil())
+		Expect(counters.Save(uid.Is("uid:1"), count.Is("3"))).To(BeNil())
+		cKey := counterKeys.Key(uid.Is("uid:1"))
+		sKey := sessionKeys.Key(sid.Is("sid:1"))
+		missing := sessionKeys.Key(sid.Is("sid:2"))
+		items, errs := dnm.MakeReadTransaction().
+			Get(counters, &cKey).
+			Get(sessions, &missing).
+			Get(sessions, &sKey).
+			Fetch()
+		Expect(errs).To(HaveLen(3))
+		Expect(errs[0]).To(BeNil())
+		Expect(errs[1].IsNotFound()).To(BeTrue())
+		Expect(errs[2]).To(BeNil())
+		Expect(count.From(items[0])).To(Equal("3"))
+		Expect(items[1]).To(BeNil())
+		Expect(sid.From(items[2])).To(Equal("sid:1"))
+	})
+
+	It("should read items from DynamoDB", func() {
+		var body map[string][]map[string]map[string]interface{}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&body)
+			fmt.Fprint(w, `{"Responses":[{},{"Item":{"UserId":{"S":"uid:1"},"Count":{"N":"3"}}}]}`)
+		}))
+		defer server.Close()
+		sessions, _ := dnm.MakeStore(&sessionsDesc, cfg)
+		counters, _ := dnm.MakeStore(&countersDesc, cfg)
+		sKey := sessionKeys.Key(sid.Is("sid:1"))
+		cKey := counterKeys.Key(uid.Is("uid:1"))
+		items, errs := dnm.MakeReadTransaction().Get(sessions, &sKey).Get(counters, &cKey).Fetch()
+
+		Expect(body["TransactItems"]).To(HaveLen(2))
+		Expect(body["TransactItems"][1]["Get"]["TableName"]).To(Equal("SessionCounters"))
+		Expect(errs[0].IsNotFound()).To(BeTrue())
+		Expect(errs[1]).To(BeNil())
+		Expect(count.From(items[1])).To(Equal("3"))
+	})
+
+	It("should read an item requested twice once", func() {
+		var body map[string][]map[string]map[string]interface{}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&body)
+			fmt.Fprint(w, `{"Responses":[{"Item":{"Id":{"S":"sid:1"}}},{}]}`)
+		}))
+		defer server.Close()
+		sessions, _ := dnm.MakeStore(&sessionsDesc, cfg)
+		sKey := sessionKeys.Key(sid.Is("sid:1"))
+		missing := sessionKeys.Key(sid.Is("sid:2"))
+		items, errs := dnm.MakeReadTransaction().
+			Get(sessions, &sKey).
+			Get(sessions, &missing).
+			Get(sessions, &sKey).
+			Fetch()
+
+		Expect(body["TransactItems"]).To(HaveLen(2))
+		Expect(errs).To(HaveLen(3))
+		Expect(errs[1].IsNotFound()).To(BeTrue())
+		Expect(sid.From(items[0])).To(Equal("sid:1"))
+		Expect(sid.From(items[2])).To(Equal("sid:1"))
+	})
+})
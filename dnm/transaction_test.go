@@ -0,0 +1,159 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteTransaction", func() {
+	var (
+		sid, sessionUser, uid, count dnm.IAttr
+		sessionKeys, counterKeys     dnm.IKeyFactory
+	)
+	sessionsDesc := dnm.Describe("Sessions", func(t dnm.ITable) {
+		sid = t.KeyAttr("Id", dnm.String)
+		sessionUser = t.NonKeyAttr("UserId", dnm.String)
+		p := t.PrimaryKey()
+		p.Hash(sid)
+		sessionKeys = p.Factory()
+	})
+	countersDesc := dnm.Describe("SessionCounters", func(t dnm.ITable) {
+		uid = t.KeyAttr("UserId", dnm.String)
+		count = t.NonKeyAttr("Count", dnm.Number)
+		p := t.PrimaryKey()
+		p.Hash(uid)
+		counterKeys = p.Factory()
+	})
+
+	Context("MemStore", func() {
+		var sessions, counters dnm.IStore
+		createSession := func(id, user string) *dnm.TError {
+			key := counterKeys.Key(uid.Is(user))
+			return dnm.MakeWriteTransaction().
+				PutConditional(sessions, []dynamodb.Attribute{sid.Is(id), sessionUser.Is(user)}, []dynamodb.Attribute{dnm.ExpectMissing(sid)}).
+				Add(counters, &key, count.Is("1")).
+				Commit()
+		}
+		counter := func(user string) string {
+			key := counterKeys.Key(uid.Is(user))
+			item, _ := counters.Get(&key)
+			return count.From(item)
+		}
+
+		BeforeEach(func() {
+			sessions = dnm.MakeMemStore(&sessionsDesc)
+			counters = dnm.MakeMemStore(&countersDesc)
+		})
+
+		It("should write items of several tables", func() {
+			Expect(createSession("sid:1", "uid:1")).To(BeNil())
+			Expect(createSession("sid:2", "uid:1")).To(BeNil())
+			Expect(counter("uid:1")).To(Equal("2"))
+		})
+
+		It("should write nothing when a condition fails", func() {
+			Expect(createSession("sid:1", "uid:1")).To(BeNil())
+			err := createSession("sid:1", "uid:2")
+			Expect(err.IsConditional()).To(BeTrue())
+			var canceled *dnm.TTransactionCanceled
+			Expect(errors.As(err, &canceled)).To(BeTrue())
+			Expect(canceled.FailedItems()).To(Equal([]int{0}))
+			Expect(canceled.Reasons[0].Table).To(Equal("Sessions"))
+			Expect(canceled.Reasons[0].Op).To(Equal(dnm.TxPut))
+			Expect(counter("uid:2")).To(Equal(""))
+		})
+
+		It("should check conditions of items it doesn't modify", func() {
+			Expect(createSession("sid:1", "uid:1")).To(BeNil())
+			key := sessionKeys.Key(sid.Is("sid:1"))
+			cKey := counterKeys.Key(uid.Is("uid:1"))
+			err := dnm.MakeWriteTransaction().
+				ConditionCheck(sessions, &key, []dynamodb.Attribute{sessionUser.Is("uid:2")}).
+				Delete(counters, &cKey).
+				Commit()
+			Expect(err.IsConditional()).To(BeTrue())
+			Expect(counter("uid:1")).To(Equal("1"))
+			Expect(dnm.MakeWriteTransaction().
+				ConditionCheck(sessions, &key, []dynamodb.Attribute{sessionUser.Is("uid:1")}).
+				Delete(counters, &cKey).
+				Commit()).To(BeNil())
+			Expect(counter("uid:1")).To(Equal(""))
+
+			err = dnm.MakeWriteTransaction().ConditionCheck(sessions, &key, nil).Commit()
+			Expect(err.Is(dnm.TransactionErr)).To(BeTrue())
+			Expect(err.Op).To(Equal(dnm.TxConditionCheck))
+		})
+
+		It("should refuse the same item twice", func() {
+			key := sessionKeys.Key(sid.Is("sid:1"))
+			err := dnm.MakeWriteTransaction().
+				Put(sessions, sid.Is("sid:1")).
+				Update(sessions, &key, sessionUser.Is("uid:1")).
+				Commit()
+			Expect(err).ToNot(BeNil())
+			Expect(err.Op).To(Equal(dnm.TxUpdate))
+		})
+	})
+
+	Context("TStore", func() {
+		var (
+			server *httptest.Server
+			cfg    *dnm.TStoreConfig
+			body   map[string][]map[string]map[string]interface{}
+		)
+		BeforeEach(func() {
+			server, cfg = fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&body)
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#TransactionCanceledException",`+
+					`"message":"Transaction cancelled","CancellationReasons":[{"Code":"None"},{"Code":"ConditionalCheckFailed","Message":"failed"}]}`)
+			}))
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should send items and report failed one", func() {
+			sessions, _ := dnm.MakeStore(&sessionsDesc, cfg)
+			counters, _ := dnm.MakeStore(&countersDesc, cfg)
+			key := counterKeys.Key(uid.Is("uid:1"))
+			err := dnm.MakeWriteTransaction().
+				Put(sessions, sid.Is("sid:1"), sessionUser.Is("uid:1")).
+				AddConditional(counters, &key, []dynamodb.Attribute{count.Is("1")}, []dynamodb.Attribute{count.Is("0")}).
+				Commit()
+
+			items := body["TransactItems"]
+			Expect(items).To(HaveLen(2))
+			Expect(items[0]["Put"]["TableName"]).To(Equal("Sessions"))
+			update := items[1]["Update"]
+			Expect(update["TableName"]).To(Equal("SessionCounters"))
+			Expect(update["UpdateExpression"]).To(Equal("ADD #n0 :v0"))
+			Expect(update["ConditionExpression"]).To(Equal("#n0 = :v1"))
+			Expect(update["ExpressionAttributeNames"]).To(Equal(map[string]interface{}{"#n0": "Count"}))
+
+			Expect(err.IsConditional()).To(BeTrue())
+			var canceled *dnm.TTransactionCanceled
+			Expect(errors.As(err, &canceled)).To(BeTrue())
+			Expect(canceled.FailedItems()).To(Equal([]int{1}))
+			Expect(canceled.Reasons[1].Table).To(Equal("SessionCounters"))
+		})
+
+		It("should refuse to mix store kinds", func() {
+			sessions, _ := dnm.MakeStore(&sessionsDesc, cfg)
+			key := counterKeys.Key(uid.Is("uid:1"))
+			err := dnm.MakeWriteTransaction().
+				Put(sessions, sid.Is("sid:1")).
+				Add(dnm.MakeMemStore(&countersDesc), &key, count.Is("1")).
+				Commit()
+			Expect(err.Is(dnm.NotSupportedErr)).To(BeTrue())
+		})
+	})
+})
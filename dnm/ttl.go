@@ -0,0 +1,123 @@
+package dnm
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Time to live. DynamoDB deletes items of a table with TTL enabled some time
+after the epoch seconds of their TTL attribute have passed, Init and Migrate
+enable TTL for the attribute declared with TimeToLive.
+
+	expires := t.NonKeyAttr("ExpiresAt").AsTimeTime()
+	t.TimeToLive(expires).HideExpired()
+
+Deletion lags behind expiry up to a couple of days, with HideExpired Get,
+BatchGet, Find, FindIter and read transactions skip items which are expired
+but not deleted yet, reporting them as missing. Items without the attribute
+or with a malformed value never expire, neither do items whose value is not
+positive or older than TTLMaxAge, DynamoDB ignores those, e.g. the zero
+time.Time.
+*/
+
+const (
+	TTLStatusEnabling  = "ENABLING"
+	TTLStatusEnabled   = "ENABLED"
+	TTLStatusDisabling = "DISABLING"
+	TTLStatusDisabled  = "DISABLED"
+	// values further in the past are not deleted by DynamoDB
+	TTLMaxAge = 5 * 365 * 24 * time.Hour
+)
+
+type iTimeToLive interface {
+	HideExpired()
+}
+
+type tTimeToLive struct {
+	opts *tTableOptions
+}
+
+func (self *tTimeToLive) HideExpired() {
+	self.opts.hideExpired = true
+}
+
+func (self *tTableOptions) expired(item map[string]*dynamodb.Attribute, now time.Time) bool {
+	if !self.hideExpired || item == nil {
+		return false
+	}
+	attr, ok := item[self.ttl]
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(attr.Value, 10, 64)
+	return err == nil && expires > 0 && expires < now.Unix() && expires >= now.Add(-TTLMaxAge).Unix()
+}
+
+// unexpired filters items in place
+func (self *tTableOptions) unexpired(items []map[string]*dynamodb.Attribute) []map[string]*dynamodb.Attribute {
+	if !self.hideExpired {
+		return items
+	}
+	now := time.Now()
+	res := items[:0]
+	for _, v := range items {
+		if !self.expired(v, now) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// enableTTL turns TTL of the table on unless it's already enabled
+func (self *TStore) enableTTL(op string) *TError {
+	if self.opts.ttl == "" {
+		return nil
+	}
+	tableName := self.tableDesc.TableName
+	var desc struct {
+		TimeToLiveDescription struct {
+			AttributeName    string
+			TimeToLiveStatus string
+		}
+	}
+	if err := self.rpc("DescribeTimeToLive", map[string]interface{}{"TableName": tableName}, &desc); err != nil {
+		log.WithFields(log.Fields{
+			LogTable:      tableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in enableTTL()")
+		return self.makeError(TTLErr, op, err)
+	}
+	current := desc.TimeToLiveDescription
+	switch current.TimeToLiveStatus {
+	case TTLStatusEnabled, TTLStatusEnabling:
+		if current.AttributeName == self.opts.ttl {
+			log.WithField(LogTable, tableName).Debug("Time to live is enabled")
+			return nil
+		}
+		return self.makeError(TTLErr, op, fmt.Errorf("time to live is enabled for attribute %s", current.AttributeName))
+	case TTLStatusDisabling:
+		return self.makeError(TTLErr, op, fmt.Errorf("time to live is being disabled"))
+	}
+	req := map[string]interface{}{
+		"TableName": tableName,
+		"TimeToLiveSpecification": map[string]interface{}{
+			"AttributeName": self.opts.ttl,
+			"Enabled":       true,
+		},
+	}
+	log.WithField(LogTable, tableName).Info("Enabling time to live")
+	if err := self.rpc("UpdateTimeToLive", req, nil); err != nil {
+		log.WithFields(log.Fields{
+			LogTable:      tableName,
+			fhlog.FHError: err.Error(),
+		}).Error("Error in enableTTL()")
+		return self.makeError(TTLErr, op, err)
+	}
+	return nil
+}
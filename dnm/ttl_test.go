@@ -0,0 +1,163 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Time to live", func() {
+	var (
+		sid, user dnm.IAttr
+		expires   interface {
+			Def() *dynamodb.AttributeDefinitionT
+			Is(time.Time) dynamodb.Attribute
+		}
+		pk     dnm.IKeyFactory
+		byUser dnm.IIndex
+		store  dnm.IStore
+	)
+	define := func(hide bool) dnm.TTableDescription {
+		return dnm.Describe("ExpiringSessions", func(t dnm.ITable) {
+			sid = t.KeyAttr("Sid", dnm.String)
+			user = t.KeyAttr("User", dnm.String)
+			e := t.NonKeyAttr("ExpiresAt").AsTimeTime()
+			expires = &e
+			if ttl := t.TimeToLive(expires); hide {
+				ttl.HideExpired()
+			}
+			p := t.PrimaryKey()
+			p.Hash(sid)
+			pk = p.Factory()
+			g := t.GlobalIndex("UserIndex")
+			g.Hash(user)
+			g.Projection().All()
+			byUser = g
+		})
+	}
+	now := time.Now()
+
+	It("should reject illegal declarations", func() {
+		Expect(func() {
+			dnm.Describe("Invalid", func(t dnm.ITable) {
+				t.TimeToLive(t.NonKeyAttr("ExpiresAt", dnm.String))
+			})
+		}).To(Panic())
+		Expect(func() {
+			dnm.Describe("Invalid", func(t dnm.ITable) {
+				t.TimeToLive(t.NonKeyAttr("ExpiresAt", dnm.Number))
+				t.TimeToLive(t.NonKeyAttr("PurgeAt", dnm.Number))
+			})
+		}).To(Panic())
+	})
+
+	for _, hide := range []bool{true, false} {
+		hide := hide
+		It(fmt.Sprintf("should hide expired items only if asked to, hide: %v", hide), func() {
+			d := define(hide)
+			store = dnm.MakeMemStore(&d)
+			visible := !hide
+			Expect(store.Save(sid.Is("sid:1"), user.Is("uid:1"), expires.Is(now.Add(-time.Minute)))).To(BeNil())
+			Expect(store.Save(sid.Is("sid:2"), user.Is("uid:1"), expires.Is(now.Add(time.Hour)))).To(BeNil())
+			Expect(store.Save(sid.Is("sid:3"), user.Is("uid:1"))).To(BeNil())
+			Expect(store.Save(sid.Is("sid:4"), user.Is("uid:1"), expires.Is(time.Time{}))).To(BeNil())
+			Expect(store.Save(sid.Is("sid:5"), user.Is("uid:1"), expires.Is(now.Add(-6*365*24*time.Hour)))).To(BeNil())
+
+			key := pk.Key(sid.Is("sid:1"))
+			_, err := store.Get(&key)
+			Expect(err == nil).To(Equal(visible))
+			key = pk.Key(sid.Is("sid:2"))
+			Expect(store.Get(&key)).ToNot(BeNil())
+			key = pk.Key(sid.Is("sid:4"))
+			Expect(store.Get(&key)).ToNot(BeNil())
+
+			keys := []dynamodb.Key{pk.Key(sid.Is("sid:1")), pk.Key(sid.Is("sid:2"))}
+			_, errs := store.BatchGet(keys)
+			Expect(errs == nil).To(Equal(visible))
+			_, errs = dnm.MakeReadTransaction().Get(store, &keys[0]).Get(store, &keys[1]).Fetch()
+			Expect(errs == nil).To(Equal(visible))
+
+			items, err := store.Find(byUser.Query().Where(user.Equals("uid:1")))
+			Expect(err).To(BeNil())
+			count := 0
+			it := store.FindIter(byUser.Query().Where(user.Equals("uid:1")), 1, 0, "")
+			for it.Next() {
+				count++
+			}
+			Expect(it.Err()).To(BeNil())
+			if visible {
+				Expect(items).To(HaveLen(5))
+				Expect(count).To(Equal(5))
+			} else {
+				Expect(items).To(HaveLen(4))
+				Expect(count).To(Equal(4))
+			}
+		})
+	}
+
+	It("should hide expired items DynamoDB reads in batches and transactions", func() {
+		d := define(true)
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			item := fmt.Sprintf(`{"Sid":{"S":"sid:1"},"ExpiresAt":{"N":"%d"}}`, now.Add(-time.Minute).Unix())
+			if strings.HasSuffix(r.Header.Get("X-Amz-Target"), "BatchGetItem") {
+				fmt.Fprintf(w, `{"Responses":{"ExpiringSessions":[%s]}}`, item)
+			} else {
+				fmt.Fprintf(w, `{"Responses":[{"Item":%s}]}`, item)
+			}
+		}))
+		defer server.Close()
+		s, _ := dnm.MakeStore(&d, cfg)
+		key := pk.Key(sid.Is("sid:1"))
+		items, errs := s.BatchGet([]dynamodb.Key{key})
+		Expect(items[0]).To(BeNil())
+		Expect(errs[0].IsNotFound()).To(BeTrue())
+		items, errs = dnm.MakeReadTransaction().Get(s, &key).Fetch()
+		Expect(items[0]).To(BeNil())
+		Expect(errs[0].IsNotFound()).To(BeTrue())
+	})
+
+	It("should enable time to live on migration", func() {
+		desired := define(true)
+		fake := &tFakeSchemaServer{live: desired.TableDescriptionT}
+		fake.live.TableStatus = dnm.TableStatusActive
+		ttl := map[string]interface{}{"TimeToLiveStatus": dnm.TTLStatusDisabled}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			target := r.Header.Get("X-Amz-Target")
+			switch {
+			case strings.HasSuffix(target, "DescribeTimeToLive"):
+				json.NewEncoder(w).Encode(map[string]interface{}{"TimeToLiveDescription": ttl})
+			case strings.HasSuffix(target, "UpdateTimeToLive"):
+				var req struct {
+					TimeToLiveSpecification struct {
+						AttributeName string
+						Enabled       bool
+					}
+				}
+				json.NewDecoder(r.Body).Decode(&req)
+				Expect(req.TimeToLiveSpecification.Enabled).To(BeTrue())
+				ttl = map[string]interface{}{"TimeToLiveStatus": dnm.TTLStatusEnabling, "AttributeName": req.TimeToLiveSpecification.AttributeName}
+				w.Write([]byte("{}"))
+			default:
+				fake.ServeHTTP(w, r)
+			}
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&desired, cfg)
+		_, err := store.Migrate(dnm.TMigrateConfig{})
+		Expect(err).To(BeNil())
+		Expect(ttl["AttributeName"]).To(Equal("ExpiresAt"))
+		_, err = store.Migrate(dnm.TMigrateConfig{})
+		Expect(err).To(BeNil())
+
+		ttl = map[string]interface{}{"TimeToLiveStatus": dnm.TTLStatusEnabled, "AttributeName": "PurgeAt"}
+		_, err = store.Migrate(dnm.TMigrateConfig{})
+		Expect(err.Is(dnm.TTLErr)).To(BeTrue())
+	})
+})
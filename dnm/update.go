@@ -0,0 +1,450 @@
+package dnm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/flowhealth/commons/fhlog"
+	"github.com/flowhealth/goamz/dynamodb"
+	log "github.com/flowhealth/logrus"
+)
+
+/**
+Update expression builder, replaces parallel slices of
+UpdateExpressionAttribute and actions with a single validated update.
+
+	update := dnm.MakeUpdate().
+		Set(Status.Is("published")).
+		SetIfNotExists(Created.Is(now)).
+		Add(Views.Is("1")).
+		Remove(LockedBy).
+		Append(History, Event.Is("published")).
+		Delete(Tags.Is("draft"))
+	item, err := store.UpdateWithCondition(&key, update, nil, dnm.ReturnValuesAllNew)
+
+Every attribute can be updated by one action only, DynamoDB rejects
+overlapping paths. Attributes nested in documents are updated by paths, see
+document.go, Add and Delete support top level attributes only.
+*/
+
+const (
+	ReturnValuesNone       = "NONE"
+	ReturnValuesAllOld     = "ALL_OLD"
+	ReturnValuesUpdatedOld = "UPDATED_OLD"
+	ReturnValuesAllNew     = "ALL_NEW"
+	ReturnValuesUpdatedNew = "UPDATED_NEW"
+)
+
+const (
+	updateSet            = "SET"
+	updateSetIfNotExists = "SET_IF_NOT_EXISTS"
+	updateAppend         = "APPEND"
+	updateCounter        = "COUNTER"
+	updateAdd            = "ADD"
+	updateRemove         = "REMOVE"
+	updateDelete         = "DELETE"
+)
+
+type tUpdateAction struct {
+	kind  string
+	name  string
+	value *dynamodb.Attribute
+	// value of missing counter the delta is added to
+	initial *dynamodb.Attribute
+}
+
+type TUpdate struct {
+	actions []tUpdateAction
+}
+
+func MakeUpdate() *TUpdate {
+	return &TUpdate{}
+}
+
+// Set replaces attribute values
+func (self *TUpdate) Set(attrs ...dynamodb.Attribute) *TUpdate {
+	for n := range attrs {
+		self.add(updateSet, attrs[n].Name, &attrs[n])
+	}
+	return self
+}
+
+// SetIfNotExists sets attributes the item doesn't have yet
+func (self *TUpdate) SetIfNotExists(attrs ...dynamodb.Attribute) *TUpdate {
+	for n := range attrs {
+		self.add(updateSetIfNotExists, attrs[n].Name, &attrs[n])
+	}
+	return self
+}
+
+// Add increments number attributes and adds elements to set attributes,
+// missing attributes are created
+func (self *TUpdate) Add(attrs ...dynamodb.Attribute) *TUpdate {
+	for n := range attrs {
+		if attrs[n].Type != dynamodb.TYPE_NUMBER && !isSetType(attrs[n].Type) {
+			panic(fmt.Sprintf("Illegal update, can't add %s value to %s", attrs[n].Type, attrs[n].Name))
+		}
+		if nestedPath(attrs[n].Name) != nil {
+			panic(fmt.Sprintf("Illegal update, can't add to nested attribute %s", attrs[n].Name))
+		}
+		self.add(updateAdd, attrs[n].Name, &attrs[n])
+	}
+	return self
+}
+
+func (self *TUpdate) Remove(attrs ...AttributeDefinitionProvider) *TUpdate {
+	for _, v := range attrs {
+		self.add(updateRemove, v.Def().Name, nil)
+	}
+	return self
+}
+
+// Append adds values to the end of a list attribute, missing list is created
+func (self *TUpdate) Append(attr AttributeDefinitionProvider, vals ...dynamodb.Attribute) *TUpdate {
+	if len(vals) == 0 {
+		panic("Invalid empty set of values")
+	}
+	list := makeListAttr(attr.Def().Name, vals)
+	self.add(updateAppend, list.Name, list)
+	return self
+}
+
+// Delete removes elements from set attributes
+func (self *TUpdate) Delete(attrs ...dynamodb.Attribute) *TUpdate {
+	for n := range attrs {
+		if !isSetType(attrs[n].Type) {
+			panic(fmt.Sprintf("Illegal update, can't delete from %s attribute %s", attrs[n].Type, attrs[n].Name))
+		}
+		if nestedPath(attrs[n].Name) != nil {
+			panic(fmt.Sprintf("Illegal update, can't delete from nested attribute %s", attrs[n].Name))
+		}
+		self.add(updateDelete, attrs[n].Name, &attrs[n])
+	}
+	return self
+}
+
+// AddToSet adds elements to set attributes, unlike Add it accepts sets only
+func (self *TUpdate) AddToSet(attrs ...dynamodb.Attribute) *TUpdate {
+	checkSets(attrs)
+	return self.Add(attrs...)
+}
+
+// RemoveFromSet removes elements from set attributes, the attribute is
+// removed along with its last element
+func (self *TUpdate) RemoveFromSet(attrs ...dynamodb.Attribute) *TUpdate {
+	checkSets(attrs)
+	return self.Delete(attrs...)
+}
+
+func checkSets(attrs []dynamodb.Attribute) {
+	for n := range attrs {
+		if !isSetType(attrs[n].Type) {
+			panic(fmt.Sprintf("Illegal update, %s attribute %s is not a set", attrs[n].Type, attrs[n].Name))
+		}
+		if len(attrs[n].SetValues) == 0 {
+			panic(fmt.Sprintf("Illegal update, empty set of values for %s", attrs[n].Name))
+		}
+	}
+}
+
+func (self *TUpdate) add(kind, name string, value *dynamodb.Attribute) {
+	for _, v := range self.actions {
+		if v.name == name {
+			panic(fmt.Sprintf("Illegal update, attribute %s is updated twice", name))
+		}
+		if pathsOverlap(v.name, name) {
+			panic(fmt.Sprintf("Illegal update, paths %s and %s overlap", v.name, name))
+		}
+	}
+	self.actions = append(self.actions, tUpdateAction{kind: kind, name: name, value: value})
+}
+
+// counter adds delta to a number attribute, unlike Add it starts missing
+// attribute from initial rather than zero
+func (self *TUpdate) counter(name string, delta, initial int64) *TUpdate {
+	self.add(updateCounter, name, &dynamodb.Attribute{Type: Number, Name: name, Value: strconv.FormatInt(delta, 10)})
+	self.actions[len(self.actions)-1].initial = &dynamodb.Attribute{Type: Number, Name: name, Value: strconv.FormatInt(initial, 10)}
+	return self
+}
+
+// idempotent reports whether applying the update twice leaves the item as
+// applying it once, counters, adding to numbers and appending to lists don't
+func (self *TUpdate) idempotent() bool {
+	for _, v := range self.actions {
+		if v.kind == updateAppend || v.kind == updateCounter || (v.kind == updateAdd && v.value.Type == Number) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks the update against the table, key attributes can't be updated
+func (self *TUpdate) validate(desc *dynamodb.TableDescriptionT) error {
+	if self == nil || len(self.actions) == 0 {
+		return fmt.Errorf("update has no actions")
+	}
+	for _, v := range self.actions {
+		for _, k := range desc.KeySchema {
+			if pathRoot(v.name) == k.AttributeName {
+				return fmt.Errorf("cannot update attribute %s, this attribute is part of the key", v.name)
+			}
+		}
+	}
+	return nil
+}
+
+// expression renders actions grouped by clause, e.g. SET #n0 = :v0 REMOVE #n1
+func (self *TUpdate) expression(ctx *tExprContext) string {
+	clauses := map[string][]string{}
+	for n := range self.actions {
+		v := &self.actions[n]
+		name := ctx.path(v.name)
+		switch v.kind {
+		case updateSet:
+			clauses["SET"] = append(clauses["SET"], fmt.Sprintf("%s = %s", name, ctx.value(v.value)))
+		case updateSetIfNotExists:
+			clauses["SET"] = append(clauses["SET"], fmt.Sprintf("%s = if_not_exists(%s, %s)", name, name, ctx.value(v.value)))
+		case updateAppend:
+			empty := makeListAttr(v.name, nil)
+			clauses["SET"] = append(clauses["SET"], fmt.Sprintf("%s = list_append(if_not_exists(%s, %s), %s)",
+				name, name, ctx.value(empty), ctx.value(v.value)))
+		case updateCounter:
+			clauses["SET"] = append(clauses["SET"], fmt.Sprintf("%s = if_not_exists(%s, %s) + %s",
+				name, name, ctx.value(v.initial), ctx.value(v.value)))
+		case updateAdd:
+			clauses["ADD"] = append(clauses["ADD"], fmt.Sprintf("%s %s", name, ctx.value(v.value)))
+		case updateRemove:
+			clauses["REMOVE"] = append(clauses["REMOVE"], name)
+		case updateDelete:
+			clauses["DELETE"] = append(clauses["DELETE"], fmt.Sprintf("%s %s", name, ctx.value(v.value)))
+		}
+	}
+	res := []string{}
+	for _, clause := range []string{"SET", "ADD", "REMOVE", "DELETE"} {
+		if len(clauses[clause]) > 0 {
+			res = append(res, clause+" "+strings.Join(clauses[clause], ", "))
+		}
+	}
+	return strings.Join(res, " ")
+}
+
+// apply evaluates the update the way DynamoDB does, item is nil if it doesn't exist
+func (self *TUpdate) apply(item map[string]*dynamodb.Attribute) (map[string]*dynamodb.Attribute, error) {
+	res := copyItem(item)
+	for n := range self.actions {
+		v := &self.actions[n]
+		attr, exists := lookupPath(res, v.name)
+		var err error
+		switch v.kind {
+		case updateSet:
+			err = setPath(res, v.name, v.value)
+		case updateSetIfNotExists:
+			if !exists {
+				err = setPath(res, v.name, v.value)
+			}
+		case updateAppend:
+			var list *dynamodb.Attribute
+			if list, err = appendList(attr, v.value); err == nil {
+				err = setPath(res, v.name, list)
+			}
+		case updateCounter:
+			if !exists {
+				attr = v.initial
+			}
+			var sum *dynamodb.Attribute
+			if sum, err = addAttrs(attr, v.value); err == nil {
+				err = setPath(res, v.name, sum)
+			}
+		case updateAdd:
+			sum, err := addAttrs(attr, v.value)
+			if err != nil {
+				return nil, err
+			}
+			res[v.name] = sum
+		case updateRemove:
+			err = removePath(res, v.name)
+		case updateDelete:
+			if !exists {
+				continue
+			}
+			rest, err := deleteFromSet(attr, v.value)
+			if err != nil {
+				return nil, err
+			}
+			if rest == nil {
+				delete(res, v.name)
+			} else {
+				res[v.name] = rest
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// returnValues picks attributes of the old or the new item as DynamoDB does
+func (self *TUpdate) returnValues(returnValues string, oldItem, newItem map[string]*dynamodb.Attribute) map[string]*dynamodb.Attribute {
+	var item map[string]*dynamodb.Attribute
+	switch returnValues {
+	case ReturnValuesAllOld, ReturnValuesUpdatedOld:
+		item = oldItem
+	case ReturnValuesAllNew, ReturnValuesUpdatedNew:
+		item = newItem
+	default:
+		return nil
+	}
+	if item == nil {
+		return nil
+	}
+	if returnValues == ReturnValuesAllOld || returnValues == ReturnValuesAllNew {
+		return copyItem(item)
+	}
+	res := map[string]*dynamodb.Attribute{}
+	for _, v := range self.actions {
+		name := v.name
+		if nestedPath(name) != nil {
+			// nested values are returned within their documents
+			name = pathRoot(name)
+		}
+		if attr, ok := item[name]; ok {
+			res[name] = copyAttr(attr)
+		}
+	}
+	return res
+}
+
+func appendList(attr, vals *dynamodb.Attribute) (*dynamodb.Attribute, error) {
+	if attr == nil {
+		return copyAttr(vals), nil
+	}
+	if attr.Type != List {
+		return nil, fmt.Errorf("cannot append to %s attribute %s", attr.Type, attr.Name)
+	}
+	var list, tail []json.RawMessage
+	if err := json.Unmarshal([]byte(attr.Value), &list); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(vals.Value), &tail); err != nil {
+		return nil, err
+	}
+	data, _ := json.Marshal(append(list, tail...))
+	return &dynamodb.Attribute{Type: List, Name: attr.Name, Value: string(data)}, nil
+}
+
+// deleteFromSet returns nil when no elements are left, empty sets are removed
+func deleteFromSet(attr, vals *dynamodb.Attribute) (*dynamodb.Attribute, error) {
+	if attr.Type != vals.Type {
+		return nil, fmt.Errorf("cannot delete %s value from %s attribute %s", vals.Type, attr.Type, attr.Name)
+	}
+	res := copyAttr(attr)
+	res.SetValues = []string{}
+	for _, v := range attr.SetValues {
+		if !setContains(attr.Type, vals.SetValues, v) {
+			res.SetValues = append(res.SetValues, v)
+		}
+	}
+	if len(res.SetValues) == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+/**
+Store implementations
+*/
+
+func (self *TStore) UpdateWithCondition(key *dynamodb.Key, update *TUpdate, cond ICondition,
+	returnValues string) (map[string]*dynamodb.Attribute, *TError) {
+
+	if err := update.validate(self.tableDesc); err != nil {
+		return nil, self.makeError(UpdateErr, "UpdateWithCondition", err)
+	}
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		versioned, current, err := self.opts.versionExpression(update)
+		if err != nil {
+			return nil, self.makeError(UpdateErr, "UpdateWithCondition", err)
+		}
+		update, check = versioned, current
+	}
+	ctx := makeExprContext()
+	req := map[string]interface{}{
+		"TableName":        self.tableDesc.TableName,
+		"Key":              toWireItem(tableKeyItem(self.tableDesc, key)),
+		"UpdateExpression": update.expression(ctx),
+	}
+	if conds := withVersionCondition(cond, check); conds != nil {
+		req["ConditionExpression"] = conds.expression(ctx)
+	}
+	if returnValues != "" {
+		req["ReturnValues"] = returnValues
+	}
+	ctx.apply(req)
+	var resp struct {
+		Attributes tWireItem
+	}
+	if err := self.rpcWrite("UpdateItem", cond == nil && check == nil && update.idempotent(), req, &resp); err != nil {
+		if errorCode(err) == ErrCodeConditional {
+			return nil, self.conditionalError("UpdateWithCondition", key, check, cond != nil, err)
+		}
+		log.WithFields(log.Fields{
+			LogKey:          key,
+			LogAttributes:   req["UpdateExpression"],
+			LogCondition:    req["ConditionExpression"],
+			LogReturnValues: returnValues,
+			LogTable:        self.tableDesc.TableName,
+			fhlog.FHError:   err.Error(),
+		}).Error("Error in UpdateWithCondition()")
+		return nil, self.makeError(UpdateErr, "UpdateWithCondition", err)
+	}
+	if len(resp.Attributes) == 0 {
+		return nil, nil
+	}
+	return fromWireItem(resp.Attributes), nil
+}
+
+func (self *TMemStore) UpdateWithCondition(key *dynamodb.Key, update *TUpdate, cond ICondition,
+	returnValues string) (map[string]*dynamodb.Attribute, *TError) {
+
+	if err := update.validate(self.tableDesc); err != nil {
+		return nil, self.makeError(UpdateErr, "UpdateWithCondition", err)
+	}
+	var check *dynamodb.Attribute
+	if self.opts.versioned() {
+		versioned, current, err := self.opts.versionExpression(update)
+		if err != nil {
+			return nil, self.makeError(UpdateErr, "UpdateWithCondition", err)
+		}
+		update, check = versioned, current
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	mk := self.memKey(key)
+	existing := self.items[mk]
+	if conds := withVersionCondition(cond, check); conds != nil && !conds.match(existing) {
+		return nil, self.conditionalError("UpdateWithCondition", existing, check, fmt.Errorf("item doesn't match condition"))
+	}
+	base := existing
+	if base == nil {
+		base = self.keyItem(key)
+	}
+	item, err := update.apply(base)
+	if err != nil {
+		return nil, self.makeError(UpdateErr, "UpdateWithCondition", err)
+	}
+	self.items[mk] = item
+	return update.returnValues(returnValues, existing, item), nil
+}
+
+func (self *tContextStore) UpdateWithCondition(key *dynamodb.Key, update *TUpdate, cond ICondition,
+	returnValues string) (map[string]*dynamodb.Attribute, *TError) {
+
+	if err := self.cancelled("UpdateWithCondition"); err != nil {
+		return nil, err
+	}
+	return self.store.UpdateWithCondition(key, update, cond, returnValues)
+}
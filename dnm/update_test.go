@@ -0,0 +1,109 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Update expressions", func() {
+	var (
+		id, status, views, lockedBy, history, tags, created dnm.IAttr
+		pk                                                  dnm.IKeyFactory
+		store                                               dnm.IStore
+	)
+	d := dnm.Describe("Documents", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		status = t.NonKeyAttr("Status", dnm.String)
+		views = t.NonKeyAttr("Views", dnm.Number)
+		lockedBy = t.NonKeyAttr("LockedBy", dnm.String)
+		history = t.NonKeyAttr("History", dnm.List)
+		tags = t.NonKeyAttr("Tags", dynamodb.TYPE_STRING_SET)
+		created = t.NonKeyAttr("Created", dnm.Number)
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+	})
+	key := pk.Key(id.Is("doc:1"))
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+		Expect(store.Save(id.Is("doc:1"), status.Is("draft"), views.Is("1"), lockedBy.Is("uid:1"), tags.Is("a", "draft"), created.Is("10"))).To(BeNil())
+	})
+
+	update := func() *dnm.TUpdate {
+		return dnm.MakeUpdate().
+			Set(status.Is("published")).
+			SetIfNotExists(created.Is("20")).
+			Add(views.Is("2")).
+			Remove(lockedBy).
+			Append(history, status.Is("published")).
+			Delete(tags.Is("draft"))
+	}
+
+	It("should reject illegal updates", func() {
+		Expect(func() { dnm.MakeUpdate().Set(status.Is("a")).Remove(status) }).To(Panic())
+		Expect(func() { dnm.MakeUpdate().Add(status.Is("a")) }).To(Panic())
+		Expect(func() { dnm.MakeUpdate().Delete(status.Is("a")) }).To(Panic())
+
+		_, err := store.UpdateWithCondition(&key, dnm.MakeUpdate().Set(id.Is("doc:2")), nil, "")
+		Expect(err.Is(dnm.UpdateErr)).To(BeTrue())
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate(), nil, "")
+		Expect(err.Is(dnm.UpdateErr)).To(BeTrue())
+	})
+
+	It("should apply actions in memory", func() {
+		item, err := store.UpdateWithCondition(&key, update(), nil, dnm.ReturnValuesAllNew)
+		Expect(err).To(BeNil())
+		Expect(status.From(item)).To(Equal("published"))
+		Expect(created.From(item)).To(Equal("10"))
+		Expect(views.From(item)).To(Equal("3"))
+		Expect(item).ToNot(HaveKey("LockedBy"))
+		Expect(item["Tags"].SetValues).To(Equal([]string{"a"}))
+		Expect(item["History"].Value).To(Equal(`[{"S":"published"}]`))
+
+		item, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Append(history, status.Is("archived")).Delete(tags.Is("a")),
+			dnm.Compare(status.Equals("published")), dnm.ReturnValuesUpdatedNew)
+		Expect(err).To(BeNil())
+		Expect(item).To(HaveLen(1))
+		Expect(item["History"].Value).To(Equal(`[{"S":"published"},{"S":"archived"}]`))
+
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Set(status.Is("draft")), dnm.Compare(status.Equals("draft")), "")
+		Expect(err.IsConditional()).To(BeTrue())
+	})
+
+	It("should create missing items", func() {
+		missing := pk.Key(id.Is("doc:2"))
+		item, err := store.UpdateWithCondition(&missing, dnm.MakeUpdate().Add(views.Is("1")), nil, dnm.ReturnValuesAllOld)
+		Expect(err).To(BeNil())
+		Expect(item).To(BeNil())
+		item, _ = store.Get(&missing)
+		Expect(views.From(item)).To(Equal("1"))
+		Expect(id.From(item)).To(Equal("doc:2"))
+	})
+
+	It("should send a single UpdateItem request", func() {
+		var req map[string]interface{}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&req)
+			fmt.Fprint(w, `{"Attributes":{"Views":{"N":"3"}}}`)
+		}))
+		defer server.Close()
+		store, _ := dnm.MakeStore(&d, cfg)
+		item, err := store.UpdateWithCondition(&key, update(), dnm.AttributeExists(id), dnm.ReturnValuesUpdatedNew)
+		Expect(err).To(BeNil())
+		Expect(views.From(item)).To(Equal("3"))
+		Expect(req["UpdateExpression"]).To(Equal("SET #n0 = :v0, #n1 = if_not_exists(#n1, :v1), #n4 = list_append(if_not_exists(#n4, :v3), :v4) " +
+			"ADD #n2 :v2 REMOVE #n3 DELETE #n5 :v5"))
+		Expect(req["ConditionExpression"]).To(Equal("attribute_exists(#n6)"))
+		Expect(req["ReturnValues"]).To(Equal(dnm.ReturnValuesUpdatedNew))
+		values := req["ExpressionAttributeValues"].(map[string]interface{})
+		Expect(values[":v3"]).To(Equal(map[string]interface{}{"L": []interface{}{}}))
+		Expect(values[":v5"]).To(Equal(map[string]interface{}{"SS": []interface{}{"draft"}}))
+	})
+})
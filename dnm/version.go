@@ -0,0 +1,218 @@
+package dnm
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Optimistic locking. Items of a table declared with VersionAttr carry a number
+which is incremented by every write, and writes are conditioned on the version
+the item was read with:
+ - Save of an item without version, or with version 0, creates it with
+   version 1 and fails if the item already exists
+ - Save and Update of an item with version succeed only if the stored version
+   is still the same
+ - Delete requires the version among the expected attributes, see DeleteVersioned
+A failed version check is reported as VersionConflictErr instead of
+ConditionalErr, failures of the expected attributes of the caller remain
+ConditionalErr.
+
+SaveWithCondition and UpdateWithCondition add the same version check to the
+condition of the caller, an update carries the read version as Set of the
+version attribute. Writes which can't carry the read version would bypass
+the check, they fail with NotSupportedErr on versioned tables: DeleteWithCondition,
+counters, batch writes, transactional writes and writes by raw update or
+condition expressions.
+*/
+
+func (self *tTableOptions) versioned() bool {
+	return self.version != ""
+}
+
+// nextVersion replaces version of attrs with the incremented one, current is
+// nil when attrs have no version, zero version is the one of a new item
+func (self *tTableOptions) nextVersion(attrs []dynamodb.Attribute) (next []dynamodb.Attribute, current *dynamodb.Attribute, err error) {
+	next = make([]dynamodb.Attribute, 0, len(attrs)+1)
+	for _, v := range attrs {
+		if v.Name != self.version {
+			next = append(next, v)
+			continue
+		}
+		if v.Value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed version %s of attribute %s", v.Value, v.Name)
+		}
+		if n == 0 {
+			continue
+		}
+		read := v
+		current = &read
+		v.Value = strconv.FormatInt(n+1, 10)
+		next = append(next, v)
+	}
+	if current == nil {
+		next = append(next, dynamodb.Attribute{Type: Number, Name: self.version, Value: "1"})
+	}
+	return next, current, nil
+}
+
+// versionSave returns the version check put of the item is conditioned on,
+// its version or its absence when the item is new
+func (self *tTableOptions) versionSave(hashName string, attrs []dynamodb.Attribute) ([]dynamodb.Attribute, *dynamodb.Attribute, error) {
+	next, current, err := self.nextVersion(attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if current == nil {
+		return next, &dynamodb.Attribute{Name: hashName, Exists: "false"}, nil
+	}
+	return next, current, nil
+}
+
+func (self *tTableOptions) versionUpdate(attrs []dynamodb.Attribute) ([]dynamodb.Attribute, *dynamodb.Attribute, error) {
+	next, current, err := self.nextVersion(attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if current == nil {
+		return nil, nil, fmt.Errorf("version attribute %s is required", self.version)
+	}
+	return next, current, nil
+}
+
+// versionDelete splits expected into the version check and the rest
+func (self *tTableOptions) versionDelete(expected []dynamodb.Attribute) (*dynamodb.Attribute, []dynamodb.Attribute, error) {
+	for n, v := range expected {
+		if v.Name == self.version && v.Value != "" {
+			rest := append(append([]dynamodb.Attribute{}, expected[:n]...), expected[n+1:]...)
+			return &v, rest, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("version attribute %s is required", self.version)
+}
+
+// versionExpression replaces the read version the update sets with the next
+// one and returns the check of the read version. An update without version,
+// or with version 0, is the one of a new item.
+func (self *tTableOptions) versionExpression(update *TUpdate) (*TUpdate, *dynamodb.Attribute, error) {
+	next := &TUpdate{}
+	read := []dynamodb.Attribute{}
+	for _, v := range update.actions {
+		switch {
+		case pathRoot(v.name) != self.version:
+			next.actions = append(next.actions, v)
+		case v.kind == updateSet && v.name == self.version:
+			read = append(read, *v.value)
+		default:
+			return nil, nil, fmt.Errorf("version attribute %s can only be set to the read version", self.version)
+		}
+	}
+	attrs, current, err := self.nextVersion(read)
+	if err != nil {
+		return nil, nil, err
+	}
+	if current == nil {
+		current = &dynamodb.Attribute{Name: self.version, Exists: "false"}
+	}
+	return next.Set(attrs...), current, nil
+}
+
+// unversioned fails for writes which can't maintain the version
+func (self *tTableOptions) unversioned() error {
+	if self.versioned() {
+		return fmt.Errorf("write would bypass version attribute %s", self.version)
+	}
+	return nil
+}
+
+// withVersion adds the version check to expected attributes of the caller
+func withVersion(expected []dynamodb.Attribute, check *dynamodb.Attribute) []dynamodb.Attribute {
+	if check == nil {
+		return expected
+	}
+	res := make([]dynamodb.Attribute, 0, len(expected)+1)
+	return append(append(res, expected...), *check)
+}
+
+// withVersionCondition adds the version check to the condition of the caller
+func withVersionCondition(cond ICondition, check *dynamodb.Attribute) ICondition {
+	if check == nil {
+		return cond
+	}
+	var version ICondition
+	if check.Exists == "false" {
+		version = &tFuncCond{fn: "attribute_not_exists", name: check.Name}
+	} else {
+		version = &tCompareCond{dynamodb.AttributeComparison{check.Name, dynamodb.COMPARISON_EQUAL, []dynamodb.Attribute{*check}}}
+	}
+	if cond == nil {
+		return version
+	}
+	return And(cond, version)
+}
+
+// makeVersionConflict keeps the code of VersionConflictErr, otherwise it would
+// be taken from the underlying conditional check failure
+func makeVersionConflict(table, op string, details error) *TError {
+	err := makeOpError(VersionConflictErr, table, op, details)
+	err.Code = ErrCodeVersionConflict
+	return err
+}
+
+// storeOptions returns options of the table the store was made for
+func storeOptions(store IStore) *tTableOptions {
+	switch store := store.(type) {
+	case *TStore:
+		return store.opts
+	case *TMemStore:
+		return store.opts
+	case *tContextStore:
+		return storeOptions(store.store)
+	}
+	return &tTableOptions{}
+}
+
+// conditionalError reports the failed write as VersionConflictErr only if its
+// version check failed. When the caller had conditions of its own the item is
+// read again to tell which of them failed.
+func (self *TStore) conditionalError(op string, key *dynamodb.Key, check *dynamodb.Attribute, callerConds bool, details error) *TError {
+	if check == nil {
+		return self.makeError(ConditionalErr, op, details)
+	}
+	if callerConds {
+		item, err := self.readItem(key, true)
+		if err != nil || matchExpected(item, []dynamodb.Attribute{*check}) {
+			return self.makeError(ConditionalErr, op, details)
+		}
+	}
+	return makeVersionConflict(self.table.Name, op, details)
+}
+
+func (self *TMemStore) conditionalError(op string, item map[string]*dynamodb.Attribute, check *dynamodb.Attribute, details error) *TError {
+	if check != nil && !matchExpected(item, []dynamodb.Attribute{*check}) {
+		return makeVersionConflict(self.tableDesc.TableName, op, details)
+	}
+	return self.makeError(ConditionalErr, op, details)
+}
+
+// DeleteVersioned deletes the item only if it wasn't modified since it was
+// read with the given version
+func (self *TStore) DeleteVersioned(key *dynamodb.Key, version string) *TError {
+	if !self.opts.versioned() {
+		return self.makeError(DeleteErr, "DeleteVersioned", fmt.Errorf("table has no version attribute"))
+	}
+	return self.DeleteConditional(key, []dynamodb.Attribute{{Type: Number, Name: self.opts.version, Value: version}})
+}
+
+func (self *TMemStore) DeleteVersioned(key *dynamodb.Key, version string) *TError {
+	if !self.opts.versioned() {
+		return self.makeError(DeleteErr, "DeleteVersioned", fmt.Errorf("table has no version attribute"))
+	}
+	return self.DeleteConditional(key, []dynamodb.Attribute{{Type: Number, Name: self.opts.version, Value: version}})
+}
@@ -0,0 +1,217 @@
+package dnm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/flowhealth/goamz/dynamodb"
+	"github.com/flowhealth/godnm/dnm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type tVersionedNote struct {
+	Id      string `dnm:"Id,hash"`
+	Text    string `dnm:"Text"`
+	Version int64  `dnm:"Version,version"`
+}
+
+var _ = Describe("Optimistic locking", func() {
+	var (
+		id, text, version dnm.IAttr
+		views             dnm.IAttr
+		pk                dnm.IKeyFactory
+		store             dnm.IStore
+	)
+	d := dnm.Describe("VersionedDocuments", func(t dnm.ITable) {
+		id = t.KeyAttr("Id", dnm.String)
+		text = t.NonKeyAttr("Text", dnm.String)
+		views = t.NonKeyAttr("Views", dnm.Number)
+		version = t.VersionAttr("Version")
+		p := t.PrimaryKey()
+		p.Hash(id)
+		pk = p.Factory()
+	})
+	key := pk.Key(id.Is("doc:1"))
+
+	BeforeEach(func() {
+		store = dnm.MakeMemStore(&d)
+		Expect(store.Save(id.Is("doc:1"), text.Is("draft"))).To(BeNil())
+	})
+
+	It("should reject duplicate version attr", func() {
+		Expect(func() {
+			dnm.Describe("Invalid", func(t dnm.ITable) {
+				t.VersionAttr("Version")
+				t.VersionAttr("Revision")
+			})
+		}).To(Panic())
+	})
+
+	It("should keep options with the description", func() {
+		dnm.Describe("VersionedDocuments", func(t dnm.ITable) {
+			t.PrimaryKey().Hash(t.KeyAttr("Id", dnm.String))
+		})
+		s := dnm.MakeMemStore(&d)
+		Expect(s.Save(id.Is("doc:2"))).To(BeNil())
+		Expect(s.Save(id.Is("doc:2")).IsVersionConflict()).To(BeTrue())
+	})
+
+	It("should create new items with the first version", func() {
+		item, _ := store.Get(&key)
+		Expect(version.From(item)).To(Equal("1"))
+
+		err := store.Save(id.Is("doc:1"), text.Is("other"))
+		Expect(err.IsVersionConflict()).To(BeTrue())
+		Expect(err.IsConditional()).To(BeFalse())
+		Expect(err.Is(dnm.VersionConflictErr)).To(BeTrue())
+		Expect(err.Is(dnm.ConditionalErr)).To(BeFalse())
+	})
+
+	It("should increment version of every write", func() {
+		item, _ := store.Get(&key)
+		Expect(store.Save(id.Is("doc:1"), text.Is("published"), version.Is(version.From(item)))).To(BeNil())
+		Expect(store.Update(&key, text.Is("archived"), version.Is("2"))).To(BeNil())
+		item, _ = store.Get(&key)
+		Expect(text.From(item)).To(Equal("archived"))
+		Expect(version.From(item)).To(Equal("3"))
+
+		Expect(store.Update(&key, text.Is("stale"), version.Is("2")).IsVersionConflict()).To(BeTrue())
+		Expect(store.Save(id.Is("doc:1"), version.Is("1")).IsVersionConflict()).To(BeTrue())
+		Expect(store.Update(&key, text.Is("blind")).Is(dnm.UpdateErr)).To(BeTrue())
+		Expect(store.Update(&key, version.Is("x")).Is(dnm.UpdateErr)).To(BeTrue())
+	})
+
+	It("should delete only the version that was read", func() {
+		Expect(store.Delete(&key).Is(dnm.DeleteErr)).To(BeTrue())
+		Expect(store.DeleteVersioned(&key, "2").IsVersionConflict()).To(BeTrue())
+		Expect(store.DeleteVersioned(&key, "1")).To(BeNil())
+		_, err := store.Get(&key)
+		Expect(err.IsNotFound()).To(BeTrue())
+	})
+
+	It("should check the read version of expression writes", func() {
+		published := dnm.MakeUpdate().Set(text.Is("published"), version.Is("1"))
+		item, err := store.UpdateWithCondition(&key, published, dnm.Compare(text.Equals("draft")), dnm.ReturnValuesAllNew)
+		Expect(err).To(BeNil())
+		Expect(version.From(item)).To(Equal("2"))
+		Expect(store.SaveWithCondition([]dynamodb.Attribute{id.Is("doc:1"), text.Is("new"), version.Is("2")}, nil)).To(BeNil())
+
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Set(text.Is("stale"), version.Is("2")), nil, "")
+		Expect(err.IsVersionConflict()).To(BeTrue())
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Set(text.Is("blind")), nil, "")
+		Expect(err.IsVersionConflict()).To(BeTrue())
+		Expect(store.SaveWithCondition([]dynamodb.Attribute{id.Is("doc:1"), text.Is("stale"), version.Is("2")}, nil).IsVersionConflict()).To(BeTrue())
+		Expect(store.SaveWithCondition([]dynamodb.Attribute{id.Is("doc:1"), text.Is("blind")}, nil).IsVersionConflict()).To(BeTrue())
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Set(text.Is("x"), version.Is("3")), dnm.Compare(text.Equals("draft")), "")
+		Expect(err.IsConditional()).To(BeTrue())
+		item, _ = store.Get(&key)
+		Expect(text.From(item)).To(Equal("new"))
+		Expect(version.From(item)).To(Equal("3"))
+
+		_, err = store.UpdateWithCondition(&key, dnm.MakeUpdate().Add(version.Is("1")), nil, "")
+		Expect(err.Is(dnm.UpdateErr)).To(BeTrue())
+		other := pk.Key(id.Is("doc:2"))
+		item, _ = store.UpdateWithCondition(&other, dnm.MakeUpdate().Set(text.Is("new")), nil, dnm.ReturnValuesAllNew)
+		Expect(version.From(item)).To(Equal("1"))
+	})
+
+	It("should send the version check of expression writes", func() {
+		var req struct {
+			ConditionExpression       string
+			ExpressionAttributeValues map[string]interface{}
+		}
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&req)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"failed"}`)
+		}))
+		defer server.Close()
+		s, _ := dnm.MakeStore(&d, cfg)
+		_, err := s.UpdateWithCondition(&key, dnm.MakeUpdate().Set(text.Is("stale"), version.Is("2")), nil, "")
+		Expect(err.IsVersionConflict()).To(BeTrue())
+		Expect(req.ConditionExpression).To(Equal("#n1 = :v2"))
+		Expect(req.ExpressionAttributeValues[":v2"]).To(Equal(map[string]interface{}{"N": "2"}))
+
+		err = s.SaveWithCondition([]dynamodb.Attribute{id.Is("doc:1"), text.Is("new")}, dnm.Compare(text.Equals("draft")))
+		Expect(err).ToNot(BeNil())
+		Expect(req.ConditionExpression).To(Equal("(#n0 = :v0) AND (attribute_not_exists(#n1))"))
+	})
+
+	It("should reject writes bypassing the version", func() {
+		errs := store.BatchWrite(dnm.TWriteRequest{Put: []dynamodb.Attribute{id.Is("doc:1"), text.Is("batch")}})
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Is(dnm.NotSupportedErr)).To(BeTrue())
+		err := dnm.MakeWriteTransaction().Put(store, id.Is("doc:1"), text.Is("tx")).Commit()
+		Expect(err.Is(dnm.NotSupportedErr)).To(BeTrue())
+		Expect(dnm.MakeWriteTransaction().ConditionCheck(store, &key, []dynamodb.Attribute{version.Is("1")}).Commit()).To(BeNil())
+		item, _ := store.Get(&key)
+		Expect(text.From(item)).To(Equal("draft"))
+		Expect(store.DeleteWithCondition(&key, dnm.Compare(version.Equals("1"))).Is(dnm.NotSupportedErr)).To(BeTrue())
+		_, err = store.Increment(&key, views, 1, nil)
+		Expect(err.Is(dnm.NotSupportedErr)).To(BeTrue())
+
+		requests := 0
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			fmt.Fprint(w, "{}")
+		}))
+		defer server.Close()
+		s, _ := dnm.MakeStore(&d, cfg)
+		_, err = s.(*dnm.TStore).UpdateWithUpdateExpression(&key, "", dynamodb.UpdateExpressionAttribute{})
+		Expect(err.Is(dnm.NotSupportedErr)).To(BeTrue())
+		Expect(s.BatchWrite(dnm.MakeDeleteRequest(&key))[0].Is(dnm.NotSupportedErr)).To(BeTrue())
+		Expect(requests).To(BeZero())
+	})
+
+	It("should tell failed conditions of the caller from version conflicts", func() {
+		other := []dynamodb.Attribute{text.Is("other")}
+		err := store.SaveConditional([]dynamodb.Attribute{id.Is("doc:1"), version.Is("1")}, other)
+		Expect(err.IsConditional()).To(BeTrue())
+		Expect(store.UpdateConditional(&key, []dynamodb.Attribute{text.Is("x"), version.Is("1")}, other).IsConditional()).To(BeTrue())
+		Expect(store.DeleteConditional(&key, []dynamodb.Attribute{version.Is("1"), text.Is("other")}).IsConditional()).To(BeTrue())
+		Expect(store.UpdateConditional(&key, []dynamodb.Attribute{text.Is("x"), version.Is("5")}, other).IsVersionConflict()).To(BeTrue())
+	})
+
+	It("should read the item again to tell which condition of DynamoDB failed", func() {
+		var (
+			stored = "1"
+			reads  int
+		)
+		server, cfg := fakeEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.Header.Get("X-Amz-Target"), "GetItem") {
+				reads++
+				fmt.Fprintf(w, `{"Item":{"Id":{"S":"doc:1"},"Version":{"N":"%s"}}}`, stored)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"failed"}`)
+		}))
+		defer server.Close()
+		s, _ := dnm.MakeStore(&d, cfg)
+		other := []dynamodb.Attribute{text.Is("other")}
+		Expect(s.UpdateConditional(&key, []dynamodb.Attribute{text.Is("x"), version.Is("1")}, other).IsConditional()).To(BeTrue())
+		stored = "2"
+		Expect(s.UpdateConditional(&key, []dynamodb.Attribute{text.Is("x"), version.Is("1")}, other).IsVersionConflict()).To(BeTrue())
+		Expect(reads).To(Equal(2))
+		Expect(s.Update(&key, text.Is("x"), version.Is("1")).IsVersionConflict()).To(BeTrue())
+		Expect(reads).To(Equal(2))
+	})
+
+	It("should keep conditional errors of unversioned tables", func() {
+		plain := dnm.MakeMemStore(&dnm.TTableDescription{TableDescriptionT: dynamodb.TableDescriptionT{TableName: "Unversioned",
+			AttributeDefinitions: d.AttributeDefinitions, KeySchema: d.KeySchema}})
+		Expect(plain.Save(id.Is("doc:1"))).To(BeNil())
+		Expect(plain.DeleteConditional(&key, []dynamodb.Attribute{text.Is("draft")}).IsConditional()).To(BeTrue())
+		Expect(plain.DeleteVersioned(&key, "1").Is(dnm.DeleteErr)).To(BeTrue())
+	})
+
+	It("should declare version with struct tag", func() {
+		notes := dnm.DescribeStruct("VersionedNotes", tVersionedNote{})
+		s := dnm.MakeMemStore(&notes)
+		Expect(s.Save(id.Is("note:1"))).To(BeNil())
+		Expect(s.Save(id.Is("note:1")).IsVersionConflict()).To(BeTrue())
+	})
+})
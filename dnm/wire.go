@@ -0,0 +1,218 @@
+package dnm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flowhealth/goamz/aws"
+	"github.com/flowhealth/goamz/dynamodb"
+)
+
+/**
+Low level DynamoDB JSON API client, used for operations goamz doesn't
+expose (e.g. unprocessed batch items). Requests are signed the same way
+goamz signs them and failures are reported as *dynamodb.Error.
+*/
+
+const (
+	DynamoTargetPrefix = "DynamoDB_20120810."
+	DynamoContentType  = "application/x-amz-json-1.0"
+	DynamoServiceName  = "dynamodb"
+)
+
+type tWireValue map[string]interface{}
+type tWireItem map[string]tWireValue
+
+func (self *TStore) rpc(action string, req interface{}, resp interface{}) error {
+	return self.rpcWrite(action, true, req, resp)
+}
+
+// rpcWrite calls DynamoDB action, see retryWrite. Requests of writes which
+// aren't idempotent are not aborted by the store context, see WithContext.
+func (self *TStore) rpcWrite(action string, idempotent bool, req interface{}, resp interface{}) error {
+	ctx := self.context()
+	if !idempotent {
+		ctx = context.Background()
+	}
+	return self.retryWrite(action, idempotent, func() error {
+		return wireCall(ctx, self.dynamoServer.Auth, self.dynamoServer.Region, self.dynamoServer.Region.DynamoDBEndpoint,
+			DynamoTargetPrefix+action, req, resp)
+	})
+}
+
+func wireCall(ctx context.Context, auth aws.Auth, region aws.Region, endpoint, target string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hreq, err := http.NewRequest("POST", endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq = hreq.WithContext(ctx)
+	hreq.Header.Set("Content-Type", DynamoContentType)
+	hreq.Header.Set("X-Amz-Date", time.Now().UTC().Format(aws.ISO8601BasicFormat))
+	hreq.Header.Set("X-Amz-Target", target)
+	if token := auth.Token(); token != "" {
+		hreq.Header.Set("X-Amz-Security-Token", token)
+	}
+	signer := aws.NewV4Signer(auth, DynamoServiceName, region)
+	signer.Sign(hreq)
+
+	hresp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+	data, err := ioutil.ReadAll(hresp.Body)
+	if err != nil {
+		return err
+	}
+	if hresp.StatusCode != http.StatusOK {
+		return wireError(hresp, data)
+	}
+	if resp != nil {
+		return json.Unmarshal(data, resp)
+	}
+	return nil
+}
+
+// tWireCanceledError is a cancelled transaction, reasons are listed in order
+// of transaction items
+type tWireCanceledError struct {
+	err     *dynamodb.Error
+	reasons []tWireCancellationReason
+}
+
+type tWireCancellationReason struct {
+	Code    string
+	Message string
+}
+
+func (self *tWireCanceledError) Error() string {
+	return self.err.Error()
+}
+
+func (self *tWireCanceledError) Unwrap() error {
+	return self.err
+}
+
+func wireError(hresp *http.Response, data []byte) error {
+	var body struct {
+		Type                string `json:"__type"`
+		Message             string `json:"message"`
+		CancellationReasons []tWireCancellationReason
+	}
+	err := &dynamodb.Error{StatusCode: hresp.StatusCode, Status: hresp.Status}
+	if json.Unmarshal(data, &body) == nil {
+		// type is prefixed with API version, e.g. com.amazonaws.dynamodb.v20120810#ResourceNotFoundException
+		err.Code = body.Type[strings.LastIndex(body.Type, "#")+1:]
+		err.Message = body.Message
+	} else {
+		err.Message = string(data)
+	}
+	if len(body.CancellationReasons) > 0 {
+		return &tWireCanceledError{err, body.CancellationReasons}
+	}
+	return err
+}
+
+/**
+Attribute value codec
+*/
+
+func toWireValue(attr *dynamodb.Attribute) tWireValue {
+	if isDocType(attr.Type) {
+		return tWireValue{attr.Type: json.RawMessage(attr.Value)}
+	}
+	if isSetType(attr.Type) {
+		return tWireValue{attr.Type: attr.SetValues}
+	}
+	if attr.Type == Bool || attr.Type == Null {
+		// BOOL and NULL are JSON booleans, NULL is always true
+		return tWireValue{attr.Type: attr.Type == Null || attr.Value == NativeBoolTrue}
+	}
+	return tWireValue{attr.Type: attr.Value}
+}
+
+// needsWireCodec tells whether attributes have values goamz can't encode,
+// it supports scalar and set values only
+func needsWireCodec(attrs []dynamodb.Attribute) bool {
+	for n := range attrs {
+		switch attrs[n].Type {
+		case List, Map, Bool, Null:
+			return true
+		}
+	}
+	return false
+}
+
+func toWireItem(item map[string]*dynamodb.Attribute) tWireItem {
+	wi := tWireItem{}
+	for name, v := range item {
+		wi[name] = toWireValue(v)
+	}
+	return wi
+}
+
+func toWireAttrs(attrs []dynamodb.Attribute) tWireItem {
+	wi := tWireItem{}
+	for n := range attrs {
+		wi[attrs[n].Name] = toWireValue(&attrs[n])
+	}
+	return wi
+}
+
+func fromWireValue(name string, wv tWireValue) *dynamodb.Attribute {
+	for typ, val := range wv {
+		attr := &dynamodb.Attribute{Type: typ, Name: name}
+		if isDocType(typ) {
+			data, _ := json.Marshal(val)
+			attr.Value = string(data)
+			return attr
+		}
+		switch val := val.(type) {
+		case string:
+			attr.Value = val
+		case bool:
+			attr.Value = strconv.FormatBool(val)
+		case []string:
+			attr.SetValues = val
+		case []interface{}:
+			attr.SetValues = make([]string, 0, len(val))
+			for _, v := range val {
+				if s, ok := v.(string); ok {
+					attr.SetValues = append(attr.SetValues, s)
+				}
+			}
+		}
+		return attr
+	}
+	return nil
+}
+
+// list attributes keep elements in their wire representation
+func makeListAttr(name string, vals []dynamodb.Attribute) *dynamodb.Attribute {
+	list := make([]tWireValue, len(vals))
+	for n := range vals {
+		list[n] = toWireValue(&vals[n])
+	}
+	data, _ := json.Marshal(list)
+	return &dynamodb.Attribute{Type: List, Name: name, Value: string(data)}
+}
+
+func fromWireItem(wi tWireItem) map[string]*dynamodb.Attribute {
+	item := make(map[string]*dynamodb.Attribute, len(wi))
+	for name, wv := range wi {
+		if attr := fromWireValue(name, wv); attr != nil {
+			item[name] = attr
+		}
+	}
+	return item
+}
@@ -66,8 +66,13 @@ func main() {
 	})
 	// init store
 	cfg := dnm.MakeDefaultStoreConfig()
-	store := dnm.MakeStore(&d, cfg)
-	store.Init()
+	store, err := dnm.MakeStore(&d, cfg)
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := store.Init(); err != nil {
+		panic(err.Error())
+	}
 	// insert several records
 	{
 		sid := "sid:1"